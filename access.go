@@ -0,0 +1,221 @@
+package authority
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAccessRequestNotFound = errors.New("access request not found")
+	ErrAccessRequestPending  = errors.New("access request is already pending")
+	ErrAccessRequestDecided  = errors.New("access request is already decided")
+	ErrReasonRequired        = errors.New("reason is required")
+)
+
+// The statuses of an access request
+const (
+	RequestPending  = "pending"
+	RequestApproved = "approved"
+	RequestRejected = "rejected"
+)
+
+// The database model of a request of a user for a role
+// the requests are kept once decided, they are the audit trail of the granted access
+type AccessRequest struct {
+	ID         uint       // Unique id (it gets set automatically by the database)
+	UserID     string     `gorm:"index"` // The id of the requesting user
+	RoleSlug   string     // The slug of the requested role
+	Reason     string     // Why the user needs the role
+	Status     string     `gorm:"index"` // One of the Request* statuses
+	ApproverID string     // The id of the user who approved or rejected the request
+	Comment    string     // The comment of the approver
+	ExpiresAt  *time.Time // The expiry of the granted assignment, nil means it never expires
+	CreatedAt  time.Time  // The time of the request
+	DecidedAt  *time.Time // The time of the approval or rejection, nil while pending
+}
+
+// TableName sets the table name
+func (r AccessRequest) TableName() string {
+	return auth.TablesPrefix + "access_requests"
+}
+
+// Requests a role for a user, the request waits for an approver
+// it returns the pending request
+// it returns an error in case of any
+// in case the role does not exists, an error is returned
+// in case the reason is empty, an error is returned
+// in case the user already holds the role or has a pending request for it, an error is returned
+func (a *Authority) RequestRole(userID interface{}, roleSlug string, reason string) (request AccessRequest, err error) {
+	a, op := a.begin("RequestRole", userAttr(userID), AttrRole.String(roleSlug))
+	defer func() { op.mutated(err) }()
+
+	if reason == "" {
+		return request, ErrReasonRequired
+	}
+	userIDStr := fmt.Sprintf("%v", userID)
+	var role Role
+	res := a.DB.Where("slug = ?", roleSlug).First(&role)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return request, ErrRoleNotFound
+		}
+		return request, res.Error
+	}
+	var held int64
+	if res := a.DB.Model(UserRole{}).Scopes(active).Where("user_id = ?", userIDStr).Where("role_id = ?", role.ID).Count(&held); res.Error != nil {
+		return request, res.Error
+	}
+	if held > 0 {
+		return request, fmt.Errorf("%w: '%v'", ErrRoleAssigned, roleSlug)
+	}
+
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		var pending int64
+		res := tx.Model(AccessRequest{}).Where("user_id = ?", userIDStr).Where("role_slug = ?", roleSlug).Where("status = ?", RequestPending).Count(&pending)
+		if res.Error != nil {
+			return res.Error
+		}
+		if pending > 0 {
+			return fmt.Errorf("%w: user '%v', role '%v'", ErrAccessRequestPending, userIDStr, roleSlug)
+		}
+		request = AccessRequest{UserID: userIDStr, RoleSlug: roleSlug, Reason: reason, Status: RequestPending}
+		return tx.Create(&request).Error
+	})
+
+	return request, err
+}
+
+// Approves a pending access request and assigns the role to the user
+// the approver must hold the grant meta-permission of the role, or the assign one and every permission of the role,
+// and cannot approve its own request
+// expiresIn limits the assignment, zero means it never expires
+// it returns an error wrapping ErrActorNotAllowed in case the approver is not allowed
+// it returns an error wrapping ErrPrivilegeEscalation in case the approver does not hold the permissions of the role
+// it returns the errors of AssignRoleToUser, in which case the request stays pending
+// in case the request does not exists or is not pending, an error is returned
+func (a *Authority) ApproveRequest(approverID interface{}, requestID uint, expiresIn time.Duration) (err error) {
+	a, op := a.begin("ApproveRequest", userAttr(approverID))
+	defer func() { op.mutated(err) }()
+
+	request, err := a.pendingRequest(approverID, requestID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var expiresAt *time.Time
+	if expiresIn > 0 {
+		expiry := now.Add(expiresIn)
+		expiresAt = &expiry
+	}
+
+	// the approver is checked like AssignRoleToUserAs, in the transaction of the assignment
+	check := func(db *gorm.DB) error {
+		slugs, err := rolePermissionSlugs(db, request.RoleSlug)
+		if err != nil {
+			return err
+		}
+		return checkGrant(db, approverID, AssignPermission(request.RoleSlug), request.RoleSlug, slugs)
+	}
+
+	return a.assignRole(request.UserID, request.RoleSlug, expiresAt, check, func(db *gorm.DB) ([]Event, error) {
+		return nil, decideRequest(db, request.ID, map[string]interface{}{
+			"status":      RequestApproved,
+			"approver_id": fmt.Sprintf("%v", approverID),
+			"expires_at":  expiresAt,
+			"decided_at":  now,
+		})
+	})
+}
+
+// Rejects a pending access request
+// the approver must hold the assign or the grant meta-permission of the role
+// it returns an error wrapping ErrActorNotAllowed in case the approver is not allowed
+// in case the request does not exists or is not pending, an error is returned
+func (a *Authority) RejectRequest(approverID interface{}, requestID uint, comment string) (err error) {
+	a, op := a.begin("RejectRequest", userAttr(approverID))
+	defer func() { op.mutated(err) }()
+
+	request, err := a.pendingRequest(approverID, requestID)
+	if err != nil {
+		return err
+	}
+
+	return decideRequest(a.DB, request.ID, map[string]interface{}{
+		"status":      RequestRejected,
+		"approver_id": fmt.Sprintf("%v", approverID),
+		"comment":     comment,
+		"decided_at":  time.Now(),
+	})
+}
+
+// Returns the access requests with the given status, all of them when the status is empty, oldest first
+// it returns an error in case of any
+func (a *Authority) GetAccessRequests(status string) (requests []AccessRequest, err error) {
+	a, op := a.begin("GetAccessRequests")
+	defer func() { op.end(err) }()
+
+	query := a.DB.Order("id")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if res := query.Find(&requests); res.Error != nil {
+		return nil, res.Error
+	}
+
+	return requests, nil
+}
+
+// Returns the access requests of a user, oldest first
+// it returns an error in case of any
+func (a *Authority) GetUserAccessRequests(userID interface{}) (requests []AccessRequest, err error) {
+	a, op := a.begin("GetUserAccessRequests", userAttr(userID))
+	defer func() { op.end(err) }()
+
+	res := a.DB.Where("user_id = ?", fmt.Sprintf("%v", userID)).Order("id").Find(&requests)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return requests, nil
+}
+
+// pendingRequest returns the request if it is pending and the approver may decide it
+func (a *Authority) pendingRequest(approverID interface{}, requestID uint) (AccessRequest, error) {
+	var request AccessRequest
+	res := a.DB.Where("id = ?", requestID).First(&request)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return request, fmt.Errorf("%w: %v", ErrAccessRequestNotFound, requestID)
+		}
+		return request, res.Error
+	}
+	if request.Status != RequestPending {
+		return request, fmt.Errorf("%w: %v is %v", ErrAccessRequestDecided, requestID, request.Status)
+	}
+	if fmt.Sprintf("%v", approverID) == request.UserID {
+		return request, fmt.Errorf("%w: actor '%v' cannot decide its own request", ErrActorNotAllowed, approverID)
+	}
+	if err := a.checkMeta(approverID, AssignPermission(request.RoleSlug), GrantPermission(request.RoleSlug)); err != nil {
+		return request, err
+	}
+
+	return request, nil
+}
+
+// decideRequest moves a pending request to its decided status
+// it fails if another approver decided the request first
+func decideRequest(db *gorm.DB, requestID uint, decision map[string]interface{}) error {
+	res := db.Model(AccessRequest{}).Where("id = ?", requestID).Where("status = ?", RequestPending).Updates(decision)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("%w: %v", ErrAccessRequestDecided, requestID)
+	}
+
+	return nil
+}
@@ -0,0 +1,439 @@
+// Package admin provides a server rendered web interface for managing
+// authority roles, permissions and user assignments
+//
+// The handler does not authenticate its users, mount it behind your own
+// authentication, for example using the httpauthz middleware
+package admin
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/harranali/authority"
+)
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+const csrfCookie = "authority_csrf"
+
+// Options has the options for initiating the admin handler
+type Options struct {
+	Authority *authority.Authority // The authority instance to manage
+	BasePath  string               // The path the handler is mounted at, for example "/admin"
+	Secret    []byte               // Key signing the csrf tokens, a random one is generated if empty
+	// Actor returns the id of the logged in user, optional
+	// when set, the changes are made on behalf of the user and require its meta-permissions,
+	// requests without a user are rejected with 401
+	Actor func(r *http.Request) (interface{}, bool)
+}
+
+type handler struct {
+	auth     *authority.Authority
+	actor    func(r *http.Request) (interface{}, bool)
+	basePath string
+	secret   []byte
+	pages    map[string]*template.Template
+	mux      *http.ServeMux
+}
+
+// New returns the admin http handler
+// when mounted under a path, strip the path before calling the handler and set it as the BasePath
+//
+//	mux.Handle("/admin/", http.StripPrefix("/admin", admin.New(admin.Options{
+//		Authority: auth,
+//		BasePath:  "/admin",
+//	})))
+func New(opts Options) http.Handler {
+	h := &handler{
+		auth:     opts.Authority,
+		actor:    opts.Actor,
+		basePath: strings.TrimSuffix(opts.BasePath, "/"),
+		secret:   opts.Secret,
+		pages:    map[string]*template.Template{},
+		mux:      http.NewServeMux(),
+	}
+	if len(h.secret) == 0 {
+		h.secret = make([]byte, 32)
+		rand.Read(h.secret)
+	}
+
+	funcs := template.FuncMap{
+		"path": func(parts ...string) string {
+			for i, part := range parts {
+				parts[i] = url.PathEscape(part)
+			}
+			return h.basePath + "/" + strings.Join(parts, "/")
+		},
+	}
+	for _, page := range []string{"index", "roles", "role", "permissions", "user", "matrix", "error"} {
+		h.pages[page] = template.Must(template.New("layout.html").Funcs(funcs).
+			ParseFS(templatesFS, "templates/layout.html", "templates/"+page+".html"))
+	}
+
+	h.mux.HandleFunc("GET /{$}", h.index)
+	h.mux.HandleFunc("GET /roles", h.roles)
+	h.mux.HandleFunc("POST /roles", h.createRole)
+	h.mux.HandleFunc("GET /roles/{slug}", h.role)
+	h.mux.HandleFunc("POST /roles/{slug}/delete", h.deleteRole)
+	h.mux.HandleFunc("POST /roles/{slug}/permissions", h.grantPermission)
+	h.mux.HandleFunc("POST /roles/{slug}/permissions/{perm}/revoke", h.revokePermission)
+	h.mux.HandleFunc("GET /permissions", h.permissions)
+	h.mux.HandleFunc("POST /permissions", h.createPermission)
+	h.mux.HandleFunc("POST /permissions/{slug}/delete", h.deletePermission)
+	h.mux.HandleFunc("GET /users", h.user)
+	h.mux.HandleFunc("POST /users/{id}/roles", h.assignRole)
+	h.mux.HandleFunc("POST /users/{id}/roles/{role}/revoke", h.revokeRole)
+	h.mux.HandleFunc("GET /matrix", h.matrix)
+
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'unsafe-inline'")
+	if r.Method == http.MethodPost && !h.validCSRF(r) {
+		h.fail(w, r, http.StatusForbidden, "invalid or missing csrf token, reload the page and try again")
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// csrfToken returns the token to embed in the forms of the page
+// the token is the signature of a random value kept in a cookie
+func (h *handler) csrfToken(w http.ResponseWriter, r *http.Request) string {
+	var nonce string
+	if c, err := r.Cookie(csrfCookie); err == nil && len(c.Value) == 64 {
+		nonce = c.Value
+	} else {
+		b := make([]byte, 32)
+		rand.Read(b)
+		nonce = hex.EncodeToString(b)
+		http.SetCookie(w, &http.Cookie{
+			Name:     csrfCookie,
+			Value:    nonce,
+			Path:     h.basePath + "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+
+	return h.sign(nonce)
+}
+
+func (h *handler) validCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(r.PostFormValue("csrf_token")), []byte(h.sign(c.Value)))
+}
+
+func (h *handler) sign(nonce string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *handler) render(w http.ResponseWriter, r *http.Request, page string, data map[string]interface{}) {
+	h.renderStatus(w, r, http.StatusOK, page, data)
+}
+
+// renderStatus sets the headers and the csrf cookie before writing the status and the page
+func (h *handler) renderStatus(w http.ResponseWriter, r *http.Request, code int, page string, data map[string]interface{}) {
+	data["CSRF"] = h.csrfToken(w, r)
+	var buf bytes.Buffer
+	if err := h.pages[page].Execute(&buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
+
+func (h *handler) fail(w http.ResponseWriter, r *http.Request, code int, msg string) {
+	h.renderStatus(w, r, code, "error", map[string]interface{}{"Title": http.StatusText(code), "Message": msg})
+}
+
+// failChange renders the error of a change, 403 when the actor is not allowed to make it
+func (h *handler) failChange(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusBadRequest
+	if errors.Is(err, authority.ErrActorNotAllowed) || errors.Is(err, authority.ErrPrivilegeEscalation) {
+		code = http.StatusForbidden
+	}
+	h.fail(w, r, code, err.Error())
+}
+
+// manager returns the manager making the changes of the request
+// on behalf of the logged in user when the Actor option is set
+func (h *handler) manager(w http.ResponseWriter, r *http.Request) (authority.Manager, bool) {
+	if h.actor == nil {
+		return h.auth, true
+	}
+	actorID, ok := h.actor(r)
+	if !ok {
+		h.fail(w, r, http.StatusUnauthorized, "log in to make changes")
+		return nil, false
+	}
+
+	return h.auth.As(actorID), true
+}
+
+func (h *handler) redirect(w http.ResponseWriter, r *http.Request, parts ...string) {
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	http.Redirect(w, r, h.basePath+"/"+strings.Join(parts, "/"), http.StatusSeeOther)
+}
+
+func (h *handler) index(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.auth.GetAllRoles()
+	if err != nil {
+		h.fail(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	perms, err := h.auth.GetAllPermissions()
+	if err != nil {
+		h.fail(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.render(w, r, "index", map[string]interface{}{"Title": "Authority", "Roles": len(roles), "Permissions": len(perms)})
+}
+
+func (h *handler) roles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.auth.GetAllRoles()
+	if err != nil {
+		h.fail(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.render(w, r, "roles", map[string]interface{}{"Title": "Roles", "Roles": roles})
+}
+
+func (h *handler) createRole(w http.ResponseWriter, r *http.Request) {
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	err := m.CreateRole(authority.Role{Name: r.PostFormValue("name"), Slug: r.PostFormValue("slug")})
+	if err != nil {
+		h.failChange(w, r, err)
+		return
+	}
+	h.redirect(w, r, "roles", r.PostFormValue("slug"))
+}
+
+func (h *handler) role(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	users, err := h.auth.GetRoleUsers(slug)
+	if errors.Is(err, authority.ErrRoleNotFound) {
+		h.fail(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		h.fail(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	granted, err := h.auth.GetRolePermissions(slug)
+	if err != nil {
+		h.fail(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	all, err := h.auth.GetAllPermissions()
+	if err != nil {
+		h.fail(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	grantedSlugs := map[string]bool{}
+	for _, perm := range granted {
+		grantedSlugs[perm.Slug] = true
+	}
+	var grantable []authority.Permission
+	for _, perm := range all {
+		if !grantedSlugs[perm.Slug] {
+			grantable = append(grantable, perm)
+		}
+	}
+	h.render(w, r, "role", map[string]interface{}{
+		"Title":       "Role " + slug,
+		"Slug":        slug,
+		"Permissions": granted,
+		"Grantable":   grantable,
+		"Users":       users,
+	})
+}
+
+func (h *handler) deleteRole(w http.ResponseWriter, r *http.Request) {
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	if err := m.DeleteRole(r.PathValue("slug")); err != nil {
+		h.failChange(w, r, err)
+		return
+	}
+	h.redirect(w, r, "roles")
+}
+
+func (h *handler) grantPermission(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	if err := m.AssignPermissionsToRole(slug, []string{r.PostFormValue("permission")}); err != nil {
+		h.failChange(w, r, err)
+		return
+	}
+	h.redirect(w, r, "roles", slug)
+}
+
+func (h *handler) revokePermission(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	if err := m.RevokeRolePermission(slug, r.PathValue("perm")); err != nil {
+		h.failChange(w, r, err)
+		return
+	}
+	h.redirect(w, r, "roles", slug)
+}
+
+func (h *handler) permissions(w http.ResponseWriter, r *http.Request) {
+	perms, err := h.auth.GetAllPermissions()
+	if err != nil {
+		h.fail(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.render(w, r, "permissions", map[string]interface{}{"Title": "Permissions", "Permissions": perms})
+}
+
+func (h *handler) createPermission(w http.ResponseWriter, r *http.Request) {
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	err := m.CreatePermission(authority.Permission{Name: r.PostFormValue("name"), Slug: r.PostFormValue("slug")})
+	if err != nil {
+		h.failChange(w, r, err)
+		return
+	}
+	h.redirect(w, r, "permissions")
+}
+
+func (h *handler) deletePermission(w http.ResponseWriter, r *http.Request) {
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	if err := m.DeletePermission(r.PathValue("slug")); err != nil {
+		h.failChange(w, r, err)
+		return
+	}
+	h.redirect(w, r, "permissions")
+}
+
+func (h *handler) user(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	data := map[string]interface{}{"Title": "Users", "ID": id}
+	if id != "" {
+		roles, err := h.auth.GetUserRoles(id)
+		if err != nil {
+			h.fail(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		all, err := h.auth.GetAllRoles()
+		if err != nil {
+			h.fail(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		held := map[string]bool{}
+		for _, role := range roles {
+			held[role.Slug] = true
+		}
+		var assignable []authority.Role
+		for _, role := range all {
+			if !held[role.Slug] {
+				assignable = append(assignable, role)
+			}
+		}
+		data["Title"] = "User " + id
+		data["Roles"] = roles
+		data["Assignable"] = assignable
+	}
+	h.render(w, r, "user", data)
+}
+
+func (h *handler) assignRole(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	if err := m.AssignRoleToUser(id, r.PostFormValue("role")); err != nil {
+		h.failChange(w, r, err)
+		return
+	}
+	http.Redirect(w, r, h.basePath+"/users?id="+url.QueryEscape(id), http.StatusSeeOther)
+}
+
+func (h *handler) revokeRole(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	if err := m.RevokeUserRole(id, r.PathValue("role")); err != nil {
+		h.failChange(w, r, err)
+		return
+	}
+	http.Redirect(w, r, h.basePath+"/users?id="+url.QueryEscape(id), http.StatusSeeOther)
+}
+
+func (h *handler) matrix(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.auth.GetAllRoles()
+	if err != nil {
+		h.fail(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	perms, err := h.auth.GetAllPermissions()
+	if err != nil {
+		h.fail(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	type row struct {
+		Permission authority.Permission
+		Granted    []bool
+	}
+	rows := make([]row, len(perms))
+	for i, perm := range perms {
+		rows[i] = row{Permission: perm, Granted: make([]bool, len(roles))}
+	}
+	index := map[string]int{}
+	for i, perm := range perms {
+		index[perm.Slug] = i
+	}
+	for j, role := range roles {
+		granted, err := h.auth.GetRolePermissions(role.Slug)
+		if err != nil {
+			h.fail(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, perm := range granted {
+			rows[index[perm.Slug]].Granted[j] = true
+		}
+	}
+	h.render(w, r, "matrix", map[string]interface{}{"Title": "Role permission matrix", "Roles": roles, "Rows": rows})
+}
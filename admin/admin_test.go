@@ -0,0 +1,185 @@
+package admin_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/harranali/authority"
+	"github.com/harranali/authority/admin"
+	"github.com/harranali/authority/internal/testdb"
+)
+
+var csrfRe = regexp.MustCompile(`name="csrf_token" value="([0-9a-f]+)"`)
+
+func setup(t *testing.T) (*authority.Authority, *httptest.Server, *http.Client) {
+	return setupWith(t, admin.Options{})
+}
+
+func setupWith(t *testing.T, opts admin.Options) (*authority.Authority, *httptest.Server, *http.Client) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           testdb.SQLite(t),
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission A", Slug: "permission-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission B", Slug: "permission-b"})
+	auth.AssignPermissionsToRole("role-a", []string{"permission-a"})
+	auth.AssignRoleToUser(1, "role-a")
+
+	mux := http.NewServeMux()
+	opts.Authority = auth
+	opts.BasePath = "/admin"
+	mux.Handle("/admin/", http.StripPrefix("/admin", admin.New(opts)))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	jar, _ := cookiejar.New(nil)
+	return auth, srv, &http.Client{Jar: jar}
+}
+
+func get(t *testing.T, client *http.Client, u string) (int, string) {
+	res, err := client.Get(u)
+	if err != nil {
+		t.Fatal("failed to get", u, err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	return res.StatusCode, string(body)
+}
+
+func TestPages(t *testing.T) {
+	_, srv, client := setup(t)
+
+	pages := map[string][]string{
+		"/admin/":             {"1 roles", "2 permissions"},
+		"/admin/roles":        {"role-a", "Role A"},
+		"/admin/roles/role-a": {"permission-a", `<option value="permission-b">`, "/admin/users?id=1"},
+		"/admin/permissions":  {"permission-a", "permission-b"},
+		"/admin/users?id=1":   {"role-a", "/admin/users/1/roles/role-a/revoke"},
+		"/admin/matrix":       {"role-a", "permission-b", "&#10003;"},
+	}
+	for page, expected := range pages {
+		code, body := get(t, client, srv.URL+page)
+		if code != http.StatusOK {
+			t.Error("unexpected status for", page, code)
+		}
+		for _, s := range expected {
+			if !strings.Contains(body, s) {
+				t.Errorf("expected %v to contain %v", page, s)
+			}
+		}
+	}
+
+	res, err := http.Get(srv.URL + "/admin/roles/role-x")
+	if err != nil {
+		t.Fatal("failed to get the unknown role", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Error("expected an unknown role to be not found, got", res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Error("expected the error page to be html, got", ct)
+	}
+	if len(res.Cookies()) == 0 {
+		t.Error("expected the error page to set the csrf cookie")
+	}
+}
+
+func TestForms(t *testing.T) {
+	auth, srv, client := setup(t)
+
+	_, body := get(t, client, srv.URL+"/admin/roles/role-a")
+	m := csrfRe.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatal("expected the page to contain a csrf token")
+	}
+	token := m[1]
+
+	// without a token
+	res, _ := client.PostForm(srv.URL+"/admin/roles/role-a/permissions", url.Values{"permission": {"permission-b"}})
+	if res.StatusCode != http.StatusForbidden {
+		t.Error("expected the request without a token to be forbidden, got", res.StatusCode)
+	}
+	// without the cookie
+	res, _ = http.PostForm(srv.URL+"/admin/roles/role-a/permissions", url.Values{"permission": {"permission-b"}, "csrf_token": {token}})
+	if res.StatusCode != http.StatusForbidden {
+		t.Error("expected the request without the cookie to be forbidden, got", res.StatusCode)
+	}
+	if ok, _ := auth.CheckRolePermission("role-a", "permission-b"); ok {
+		t.Error("the forbidden requests should not grant the permission")
+	}
+
+	res, _ = client.PostForm(srv.URL+"/admin/roles/role-a/permissions", url.Values{"permission": {"permission-b"}, "csrf_token": {token}})
+	if res.StatusCode != http.StatusOK || res.Request.URL.Path != "/admin/roles/role-a" {
+		t.Error("expected a redirect to the role page", res.StatusCode, res.Request.URL)
+	}
+	if ok, _ := auth.CheckRolePermission("role-a", "permission-b"); !ok {
+		t.Error("expected the permission to be granted")
+	}
+
+	client.PostForm(srv.URL+"/admin/users/1/roles/role-a/revoke", url.Values{"csrf_token": {token}})
+	if ok, _ := auth.CheckUserRole(1, "role-a"); ok {
+		t.Error("expected the role to be revoked")
+	}
+
+	client.PostForm(srv.URL+"/admin/users/2/roles", url.Values{"role": {"role-a"}, "csrf_token": {token}})
+	if ok, _ := auth.CheckUserRole(2, "role-a"); !ok {
+		t.Error("expected the role to be assigned")
+	}
+
+	res, _ = client.PostForm(srv.URL+"/admin/roles", url.Values{"slug": {"role-a"}, "name": {"Role A"}, "csrf_token": {token}})
+	if res.StatusCode != http.StatusBadRequest {
+		t.Error("expected creating a duplicated role to fail, got", res.StatusCode)
+	}
+}
+
+func TestActor(t *testing.T) {
+	auth, srv, client := setupWith(t, admin.Options{
+		Actor: func(r *http.Request) (interface{}, bool) {
+			id := r.Header.Get("X-User")
+			return id, id != ""
+		},
+	})
+	auth.EnsureMetaPermissions()
+	auth.CreateRole(authority.Role{Name: "Manager", Slug: "manager"})
+	auth.AssignPermissionsToRole("manager", []string{authority.PermissionRolesManage})
+	auth.AssignRoleToUser("3", "manager")
+
+	_, body := get(t, client, srv.URL+"/admin/roles")
+	token := csrfRe.FindStringSubmatch(body)[1]
+	post := func(user string) int {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/admin/roles",
+			strings.NewReader(url.Values{"slug": {"role-" + user}, "name": {"Role"}, "csrf_token": {token}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-User", user)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal("failed to post", err)
+		}
+		res.Body.Close()
+		if res.Request.Method == http.MethodGet {
+			return http.StatusSeeOther
+		}
+		return res.StatusCode
+	}
+
+	if code := post(""); code != http.StatusUnauthorized {
+		t.Error("expected a change without a user to be unauthorized, got", code)
+	}
+	if code := post("2"); code != http.StatusForbidden {
+		t.Error("expected a change by a user without the meta-permission to be forbidden, got", code)
+	}
+	if code := post("3"); code != http.StatusSeeOther {
+		t.Error("expected the manager to create the role, got", code)
+	}
+	if _, err := auth.GetRoleUsers("role-3"); err != nil {
+		t.Error("expected the role to be created", err)
+	}
+}
@@ -0,0 +1,378 @@
+// Package api provides a json http api for managing and querying authority
+// roles, permissions and assignments, described by an OpenAPI document
+//
+// The handler does not authenticate its callers, mount it behind your own
+// authentication, for example using the httpauthz middleware
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/harranali/authority"
+)
+
+// Options has the options for initiating the api handler
+type Options struct {
+	Authority *authority.Authority // The authority instance to expose
+	BasePath  string               // The path the handler is mounted at, used as the server url of the OpenAPI document
+	// Actor returns the id of the authenticated caller, optional
+	// when set, the changes are made on behalf of the caller and require its meta-permissions,
+	// requests without a caller are rejected with 401
+	Actor func(r *http.Request) (interface{}, bool)
+	// Logger receives the unexpected errors, their details are not sent to the caller
+	// defaults to slog.Default()
+	Logger *slog.Logger
+}
+
+// Role is the json form of a role
+type Role struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// Permission is the json form of a permission
+type Permission struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// GrantRequest is the body of a permissions grant
+type GrantRequest struct {
+	Permissions []string `json:"permissions"`
+}
+
+// AssignRequest is the body of a role assignment
+type AssignRequest struct {
+	Role string `json:"role"`
+}
+
+// CheckResponse is the result of a check
+type CheckResponse struct {
+	Allowed bool `json:"allowed"`
+}
+
+// ExplainResponse is the result of an explain
+type ExplainResponse struct {
+	UserID     string `json:"user_id"`
+	Permission string `json:"permission"`
+	Allowed    bool   `json:"allowed"`
+	Roles      []Role `json:"roles"`
+}
+
+// ErrorResponse is the body of every failed request
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type handler struct {
+	auth   *authority.Authority
+	actor  func(r *http.Request) (interface{}, bool)
+	logger *slog.Logger
+	spec   []byte
+	mux    *http.ServeMux
+}
+
+// New returns the api http handler
+// when mounted under a path, strip the path before calling the handler
+func New(opts Options) http.Handler {
+	h := &handler{
+		auth:   opts.Authority,
+		actor:  opts.Actor,
+		logger: opts.Logger,
+		spec:   Spec(opts.BasePath),
+		mux:    http.NewServeMux(),
+	}
+	if h.logger == nil {
+		h.logger = slog.Default()
+	}
+	for _, e := range endpoints {
+		e := e
+		h.mux.HandleFunc(e.method+" "+e.path, func(w http.ResponseWriter, r *http.Request) {
+			e.handle(h, w, r)
+		})
+	}
+	h.mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(h.spec)
+	})
+
+	return h.mux
+}
+
+func (h *handler) listRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.auth.GetAllRoles()
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toRoles(roles))
+}
+
+func (h *handler) createRole(w http.ResponseWriter, r *http.Request) {
+	var body Role
+	if !readJSON(w, r, &body) {
+		return
+	}
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	if err := m.CreateRole(authority.Role{Name: body.Name, Slug: body.Slug}); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, body)
+}
+
+func (h *handler) deleteRole(w http.ResponseWriter, r *http.Request) {
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	if err := m.DeleteRole(r.PathValue("slug")); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) listPermissions(w http.ResponseWriter, r *http.Request) {
+	perms, err := h.auth.GetAllPermissions()
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toPermissions(perms))
+}
+
+func (h *handler) createPermission(w http.ResponseWriter, r *http.Request) {
+	var body Permission
+	if !readJSON(w, r, &body) {
+		return
+	}
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	if err := m.CreatePermission(authority.Permission{Name: body.Name, Slug: body.Slug}); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, body)
+}
+
+func (h *handler) deletePermission(w http.ResponseWriter, r *http.Request) {
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	if err := m.DeletePermission(r.PathValue("slug")); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) listRolePermissions(w http.ResponseWriter, r *http.Request) {
+	perms, err := h.auth.GetRolePermissions(r.PathValue("slug"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toPermissions(perms))
+}
+
+func (h *handler) grantPermissions(w http.ResponseWriter, r *http.Request) {
+	var body GrantRequest
+	if !readJSON(w, r, &body) {
+		return
+	}
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	if err := m.AssignPermissionsToRole(r.PathValue("slug"), body.Permissions); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) revokePermission(w http.ResponseWriter, r *http.Request) {
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	if err := m.RevokeRolePermission(r.PathValue("slug"), r.PathValue("permission")); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) listRoleUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.auth.GetRoleUsers(r.PathValue("slug"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	if users == nil {
+		users = []string{}
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (h *handler) listUserRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.auth.GetUserRoles(r.PathValue("id"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toRoles(roles))
+}
+
+func (h *handler) assignRole(w http.ResponseWriter, r *http.Request) {
+	var body AssignRequest
+	if !readJSON(w, r, &body) {
+		return
+	}
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	if err := m.AssignRoleToUser(r.PathValue("id"), body.Role); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) revokeRole(w http.ResponseWriter, r *http.Request) {
+	m, ok := h.manager(w, r)
+	if !ok {
+		return
+	}
+	if err := m.RevokeUserRole(r.PathValue("id"), r.PathValue("role")); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) check(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	userID, role, perm := q.Get("user_id"), q.Get("role"), q.Get("permission")
+
+	var ok bool
+	var err error
+	switch {
+	case userID != "" && perm != "" && role == "":
+		ok, err = h.auth.CheckUserPermission(userID, perm)
+	case userID != "" && role != "" && perm == "":
+		ok, err = h.auth.CheckUserRole(userID, role)
+	case userID == "" && role != "" && perm != "":
+		ok, err = h.auth.CheckRolePermission(role, perm)
+	default:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "expected two of user_id, role and permission"})
+		return
+	}
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, CheckResponse{Allowed: ok})
+}
+
+func (h *handler) explain(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("user_id") == "" || q.Get("permission") == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "expected user_id and permission"})
+		return
+	}
+	e, err := h.auth.ExplainUserPermission(q.Get("user_id"), q.Get("permission"))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ExplainResponse{
+		UserID:     e.UserID,
+		Permission: e.Permission,
+		Allowed:    e.Allowed,
+		Roles:      toRoles(e.Roles),
+	})
+}
+
+func toRoles(roles []authority.Role) []Role {
+	res := make([]Role, len(roles))
+	for i, role := range roles {
+		res[i] = Role{Name: role.Name, Slug: role.Slug}
+	}
+	return res
+}
+
+func toPermissions(perms []authority.Permission) []Permission {
+	res := make([]Permission, len(perms))
+	for i, perm := range perms {
+		res[i] = Permission{Name: perm.Name, Slug: perm.Slug}
+	}
+	return res
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// manager returns the manager making the changes of the request
+// on behalf of the caller when the Actor option is set
+func (h *handler) manager(w http.ResponseWriter, r *http.Request) (authority.Manager, bool) {
+	if h.actor == nil {
+		return h.auth, true
+	}
+	actorID, ok := h.actor(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "unauthenticated"})
+		return nil, false
+	}
+
+	return h.auth.As(actorID), true
+}
+
+// writeError maps the authority errors to http status codes
+// the unexpected errors are logged and answered with a generic message
+func (h *handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, authority.ErrRoleNotFound), errors.Is(err, authority.ErrPermissionNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, authority.ErrRoleExists), errors.Is(err, authority.ErrPermissionExists),
+		errors.Is(err, authority.ErrRoleAssigned), errors.Is(err, authority.ErrPermissionAssigned),
+		errors.Is(err, authority.ErrRoleInUse), errors.Is(err, authority.ErrPermissionInUse),
+		errors.Is(err, authority.ErrSoDViolation),
+		errors.Is(err, authority.ErrRoleHoldersExceeded), errors.Is(err, authority.ErrUserRolesExceeded),
+		errors.Is(err, authority.ErrRoleProtected), errors.Is(err, authority.ErrPermissionProtected):
+		code = http.StatusConflict
+	case errors.Is(err, authority.ErrPrivilegeEscalation), errors.Is(err, authority.ErrActorNotAllowed):
+		code = http.StatusForbidden
+	}
+	if code == http.StatusInternalServerError {
+		h.logger.ErrorContext(r.Context(), "authority api request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		writeJSON(w, code, ErrorResponse{Error: "internal error"})
+		return
+	}
+	writeJSON(w, code, ErrorResponse{Error: err.Error()})
+}
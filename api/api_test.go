@@ -0,0 +1,192 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/harranali/authority"
+	"github.com/harranali/authority/api"
+	"github.com/harranali/authority/internal/testdb"
+)
+
+func setup(t *testing.T) (*authority.Authority, http.Handler) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           testdb.SQLite(t),
+	})
+
+	return auth, api.New(api.Options{Authority: auth})
+}
+
+func do(h http.Handler, method string, path string, body string) (int, string) {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, r))
+	return w.Code, strings.TrimSpace(w.Body.String())
+}
+
+func TestManagement(t *testing.T) {
+	auth, h := setup(t)
+
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"POST", "/roles", `{"name": "Role A", "slug": "role-a"}`, http.StatusCreated},
+		{"POST", "/roles", `{"name": "Role A", "slug": "role-a"}`, http.StatusConflict},
+		{"POST", "/roles", `{"title": "Role A"}`, http.StatusBadRequest},
+		{"POST", "/permissions", `{"name": "Permission A", "slug": "permission-a"}`, http.StatusCreated},
+		{"POST", "/permissions", `{"name": "Permission B", "slug": "permission-b"}`, http.StatusCreated},
+		{"POST", "/roles/role-a/permissions", `{"permissions": ["permission-a", "permission-b"]}`, http.StatusNoContent},
+		{"POST", "/roles/role-x/permissions", `{"permissions": ["permission-a"]}`, http.StatusNotFound},
+		{"DELETE", "/roles/role-a/permissions/permission-b", "", http.StatusNoContent},
+		{"POST", "/users/1/roles", `{"role": "role-a"}`, http.StatusNoContent},
+		{"POST", "/users/1/roles", `{"role": "role-a"}`, http.StatusConflict},
+		{"DELETE", "/roles/role-a", "", http.StatusConflict},
+		{"DELETE", "/permissions/permission-b", "", http.StatusNoContent},
+		{"DELETE", "/permissions/permission-x", "", http.StatusNotFound},
+	}
+	for _, step := range steps {
+		code, body := do(h, step.method, step.path, step.body)
+		if code != step.code {
+			t.Errorf("%v %v: expected %v, got %v %v", step.method, step.path, step.code, code, body)
+		}
+	}
+
+	if ok, _ := auth.CheckUserPermission(1, "permission-a"); !ok {
+		t.Error("expected the user to have the permission")
+	}
+
+	queries := map[string]string{
+		"/roles":                    `[{"name":"Role A","slug":"role-a"}]`,
+		"/roles/role-a/permissions": `[{"name":"Permission A","slug":"permission-a"}]`,
+		"/roles/role-a/users":       `["1"]`,
+		"/users/1/roles":            `[{"name":"Role A","slug":"role-a"}]`,
+		"/users/2/roles":            `[]`,
+	}
+	for path, expected := range queries {
+		code, body := do(h, "GET", path, "")
+		if code != http.StatusOK || body != expected {
+			t.Errorf("GET %v: unexpected response %v %v", path, code, body)
+		}
+	}
+	if code, _ := do(h, "GET", "/roles/role-x/permissions", ""); code != http.StatusNotFound {
+		t.Error("expected an unknown role to be not found, got", code)
+	}
+}
+
+func TestInternalError(t *testing.T) {
+	auth, h := setup(t)
+	auth.DB.Migrator().DropTable(authority.Role{})
+
+	code, body := do(h, "GET", "/roles", "")
+	if code != http.StatusInternalServerError || body != `{"error":"internal error"}` {
+		t.Error("expected a generic internal error, got", code, body)
+	}
+}
+
+func TestCheckAndExplain(t *testing.T) {
+	auth, h := setup(t)
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission A", Slug: "permission-a"})
+	auth.AssignPermissionsToRole("role-a", []string{"permission-a"})
+	auth.AssignRoleToUser(1, "role-a")
+
+	checks := map[string]string{
+		"/check?user_id=1&permission=permission-a":   `{"allowed":true}`,
+		"/check?user_id=2&permission=permission-a":   `{"allowed":false}`,
+		"/check?user_id=1&role=role-a":               `{"allowed":true}`,
+		"/check?role=role-a&permission=permission-a": `{"allowed":true}`,
+		"/explain?user_id=1&permission=permission-a": `{"user_id":"1","permission":"permission-a","allowed":true,"roles":[{"name":"Role A","slug":"role-a"}]}`,
+	}
+	for path, expected := range checks {
+		code, body := do(h, "GET", path, "")
+		if code != http.StatusOK || body != expected {
+			t.Errorf("GET %v: unexpected response %v %v", path, code, body)
+		}
+	}
+
+	if code, _ := do(h, "GET", "/check?user_id=1", ""); code != http.StatusBadRequest {
+		t.Error("expected a bad request for a partial check, got", code)
+	}
+	if code, _ := do(h, "GET", "/check?user_id=1&permission=permission-x", ""); code != http.StatusNotFound {
+		t.Error("expected an unknown permission to be not found, got", code)
+	}
+}
+
+func TestActor(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           testdb.SQLite(t),
+	})
+	h := api.New(api.Options{
+		Authority: auth,
+		Actor: func(r *http.Request) (interface{}, bool) {
+			id := r.Header.Get("X-User")
+			return id, id != ""
+		},
+	})
+	auth.CreateRole(authority.Role{Name: "Owner", Slug: "owner"})
+	auth.CreateRole(authority.Role{Name: "Editor", Slug: "editor"})
+	auth.CreateRole(authority.Role{Name: "Tenant Admin", Slug: "tenant-admin"})
+	auth.EnsureMetaPermissions()
+	auth.AssignPermissionsToRole("tenant-admin", []string{authority.AssignPermission("editor")})
+	auth.AssignRoleToUser(1, "tenant-admin")
+
+	steps := []struct {
+		actor  string
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"", "POST", "/users/2/roles", `{"role": "editor"}`, http.StatusUnauthorized},
+		{"1", "POST", "/users/2/roles", `{"role": "editor"}`, http.StatusNoContent},
+		{"1", "POST", "/users/2/roles", `{"role": "owner"}`, http.StatusForbidden},
+		{"1", "POST", "/roles", `{"name": "Viewer", "slug": "viewer"}`, http.StatusForbidden},
+		{"1", "DELETE", "/users/2/roles/editor", "", http.StatusNoContent},
+	}
+	for _, step := range steps {
+		var r io.Reader
+		if step.body != "" {
+			r = strings.NewReader(step.body)
+		}
+		req := httptest.NewRequest(step.method, step.path, r)
+		if step.actor != "" {
+			req.Header.Set("X-User", step.actor)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != step.code {
+			t.Errorf("%v %v as '%v': expected %v, got %v %v", step.method, step.path, step.actor, step.code, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	_, h := setup(t)
+
+	code, body := do(h, "GET", "/openapi.json", "")
+	if code != http.StatusOK {
+		t.Fatal("expected the OpenAPI document, got", code)
+	}
+	var doc struct {
+		OpenAPI string                            `json:"openapi"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		t.Fatal("expected a valid json document", err)
+	}
+	if doc.OpenAPI == "" || doc.Paths["/roles/{slug}/permissions"]["post"] == nil || doc.Paths["/explain"]["get"] == nil {
+		t.Error("expected the document to describe the routes", body)
+	}
+}
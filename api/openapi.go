@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// endpoint describes a route, it is used both to register the route and to generate its OpenAPI operation
+type endpoint struct {
+	id       string // The OpenAPI operation id
+	method   string
+	path     string
+	summary  string
+	query    []string    // Required query parameters
+	optional []string    // Optional query parameters
+	body     interface{} // The request body, nil if none
+	response interface{} // The response body, nil for 204 responses
+	status   int         // The status of the successful response
+	handle   func(h *handler, w http.ResponseWriter, r *http.Request)
+}
+
+var endpoints = []endpoint{
+	{id: "listRoles", method: "GET", path: "/roles", summary: "List all roles", response: []Role{}, status: http.StatusOK, handle: (*handler).listRoles},
+	{id: "createRole", method: "POST", path: "/roles", summary: "Create a role", body: Role{}, response: Role{}, status: http.StatusCreated, handle: (*handler).createRole},
+	{id: "deleteRole", method: "DELETE", path: "/roles/{slug}", summary: "Delete a role and revoke its permissions", status: http.StatusNoContent, handle: (*handler).deleteRole},
+	{id: "listRolePermissions", method: "GET", path: "/roles/{slug}/permissions", summary: "List the permissions of a role", response: []Permission{}, status: http.StatusOK, handle: (*handler).listRolePermissions},
+	{id: "grantPermissions", method: "POST", path: "/roles/{slug}/permissions", summary: "Grant permissions to a role", body: GrantRequest{}, status: http.StatusNoContent, handle: (*handler).grantPermissions},
+	{id: "revokePermission", method: "DELETE", path: "/roles/{slug}/permissions/{permission}", summary: "Revoke a permission from a role", status: http.StatusNoContent, handle: (*handler).revokePermission},
+	{id: "listRoleUsers", method: "GET", path: "/roles/{slug}/users", summary: "List the ids of the users having a role", response: []string{}, status: http.StatusOK, handle: (*handler).listRoleUsers},
+	{id: "listPermissions", method: "GET", path: "/permissions", summary: "List all permissions", response: []Permission{}, status: http.StatusOK, handle: (*handler).listPermissions},
+	{id: "createPermission", method: "POST", path: "/permissions", summary: "Create a permission", body: Permission{}, response: Permission{}, status: http.StatusCreated, handle: (*handler).createPermission},
+	{id: "deletePermission", method: "DELETE", path: "/permissions/{slug}", summary: "Delete a permission", status: http.StatusNoContent, handle: (*handler).deletePermission},
+	{id: "listUserRoles", method: "GET", path: "/users/{id}/roles", summary: "List the roles of a user", response: []Role{}, status: http.StatusOK, handle: (*handler).listUserRoles},
+	{id: "assignRole", method: "POST", path: "/users/{id}/roles", summary: "Assign a role to a user", body: AssignRequest{}, status: http.StatusNoContent, handle: (*handler).assignRole},
+	{id: "revokeRole", method: "DELETE", path: "/users/{id}/roles/{role}", summary: "Revoke a role from a user", status: http.StatusNoContent, handle: (*handler).revokeRole},
+	{id: "check", method: "GET", path: "/check", summary: "Check a user permission, a user role or a role permission, pass two of the parameters", optional: []string{"user_id", "role", "permission"}, response: CheckResponse{}, status: http.StatusOK, handle: (*handler).check},
+	{id: "explain", method: "GET", path: "/explain", summary: "Explain which roles grant a permission to a user", query: []string{"user_id", "permission"}, response: ExplainResponse{}, status: http.StatusOK, handle: (*handler).explain},
+}
+
+// Spec returns the OpenAPI 3 document of the api, generated from its routes
+// the base path is used as the server url
+func Spec(basePath string) []byte {
+	schemas := map[string]interface{}{}
+	paths := map[string]map[string]interface{}{}
+	for _, e := range endpoints {
+		op := map[string]interface{}{
+			"summary":     e.summary,
+			"operationId": e.id,
+		}
+
+		var params []interface{}
+		for _, seg := range strings.Split(e.path, "/") {
+			if strings.HasPrefix(seg, "{") {
+				params = append(params, parameter(strings.Trim(seg, "{}"), "path", true))
+			}
+		}
+		for _, name := range e.query {
+			params = append(params, parameter(name, "query", true))
+		}
+		for _, name := range e.optional {
+			params = append(params, parameter(name, "query", false))
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+
+		if e.body != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schemaOf(reflect.TypeOf(e.body), schemas)),
+			}
+		}
+
+		success := map[string]interface{}{"description": http.StatusText(e.status)}
+		if e.response != nil {
+			success["content"] = jsonContent(schemaOf(reflect.TypeOf(e.response), schemas))
+		}
+		op["responses"] = map[string]interface{}{
+			strconv.Itoa(e.status): success,
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content":     jsonContent(schemaOf(reflect.TypeOf(ErrorResponse{}), schemas)),
+			},
+		}
+
+		if paths[e.path] == nil {
+			paths[e.path] = map[string]interface{}{}
+		}
+		paths[e.path][strings.ToLower(e.method)] = op
+	}
+
+	if basePath == "" {
+		basePath = "/"
+	}
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Authority API",
+			"version": "1.0.0",
+		},
+		"servers":    []interface{}{map[string]interface{}{"url": basePath}},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+	b, _ := json.MarshalIndent(doc, "", "  ")
+
+	return b
+}
+
+func parameter(name string, in string, required bool) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"in":       in,
+		"required": required,
+		"schema":   map[string]interface{}{"type": "string"},
+	}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// schemaOf returns the json schema of the type, structs are added to the components and referenced
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if _, ok := schemas[t.Name()]; !ok {
+			props := map[string]interface{}{}
+			var required []string
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				name := strings.Split(f.Tag.Get("json"), ",")[0]
+				props[name] = schemaOf(f.Type, schemas)
+				required = append(required, name)
+			}
+			schemas[t.Name()] = map[string]interface{}{
+				"type":       "object",
+				"properties": props,
+				"required":   required,
+			}
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	return map[string]interface{}{}
+}
@@ -1,27 +1,64 @@
 package authority
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
 // Authority helps deal with permissions
 type Authority struct {
-	TablesPrefix string
-	DB           *gorm.DB
+	TablesPrefix    string
+	DB              *gorm.DB
+	metrics         Metrics
+	tracer          trace.Tracer
+	hashUserIDs     bool
+	logger          *slog.Logger
+	logAllowedEvery int
+	allowed         *atomic.Uint64
+	ctx             context.Context
+	events          *dispatcher
+	outbox          bool
+	maxRolesPerUser int
+	maxElevation    time.Duration
+	override        bool     // Allows changing protected roles and permissions
+	pending         *[]Event // The events of the transaction, emitted on Commit
 }
 
 // Options has the options for initiating the package
 type Options struct {
 	TablesPrefix string
 	DB           *gorm.DB
+	Metrics      Metrics      // Receives the measurements of the checks and changes, optional
+	Tracer       trace.Tracer // Traces every call in a span, optional
+	// HashTracedUserIDs replaces the user ids of the spans with their sha256 hash
+	HashTracedUserIDs bool
+	// Logger logs every denied check, sampled allowed checks and every change, optional
+	Logger *slog.Logger
+	// LogAllowedEvery logs one of every n allowed checks, zero or one logs all of them
+	LogAllowedEvery int
+	// Outbox stores the change events in the outbox table, in the same transaction as the change
+	// the outbox package delivers them
+	Outbox bool
+	// MaxRolesPerUser is the maximum number of roles a user can hold, zero means no limit
+	MaxRolesPerUser int
+	// MaxElevation is the longest duration of an elevation or break-glass access, zero means no limit
+	MaxElevation time.Duration
 }
 
 var (
+	ErrPermissionExists   = errors.New("permission already exists")
+	ErrPermissionAssigned = errors.New("permission is already assigned to the role")
 	ErrPermissionInUse    = errors.New("cannot delete assigned permission")
 	ErrPermissionNotFound = errors.New("permission not found")
+	ErrRoleExists         = errors.New("role already exists")
+	ErrRoleAssigned       = errors.New("role is already assigned to the user")
 	ErrRoleInUse          = errors.New("cannot delete assigned role")
 	ErrRoleNotFound       = errors.New("role not found")
 )
@@ -36,18 +73,44 @@ var tx *gorm.DB
 func New(opts Options) *Authority {
 	options = opts
 	auth = &Authority{
-		TablesPrefix: options.TablesPrefix,
-		DB:           opts.DB,
+		TablesPrefix:    options.TablesPrefix,
+		DB:              opts.DB,
+		metrics:         opts.Metrics,
+		tracer:          opts.Tracer,
+		hashUserIDs:     opts.HashTracedUserIDs,
+		logger:          opts.Logger,
+		logAllowedEvery: opts.LogAllowedEvery,
+		allowed:         &atomic.Uint64{},
+		events:          newDispatcher(),
+		outbox:          opts.Outbox,
+		maxRolesPerUser: opts.MaxRolesPerUser,
+		maxElevation:    opts.MaxElevation,
+	}
+	if opts.Tracer != nil {
+		registerQueryCounter(opts.DB)
 	}
 
 	migrateTables(opts.DB)
+	if opts.Outbox {
+		opts.DB.AutoMigrate(&OutboxEvent{})
+	}
 	return auth
 }
 
 // New initiates new instance of authority
 func newInstance(opts Options) *Authority {
 	newAuth := &Authority{
-		DB: opts.DB,
+		DB:              opts.DB,
+		metrics:         opts.Metrics,
+		tracer:          opts.Tracer,
+		hashUserIDs:     opts.HashTracedUserIDs,
+		logger:          opts.Logger,
+		logAllowedEvery: opts.LogAllowedEvery,
+		allowed:         &atomic.Uint64{},
+		events:          newDispatcher(),
+		outbox:          opts.Outbox,
+		maxRolesPerUser: opts.MaxRolesPerUser,
+		maxElevation:    opts.MaxElevation,
 	}
 
 	migrateTables(opts.DB)
@@ -63,46 +126,54 @@ func Resolve() *Authority {
 // it accepts the Role struct as a parameter
 // it returns an error in case of any
 // it returns an error if the role is already exists
-func (a *Authority) CreateRole(r Role) error {
+func (a *Authority) CreateRole(r Role) (err error) {
+	a, op := a.begin("CreateRole", AttrRole.String(r.Slug))
+	defer func() { op.mutated(err) }()
+
 	roleSlug := r.Slug
 	var dbRole Role
 	res := a.DB.Where("slug = ?", roleSlug).First(&dbRole)
 	if res.Error != nil {
 		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
 			// create
-			createRes := a.DB.Create(&r)
-			if createRes.Error != nil {
-				return createRes.Error
-			}
-			return nil
+			return a.write(func(db *gorm.DB) ([]Event, error) {
+				if err := db.Create(&r).Error; err != nil {
+					return nil, err
+				}
+				return []Event{{Type: EventRoleCreated, Role: r.Slug, Name: r.Name}}, nil
+			})
 		}
 		return res.Error
 	}
 
-	return errors.New(fmt.Sprintf("role '%v' already exists", roleSlug))
+	return fmt.Errorf("%w: '%v'", ErrRoleExists, roleSlug)
 }
 
 // Add a new permission to the database
 // it accepts the Permission struct as a parameter
 // it returns an error in case of any
 // it returns an error if the permission is already exists
-func (a *Authority) CreatePermission(p Permission) error {
+func (a *Authority) CreatePermission(p Permission) (err error) {
+	a, op := a.begin("CreatePermission", AttrPermission.String(p.Slug))
+	defer func() { op.mutated(err) }()
+
 	permSlug := p.Slug
 	var dbPerm Permission
 	res := a.DB.Where("slug = ?", permSlug).First(&dbPerm)
 	if res.Error != nil {
 		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
 			// create
-			createRes := a.DB.Create(&p)
-			if createRes.Error != nil {
-				return createRes.Error
-			}
-			return nil
+			return a.write(func(db *gorm.DB) ([]Event, error) {
+				if err := db.Create(&p).Error; err != nil {
+					return nil, err
+				}
+				return []Event{{Type: EventPermissionCreated, Permission: p.Slug, Name: p.Name}}, nil
+			})
 		}
 		return res.Error
 	}
 
-	return errors.New(fmt.Sprintf("permission '%v' already exists", permSlug))
+	return fmt.Errorf("%w: '%v'", ErrPermissionExists, permSlug)
 }
 
 // Assigns a group of permissions to a given role
@@ -112,7 +183,16 @@ func (a *Authority) CreatePermission(p Permission) error {
 // it returns an error in case the role does not exists
 // it returns an error in case any of the permissions does not exists
 // it returns an error in case any of the permissions is already assigned
-func (a *Authority) AssignPermissionsToRole(roleSlug string, permSlugs []string) error {
+func (a *Authority) AssignPermissionsToRole(roleSlug string, permSlugs []string) (err error) {
+	a, op := a.begin("AssignPermissionsToRole", AttrRole.String(roleSlug), AttrPermissions.StringSlice(permSlugs))
+	defer func() { op.mutated(err) }()
+
+	return a.assignPermissions(roleSlug, permSlugs, nil)
+}
+
+// assignPermissions grants the permissions to the role
+// the optional check function runs in the transaction of the grant, before any change
+func (a *Authority) assignPermissions(roleSlug string, permSlugs []string, check func(db *gorm.DB) error) error {
 	var role Role
 	rRes := a.DB.Where("slug = ?", roleSlug).First(&role)
 	if rRes.Error != nil {
@@ -134,9 +214,15 @@ func (a *Authority) AssignPermissionsToRole(roleSlug string, permSlugs []string)
 		perms = append(perms, perm)
 	}
 	tx := a.DB.Begin()
+	if check != nil {
+		if err := check(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 	for _, perm := range perms {
 		var rolePerm RolePermission
-		res := a.DB.Where("role_id = ?", role.ID).Where("permission_id =?", perm.ID).First(&rolePerm)
+		res := tx.Where("role_id = ?", role.ID).Where("permission_id =?", perm.ID).First(&rolePerm)
 		if res.Error != nil && errors.Is(res.Error, gorm.ErrRecordNotFound) {
 			cRes := tx.Create(&RolePermission{RoleID: role.ID, PermissionID: perm.ID})
 			if cRes.Error != nil {
@@ -150,11 +236,24 @@ func (a *Authority) AssignPermissionsToRole(roleSlug string, permSlugs []string)
 		}
 		if rolePerm != (RolePermission{}) {
 			tx.Rollback()
-			return errors.New(fmt.Sprintf("permission '%v' is aleady assigned to the role '%v'", perm.Name, role.Name))
+			return fmt.Errorf("%w: permission '%v', role '%v'", ErrPermissionAssigned, perm.Name, role.Name)
 		}
 		rolePerm = RolePermission{}
 	}
-	return tx.Commit().Error
+	var events []Event
+	for _, perm := range perms {
+		events = append(events, Event{Type: EventPermissionGranted, Role: roleSlug, Permission: perm.Slug})
+	}
+	if err := a.record(tx, events...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+
+	a.emit(events...)
+	return nil
 }
 
 // Assigns a role to a given user
@@ -163,8 +262,20 @@ func (a *Authority) AssignPermissionsToRole(roleSlug string, permSlugs []string)
 // it returns an error in case of any
 // it returns an error in case the role does not exists
 // it returns an error in case the role is already assigned
-func (a *Authority) AssignRoleToUser(userID interface{}, roleSlug string) error {
-	userIDStr := fmt.Sprintf("%v", userID)
+// it returns a SoDError in case the user holds a role excluded by the role
+// it returns a CardinalityError in case the role has its maximum holders or the user its maximum roles
+func (a *Authority) AssignRoleToUser(userID interface{}, roleSlug string) (err error) {
+	a, op := a.begin("AssignRoleToUser", userAttr(userID), AttrRole.String(roleSlug))
+	defer func() { op.mutated(err) }()
+
+	return a.assignRole(fmt.Sprintf("%v", userID), roleSlug, nil, nil, nil)
+}
+
+// assignRole assigns the role to the user until expiresAt, nil means forever
+// an expired assignment of the role is replaced
+// the optional check function runs in the transaction of the assignment, before any change
+// the optional also function runs in the transaction of the assignment, its events follow the assignment ones
+func (a *Authority) assignRole(userID string, roleSlug string, expiresAt *time.Time, check func(db *gorm.DB) error, also func(db *gorm.DB) ([]Event, error)) error {
 	var role Role
 	res := a.DB.Where("slug = ?", roleSlug).First(&role)
 	if res.Error != nil {
@@ -174,16 +285,48 @@ func (a *Authority) AssignRoleToUser(userID interface{}, roleSlug string) error
 		return res.Error
 	}
 	var userRole UserRole
-	res = a.DB.Where("user_id = ?", userIDStr).Where("role_id = ?", role.ID).First(&userRole)
+	res = a.DB.Scopes(active).Where("user_id = ?", userID).Where("role_id = ?", role.ID).First(&userRole)
 	if res.Error != nil && errors.Is(res.Error, gorm.ErrRecordNotFound) {
-		a.DB.Create(&UserRole{UserID: userIDStr, RoleID: role.ID})
-		return nil
+		return a.writeAtomic(func(db *gorm.DB) ([]Event, error) {
+			if check != nil {
+				if err := check(db); err != nil {
+					return nil, err
+				}
+			}
+			var events []Event
+			// drop the expired assignment not yet revoked by RevokeExpiredRoles
+			dRes := db.Where("user_id = ?", userID).Where("role_id = ?", role.ID).Where("expires_at <= ?", time.Now()).Delete(UserRole{})
+			if dRes.Error != nil {
+				return nil, dRes.Error
+			}
+			if dRes.RowsAffected > 0 {
+				events = append(events, Event{Type: EventRoleRevoked, UserID: userID, Role: roleSlug})
+			}
+			if err := checkCardinality(db, userID, role, a.maxRolesPerUser); err != nil {
+				return nil, err
+			}
+			if err := checkSoD(db, userID, role); err != nil {
+				return nil, err
+			}
+			if err := db.Create(&UserRole{UserID: userID, RoleID: role.ID, ExpiresAt: expiresAt}).Error; err != nil {
+				return nil, err
+			}
+			events = append(events, Event{Type: EventRoleAssigned, UserID: userID, Role: roleSlug})
+			if also != nil {
+				alsoEvents, err := also(db)
+				if err != nil {
+					return nil, err
+				}
+				events = append(events, alsoEvents...)
+			}
+			return events, nil
+		})
 	}
 	if res.Error != nil && !errors.Is(res.Error, gorm.ErrRecordNotFound) {
 		return res.Error
 	}
 
-	return errors.New(fmt.Sprintf("this role '%v' is aleady assigned to the user", roleSlug))
+	return fmt.Errorf("%w: '%v'", ErrRoleAssigned, roleSlug)
 }
 
 // Checks if a role is assigned to a user
@@ -193,7 +336,10 @@ func (a *Authority) AssignRoleToUser(userID interface{}, roleSlug string) error
 // the first parameter of the return is a boolean represents whether the role is assigned or not
 // the second is an error in case of any
 // in case the role does not exists, an error is returned
-func (a *Authority) CheckUserRole(userID interface{}, roleSlug string) (bool, error) {
+func (a *Authority) CheckUserRole(userID interface{}, roleSlug string) (ok bool, err error) {
+	a, op := a.begin("CheckUserRole", userAttr(userID), AttrRole.String(roleSlug))
+	defer func() { op.decided(ok, err) }()
+
 	userIDStr := fmt.Sprintf("%v", userID)
 	// find the role
 	var role Role
@@ -207,7 +353,7 @@ func (a *Authority) CheckUserRole(userID interface{}, roleSlug string) (bool, er
 
 	// check if the role is a assigned
 	var userRole UserRole
-	res = a.DB.Where("user_id = ?", userIDStr).Where("role_id = ?", role.ID).First(&userRole)
+	res = a.DB.Scopes(active).Where("user_id = ?", userIDStr).Where("role_id = ?", role.ID).First(&userRole)
 	if res.Error != nil {
 		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
 			return false, nil
@@ -225,11 +371,14 @@ func (a *Authority) CheckUserRole(userID interface{}, roleSlug string) (bool, er
 // the first parameter of the return is a boolean represents whether the role is assigned or not
 // the second is an error in case of any
 // in case the role does not exists, an error is returned
-func (a *Authority) CheckUserPermission(userID interface{}, permSlug string) (bool, error) {
+func (a *Authority) CheckUserPermission(userID interface{}, permSlug string) (ok bool, err error) {
+	a, op := a.begin("CheckUserPermission", userAttr(userID), AttrPermission.String(permSlug))
+	defer func() { op.decided(ok, err) }()
+
 	userIDStr := fmt.Sprintf("%v", userID)
 	// the user role
 	var userRoles []UserRole
-	res := a.DB.Where("user_id = ?", userIDStr).Find(&userRoles)
+	res := a.DB.Scopes(active).Where("user_id = ?", userIDStr).Find(&userRoles)
 	if res.Error != nil {
 		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
 			return false, nil
@@ -266,6 +415,70 @@ func (a *Authority) CheckUserPermission(userID interface{}, permSlug string) (bo
 	return true, nil
 }
 
+// Explanation describes why a user has, or does not have, a permission
+type Explanation struct {
+	UserID     string // The user id
+	Permission string // The permission slug
+	Allowed    bool   // Whether the user has the permission
+	Roles      []Role // The user roles granting the permission
+}
+
+// Explains a permission check of a user
+// it accepts the user id as the first parameter
+// the second parameter the permission slug
+// it returns the user roles granting the permission
+// it returns an error in case of any
+// in case the permission does not exists, an error is returned
+func (a *Authority) ExplainUserPermission(userID interface{}, permSlug string) (explanation Explanation, err error) {
+	a, op := a.begin("ExplainUserPermission", userAttr(userID), AttrPermission.String(permSlug))
+	defer func() { op.end(err) }()
+
+	userIDStr := fmt.Sprintf("%v", userID)
+	explanation = Explanation{UserID: userIDStr, Permission: permSlug}
+
+	// find the permission
+	var perm Permission
+	res := a.DB.Where("slug = ?", permSlug).First(&perm)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return explanation, ErrPermissionNotFound
+		}
+		return explanation, res.Error
+	}
+
+	// the user roles
+	var userRoles []UserRole
+	res = a.DB.Scopes(active).Where("user_id = ?", userIDStr).Find(&userRoles)
+	if res.Error != nil {
+		return explanation, res.Error
+	}
+	var roleIDs []interface{}
+	for _, r := range userRoles {
+		roleIDs = append(roleIDs, r.RoleID)
+	}
+
+	// the user roles having the permission
+	var rolePerms []RolePermission
+	res = a.DB.Where("role_id IN (?)", roleIDs).Where("permission_id = ?", perm.ID).Find(&rolePerms)
+	if res.Error != nil {
+		return explanation, res.Error
+	}
+	if len(rolePerms) == 0 {
+		return explanation, nil
+	}
+	var grantingIDs []interface{}
+	for _, rp := range rolePerms {
+		grantingIDs = append(grantingIDs, rp.RoleID)
+	}
+	res = a.DB.Where("id IN (?)", grantingIDs).Order("slug").Find(&explanation.Roles)
+	if res.Error != nil {
+		return explanation, res.Error
+	}
+	explanation.Allowed = true
+
+	return explanation, nil
+}
+
 // Checks if a permission is assigned to a role
 // it accepts in the role slug as the first parameter
 // the second parameter the permission slug
@@ -274,7 +487,10 @@ func (a *Authority) CheckUserPermission(userID interface{}, permSlug string) (bo
 // the second is an error in case of any
 // in case the role does not exists, an error is returned
 // in case the permission does not exists, an error is returned
-func (a *Authority) CheckRolePermission(roleSlug string, permSlug string) (bool, error) {
+func (a *Authority) CheckRolePermission(roleSlug string, permSlug string) (ok bool, err error) {
+	a, op := a.begin("CheckRolePermission", AttrRole.String(roleSlug), AttrPermission.String(permSlug))
+	defer func() { op.decided(ok, err) }()
+
 	// find the role
 	var role Role
 	res := a.DB.Where("slug = ?", roleSlug).First(&role)
@@ -311,7 +527,10 @@ func (a *Authority) CheckRolePermission(roleSlug string, permSlug string) (bool,
 // Revokes a user's role
 // it returns a error in case of any
 // in case the role does not exists, an error is returned
-func (a *Authority) RevokeUserRole(userID interface{}, roleSlug string) error {
+func (a *Authority) RevokeUserRole(userID interface{}, roleSlug string) (err error) {
+	a, op := a.begin("RevokeUserRole", userAttr(userID), AttrRole.String(roleSlug))
+	defer func() { op.mutated(err) }()
+
 	userIDStr := fmt.Sprintf("%v", userID)
 	// find the role
 	var role Role
@@ -324,19 +543,24 @@ func (a *Authority) RevokeUserRole(userID interface{}, roleSlug string) error {
 	}
 
 	// revoke the role
-	rRes := a.DB.Where("user_id = ?", userIDStr).Where("role_id = ?", role.ID).Delete(UserRole{})
-	if rRes.Error != nil {
-		return rRes.Error
-	}
-
-	return nil
+	return a.write(func(db *gorm.DB) ([]Event, error) {
+		rRes := db.Where("user_id = ?", userIDStr).Where("role_id = ?", role.ID).Delete(UserRole{})
+		if rRes.Error != nil || rRes.RowsAffected == 0 {
+			return nil, rRes.Error
+		}
+		return []Event{{Type: EventRoleRevoked, UserID: userIDStr, Role: roleSlug}}, nil
+	})
 }
 
 // Revokes a roles's permission
 // it returns a error in case of any
 // in case the role does not exists, an error is returned
 // in case the permission does not exists, an error is returned
-func (a *Authority) RevokeRolePermission(roleSlug string, permSlug string) error {
+// in case the role is protected, an error is returned
+func (a *Authority) RevokeRolePermission(roleSlug string, permSlug string) (err error) {
+	a, op := a.begin("RevokeRolePermission", AttrRole.String(roleSlug), AttrPermission.String(permSlug))
+	defer func() { op.mutated(err) }()
+
 	// find the role
 	var role Role
 	res := a.DB.Where("slug = ?", roleSlug).First(&role)
@@ -356,20 +580,26 @@ func (a *Authority) RevokeRolePermission(roleSlug string, permSlug string) error
 		}
 		return res.Error
 	}
-
-	// revoke the permission
-	rRes := a.DB.Where("role_id = ?", role.ID).Where("permission_id = ?", perm.ID).Delete(RolePermission{})
-	if rRes.Error != nil {
-		return rRes.Error
+	if err := a.checkRoleProtected(role); err != nil {
+		return err
 	}
 
-	return nil
+	// revoke the permission
+	return a.write(func(db *gorm.DB) ([]Event, error) {
+		rRes := db.Where("role_id = ?", role.ID).Where("permission_id = ?", perm.ID).Delete(RolePermission{})
+		if rRes.Error != nil || rRes.RowsAffected == 0 {
+			return nil, rRes.Error
+		}
+		return []Event{{Type: EventPermissionRevoked, Role: roleSlug, Permission: permSlug}}, nil
+	})
 }
 
 // Returns all stored roles
 // it returns an error in case of any
-func (a *Authority) GetAllRoles() ([]Role, error) {
-	var roles []Role
+func (a *Authority) GetAllRoles() (roles []Role, err error) {
+	a, op := a.begin("GetAllRoles")
+	defer func() { op.end(err) }()
+
 	res := a.DB.Find(&roles)
 	if res.Error != nil {
 		return nil, res.Error
@@ -380,10 +610,13 @@ func (a *Authority) GetAllRoles() ([]Role, error) {
 
 // Returns all user assigned roles
 // it returns an error in case of any
-func (a *Authority) GetUserRoles(userID interface{}) ([]Role, error) {
+func (a *Authority) GetUserRoles(userID interface{}) (roles []Role, err error) {
+	a, op := a.begin("GetUserRoles", userAttr(userID))
+	defer func() { op.end(err) }()
+
 	userIDStr := fmt.Sprintf("%v", userID)
 	var userRoles []UserRole
-	res := a.DB.Where("user_id = ?", userIDStr).Find(&userRoles)
+	res := a.DB.Scopes(active).Where("user_id = ?", userIDStr).Find(&userRoles)
 	if res.Error != nil {
 		return nil, res.Error
 	}
@@ -393,7 +626,6 @@ func (a *Authority) GetUserRoles(userID interface{}) ([]Role, error) {
 		roleIDs = append(roleIDs, r.RoleID)
 	}
 
-	var roles []Role
 	res = a.DB.Where("id IN (?)", roleIDs).Find(&roles)
 	if res.Error != nil {
 		return nil, res.Error
@@ -402,12 +634,48 @@ func (a *Authority) GetUserRoles(userID interface{}) ([]Role, error) {
 	return roles, nil
 }
 
+// Returns the ids of all users assigned a given role
+// it returns an error in case of any
+// in case the role does not exists, an error is returned
+func (a *Authority) GetRoleUsers(roleSlug string) (userIDs []string, err error) {
+	a, op := a.begin("GetRoleUsers", AttrRole.String(roleSlug))
+	defer func() { op.end(err) }()
+
+	var role Role
+	res := a.DB.Where("slug = ?", roleSlug).First(&role)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, res.Error
+	}
+
+	var userRoles []UserRole
+	res = a.DB.Scopes(active).Where("role_id = ?", role.ID).Order("user_id").Find(&userRoles)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	for _, userRole := range userRoles {
+		userIDs = append(userIDs, userRole.UserID)
+	}
+
+	return userIDs, nil
+}
+
 // Returns all role assigned permissions
 // it returns an error in case of any
-func (a *Authority) GetRolePermissions(roleSlug string) ([]Permission, error) {
+// in case the role does not exists, an error is returned
+func (a *Authority) GetRolePermissions(roleSlug string) (perms []Permission, err error) {
+	a, op := a.begin("GetRolePermissions", AttrRole.String(roleSlug))
+	defer func() { op.end(err) }()
+
 	var role Role
-	res := a.DB.Where("slug = ?", roleSlug).Find(&role)
+	res := a.DB.Where("slug = ?", roleSlug).First(&role)
 	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrRoleNotFound
+		}
 		return nil, res.Error
 	}
 
@@ -421,7 +689,6 @@ func (a *Authority) GetRolePermissions(roleSlug string) ([]Permission, error) {
 		permIDs = append(permIDs, rolePerm.PermissionID)
 	}
 
-	var perms []Permission
 	res = a.DB.Where("id IN (?)", permIDs).Find(&perms)
 	if res.Error != nil {
 		return nil, res.Error
@@ -432,8 +699,10 @@ func (a *Authority) GetRolePermissions(roleSlug string) ([]Permission, error) {
 
 // Returns all stored permissions
 // it returns an error in case of any
-func (a *Authority) GetAllPermissions() ([]Permission, error) {
-	var perms []Permission
+func (a *Authority) GetAllPermissions() (perms []Permission, err error) {
+	a, op := a.begin("GetAllPermissions")
+	defer func() { op.end(err) }()
+
 	res := a.DB.Find(&perms)
 	if res.Error != nil {
 		return nil, res.Error
@@ -447,13 +716,24 @@ func (a *Authority) GetAllPermissions() ([]Permission, error) {
 // it accepts the role slug as a parameter
 // it returns an error in case of any
 // if the role is assigned to a user it returns an error
-func (a *Authority) DeleteRole(roleSlug string) error {
+// if the role is protected it returns an error
+func (a *Authority) DeleteRole(roleSlug string) (err error) {
+	a, op := a.begin("DeleteRole", AttrRole.String(roleSlug))
+	defer func() { op.mutated(err) }()
+
 	// find the role
 	var role Role
 	res := a.DB.Where("slug = ?", roleSlug).First(&role)
 	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			// gorm.ErrRecordNotFound is kept for the callers matching it before ErrRoleNotFound
+			return fmt.Errorf("%w: %w", ErrRoleNotFound, res.Error)
+		}
 		return res.Error
 	}
+	if err := a.checkRoleProtected(role); err != nil {
+		return err
+	}
 
 	// check if the role is assigned to a user
 	var c int64
@@ -474,27 +754,54 @@ func (a *Authority) DeleteRole(roleSlug string) error {
 		return dRes.Error
 	}
 
+	// remove the separation of duties constraints of the role
+	dRes = tx.Where("role_id = ? OR conflicting_role_id = ?", role.ID, role.ID).Delete(SoDConstraint{})
+	if dRes.Error != nil {
+		tx.Rollback()
+		return dRes.Error
+	}
+
 	// delete the role
-	dRes = a.DB.Where("slug = ?", roleSlug).Delete(Role{})
+	dRes = tx.Where("slug = ?", roleSlug).Delete(Role{})
 	if dRes.Error != nil {
 		tx.Rollback()
 		return dRes.Error
 	}
+	event := Event{Type: EventRoleDeleted, Role: roleSlug}
+	if err := a.record(tx, event); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
 
-	return tx.Commit().Error
+	a.emit(event)
+	return nil
 }
 
 // Deletes a given permission
 // it accepts the permission slug as a parameter
 // it returns an error in case of any
 // if the permission is assigned to a role it returns an error
-func (a *Authority) DeletePermission(permSlug string) error {
+// if the permission is protected it returns an error
+func (a *Authority) DeletePermission(permSlug string) (err error) {
+	a, op := a.begin("DeletePermission", AttrPermission.String(permSlug))
+	defer func() { op.mutated(err) }()
+
 	// find the permission
 	var perm Permission
 	res := a.DB.Where("slug = ?", permSlug).First(&perm)
 	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			// gorm.ErrRecordNotFound is kept for the callers matching it before ErrPermissionNotFound
+			return fmt.Errorf("%w: %w", ErrPermissionNotFound, res.Error)
+		}
 		return res.Error
 	}
+	if err := a.checkPermissionProtected(perm); err != nil {
+		return err
+	}
 
 	// check if the permission is assigned to a role
 	var rolePermission RolePermission
@@ -508,33 +815,51 @@ func (a *Authority) DeletePermission(permSlug string) error {
 	}
 
 	// delete the permission
-	dRes := a.DB.Where("slug = ?", permSlug).Delete(Permission{})
-	if dRes.Error != nil {
-		return dRes.Error
-	}
-
-	return nil
+	return a.write(func(db *gorm.DB) ([]Event, error) {
+		if err := db.Where("slug = ?", permSlug).Delete(Permission{}).Error; err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventPermissionDeleted, Permission: permSlug}}, nil
+	})
 }
 
 // Begin a transaction session
 func (a *Authority) BeginTX() *Authority {
-	tx = options.DB.Begin()
-	newAuth := newInstance(Options{
-		TablesPrefix: options.TablesPrefix,
-		DB:           tx,
-	})
+	tx = options.DB.WithContext(a.context()).Begin()
+	txOptions := options
+	txOptions.DB = tx
+	newAuth := newInstance(txOptions)
+	newAuth.ctx = a.ctx
+	newAuth.override = a.override
+	newAuth.allowed = a.allowed
+	newAuth.events = a.events
+	newAuth.pending = &[]Event{}
 
 	return newAuth
 }
 
 // Rolback previous queries
+// the change events of the transaction are dropped
 func (a *Authority) Rollback() error {
+	if a.pending != nil {
+		*a.pending = nil
+	}
 	return tx.Rollback().Error
 }
 
 // Commit queries to the database
+// the change events of the transaction are emitted once it is committed
 func (a *Authority) Commit() error {
-	return tx.Commit().Error
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	if a.pending != nil {
+		events := *a.pending
+		*a.pending = nil
+		a.events.dispatch(events...)
+	}
+
+	return nil
 }
 
 func migrateTables(db *gorm.DB) {
@@ -542,4 +867,10 @@ func migrateTables(db *gorm.DB) {
 	db.AutoMigrate(&Permission{})
 	db.AutoMigrate(&RolePermission{})
 	db.AutoMigrate(&UserRole{})
+	db.AutoMigrate(&UserLock{})
+	db.AutoMigrate(&SoDConstraint{})
+	db.AutoMigrate(&AccessRequest{})
+	db.AutoMigrate(&Elevation{})
+	db.AutoMigrate(&Campaign{})
+	db.AutoMigrate(&CampaignItem{})
 }
@@ -1,43 +1,81 @@
 package authority_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/harranali/authority"
-	"github.com/joho/godotenv"
-	"gorm.io/driver/mysql"
+	"github.com/harranali/authority/internal/testdb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
 var db *gorm.DB
 
+// dialect is the name of the database the current test runs against
+var dialect string
+
+// database is an opened test database
+type database struct {
+	name string
+	db   *gorm.DB
+}
+
+var databases []database
+
 func TestMain(m *testing.M) {
-	err := godotenv.Load()
+	dir, err := os.MkdirTemp("", "authority")
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatal("failed to create the databases directory ", err)
 	}
-	var dsn string
-	if os.Getenv("env") == "testing" {
-		fmt.Println("preparing testing config...")
-		dsn = fmt.Sprintf("root:%s@tcp(127.0.0.1:3306)/db_test?charset=utf8mb4&parseTime=True&loc=Local",
-			os.Getenv("ROOT_PASSWORD"))
-	} else {
-		dsn = "root:root@tcp(127.0.0.1:3306)/db_test?charset=utf8mb4&parseTime=True&loc=Local"
+
+	for _, d := range testdb.Dialects() {
+		fmt.Printf("preparing %v database...\n", d.Name)
+		conn, err := testdb.Open(d, dir)
+		if err != nil {
+			log.Fatalf("failed to open the %v database: %v", d.Name, err)
+		}
+		// start from empty tables, the server databases may hold rows of a previous run
+		conn.Migrator().DropTable("authority_roles", "authority_permissions", "authority_role_permissions", "authority_user_roles", "authority_outbox_events", "authority_sod_constraints", "authority_access_requests", "authority_elevations", "authority_campaigns", "authority_campaign_items", "authority_user_locks")
+		databases = append(databases, database{name: d.Name, db: conn})
 	}
 
-	db, _ = gorm.Open(mysql.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Silent),
-	})
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
 
-	// call flag.Parse() here if TestMain uses flags
-	os.Exit(m.Run())
+// forEachDatabase runs the test once against every database
+func forEachDatabase(t *testing.T, test func(t *testing.T)) {
+	for _, d := range databases {
+		t.Run(d.name, func(t *testing.T) {
+			db = d.db
+			dialect = d.name
+			test(t)
+		})
+	}
 }
 
 func TestCreateRole(t *testing.T) {
+	forEachDatabase(t, testCreateRole)
+}
+
+func testCreateRole(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -66,8 +104,8 @@ func TestCreateRole(t *testing.T) {
 		Name: "Role A",
 		Slug: "role-a",
 	})
-	if err == nil {
-		t.Error("failed test create role")
+	if !errors.Is(err, authority.ErrRoleExists) {
+		t.Error("failed test create role", err)
 	}
 
 	t.Cleanup(func() {
@@ -78,6 +116,10 @@ func TestCreateRole(t *testing.T) {
 }
 
 func TestCreatePermission(t *testing.T) {
+	forEachDatabase(t, testCreatePermission)
+}
+
+func testCreatePermission(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -122,6 +164,10 @@ func TestCreatePermission(t *testing.T) {
 }
 
 func TestAssignPermissionToRole(t *testing.T) {
+	forEachDatabase(t, testAssignPermissionToRole)
+}
+
+func testAssignPermissionToRole(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -187,6 +233,10 @@ func TestAssignPermissionToRole(t *testing.T) {
 }
 
 func TestAssignRoleToUser(t *testing.T) {
+	forEachDatabase(t, testAssignRoleToUser)
+}
+
+func testAssignRoleToUser(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -252,6 +302,10 @@ func TestAssignRoleToUser(t *testing.T) {
 }
 
 func TestCheckUserRole(t *testing.T) {
+	forEachDatabase(t, testCheckUserRole)
+}
+
+func testCheckUserRole(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -320,6 +374,10 @@ func TestCheckUserRole(t *testing.T) {
 
 // check user permission
 func TestCheckUserPermission(t *testing.T) {
+	forEachDatabase(t, testCheckUserPermission)
+}
+
+func testCheckUserPermission(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -400,6 +458,10 @@ func TestCheckUserPermission(t *testing.T) {
 }
 
 func TestCheckRolePermission(t *testing.T) {
+	forEachDatabase(t, testCheckRolePermission)
+}
+
+func testCheckRolePermission(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -481,6 +543,10 @@ func TestCheckRolePermission(t *testing.T) {
 }
 
 func TestRevokeUserRole(t *testing.T) {
+	forEachDatabase(t, testRevokeUserRole)
+}
+
+func testRevokeUserRole(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -528,6 +594,10 @@ func TestRevokeUserRole(t *testing.T) {
 }
 
 func TestRevokeRolePermission(t *testing.T) {
+	forEachDatabase(t, testRevokeRolePermission)
+}
+
+func testRevokeRolePermission(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -602,6 +672,10 @@ func TestRevokeRolePermission(t *testing.T) {
 }
 
 func TestGetAllRoles(t *testing.T) {
+	forEachDatabase(t, testGetAllRoles)
+}
+
+func testGetAllRoles(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -638,6 +712,10 @@ func TestGetAllRoles(t *testing.T) {
 }
 
 func TestGetAllPermissions(t *testing.T) {
+	forEachDatabase(t, testGetAllPermissions)
+}
+
+func testGetAllPermissions(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -670,6 +748,10 @@ func TestGetAllPermissions(t *testing.T) {
 }
 
 func TestDeleteRole(t *testing.T) {
+	forEachDatabase(t, testDeleteRole)
+}
+
+func testDeleteRole(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -685,8 +767,8 @@ func TestDeleteRole(t *testing.T) {
 
 	// test delete a missing role
 	err = auth.DeleteRole("role-aa")
-	if err == nil {
-		t.Error("failed test delete role")
+	if !errors.Is(err, authority.ErrRoleNotFound) || !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Error("failed test delete role", err)
 	}
 
 	// test delete an assigned role
@@ -715,6 +797,10 @@ func TestDeleteRole(t *testing.T) {
 }
 
 func TestDeletePermission(t *testing.T) {
+	forEachDatabase(t, testDeletePermission)
+}
+
+func testDeletePermission(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -730,7 +816,7 @@ func TestDeletePermission(t *testing.T) {
 
 	// delete missing permission
 	err = auth.DeletePermission("permission-aa")
-	if err == nil {
+	if !errors.Is(err, authority.ErrPermissionNotFound) || !errors.Is(err, gorm.ErrRecordNotFound) {
 		t.Error("failed test delete permission", err)
 	}
 
@@ -768,6 +854,10 @@ func TestDeletePermission(t *testing.T) {
 }
 
 func TestGetUserRoles(t *testing.T) {
+	forEachDatabase(t, testGetUserRoles)
+}
+
+func testGetUserRoles(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -818,6 +908,10 @@ func TestGetUserRoles(t *testing.T) {
 }
 
 func TestGetRolePermissions(t *testing.T) {
+	forEachDatabase(t, testGetRolePermissions)
+}
+
+func testGetRolePermissions(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -852,6 +946,10 @@ func TestGetRolePermissions(t *testing.T) {
 	if len(rolePermissions) != 2 {
 		t.Error("failed test get role permissions", err)
 	}
+	_, err = auth.GetRolePermissions("role-aa")
+	if !errors.Is(err, authority.ErrRoleNotFound) {
+		t.Error("expected ErrRoleNotFound for a missing role", err)
+	}
 	var r authority.Role
 	db.Where("slug = ?", "role-a").First(&r)
 	db.Where("role_id = ?", r.ID).Delete(authority.RolePermission{})
@@ -861,6 +959,10 @@ func TestGetRolePermissions(t *testing.T) {
 }
 
 func TestTransaction(t *testing.T) {
+	forEachDatabase(t, testTransaction)
+}
+
+func testTransaction(t *testing.T) {
 	auth := authority.New(authority.Options{
 		TablesPrefix: "authority_",
 		DB:           db,
@@ -941,3 +1043,1601 @@ func TestTransaction(t *testing.T) {
 		db.Where("slug = ?", "permission-b").Delete(&authority.Permission{})
 	})
 }
+
+func TestImportPolicy(t *testing.T) {
+	forEachDatabase(t, testImportPolicy)
+}
+
+func testImportPolicy(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission A", Slug: "permission-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission B", Slug: "permission-b"})
+	auth.AssignPermissionsToRole("role-a", []string{"permission-a"})
+	auth.AssignRoleToUser(1, "role-a")
+
+	doc := `
+permissions:
+  - {name: Permission B, slug: permission-b}
+  - {name: Permission C, slug: permission-c}
+roles:
+  - name: Role A Renamed
+    slug: role-a
+    permissions: [permission-b]
+  - name: Role B
+    slug: role-b
+    permissions: [permission-b, permission-c]
+users:
+  - id: "1"
+    roles: [role-b]
+`
+	// test dry run
+	changes, err := auth.DiffPolicy(strings.NewReader(doc))
+	if err != nil {
+		t.Error("an error was not expected while diffing the policy", err)
+	}
+	if len(changes) != 9 {
+		t.Error("unexpected policy diff", changes)
+	}
+	ok, _ := auth.CheckRolePermission("role-a", "permission-a")
+	if !ok {
+		t.Error("the dry run should not apply changes")
+	}
+
+	// test import
+	changes, err = auth.ImportPolicy(strings.NewReader(doc))
+	if err != nil {
+		t.Error("an error was not expected while importing the policy", err)
+	}
+	if len(changes) != 9 {
+		t.Error("unexpected applied changes", changes)
+	}
+	ok, _ = auth.CheckRolePermission("role-a", "permission-a")
+	if ok {
+		t.Error("expected the extra grant to be revoked")
+	}
+	ok, _ = auth.CheckRolePermission("role-b", "permission-c")
+	if !ok {
+		t.Error("expected the new role to be granted the new permission")
+	}
+	ok, _ = auth.CheckUserRole(1, "role-a")
+	if ok {
+		t.Error("expected the extra user role to be revoked")
+	}
+	ok, _ = auth.CheckUserRole(1, "role-b")
+	if !ok {
+		t.Error("expected the user to be assigned the new role")
+	}
+	var role authority.Role
+	db.Where("slug = ?", "role-a").First(&role)
+	if role.Name != "Role A Renamed" {
+		t.Error("expected the role to be renamed")
+	}
+
+	// test importing the same document again
+	changes, _ = auth.DiffPolicy(strings.NewReader(doc))
+	if len(changes) != 0 {
+		t.Error("expected no changes after importing the policy", changes)
+	}
+
+	// test the import is atomic
+	_, err = auth.ImportPolicy(strings.NewReader(`
+roles:
+  - {name: Role C, slug: role-c, permissions: [permission-x]}
+`))
+	if err == nil {
+		t.Error("expected an error when importing an unknown permission")
+	}
+	var c int64
+	db.Model(authority.Role{}).Where("slug = ?", "role-c").Count(&c)
+	if c != 0 {
+		t.Error("failed import should not create roles")
+	}
+
+	// the expired assignment is replaced, not duplicated
+	auth.AssignRoleToUserUntil(2, "role-a", time.Now().Add(time.Hour))
+	db.Model(authority.UserRole{}).Where("user_id = ?", "2").Update("expires_at", time.Now().Add(-time.Minute))
+	_, err = auth.ImportPolicy(strings.NewReader(`
+users:
+  - id: "2"
+    roles: [role-a]
+`))
+	if err != nil {
+		t.Error("an error was not expected while assigning a role whose assignment expired", err)
+	}
+	var userRoles []authority.UserRole
+	db.Where("user_id = ?", "2").Find(&userRoles)
+	if len(userRoles) != 1 || userRoles[0].ExpiresAt != nil {
+		t.Error("expected the expired assignment to be replaced", userRoles)
+	}
+
+	t.Cleanup(func() {
+		db.Where("user_id IN (?)", []string{"1", "2"}).Delete(authority.UserRole{})
+		db.Where("user_id IN (?)", []string{"1", "2"}).Delete(authority.UserLock{})
+		db.Where("1 = 1").Delete(authority.RolePermission{})
+		db.Where("slug IN (?)", []string{"role-a", "role-b"}).Delete(authority.Role{})
+		db.Where("slug IN (?)", []string{"permission-a", "permission-b", "permission-c"}).Delete(authority.Permission{})
+	})
+}
+
+func TestExportPolicy(t *testing.T) {
+	forEachDatabase(t, testExportPolicy)
+}
+
+func testExportPolicy(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission A", Slug: "permission-a"})
+	auth.AssignPermissionsToRole("role-a", []string{"permission-a"})
+	auth.AssignRoleToUser(1, "role-a")
+
+	var b strings.Builder
+	err := auth.ExportPolicy(&b)
+	if err != nil {
+		t.Error("an error was not expected while exporting the policy", err)
+	}
+	expected := `permissions:
+  - name: Permission A
+    slug: permission-a
+roles:
+  - name: Role A
+    slug: role-a
+    permissions:
+      - permission-a
+users:
+  - id: "1"
+    roles:
+      - role-a
+`
+	if b.String() != expected {
+		t.Error("unexpected exported policy", b.String())
+	}
+
+	// the export imports back without changes
+	changes, err := auth.DiffPolicy(strings.NewReader(b.String()))
+	if err != nil || len(changes) != 0 {
+		t.Error("expected the exported policy to match the database", changes, err)
+	}
+
+	t.Cleanup(func() {
+		db.Where("user_id = ?", "1").Delete(authority.UserRole{})
+		db.Where("1 = 1").Delete(authority.RolePermission{})
+		db.Where("slug = ?", "role-a").Delete(authority.Role{})
+		db.Where("slug = ?", "permission-a").Delete(authority.Permission{})
+	})
+}
+
+func TestExplainUserPermission(t *testing.T) {
+	forEachDatabase(t, testExplainUserPermission)
+}
+
+func testExplainUserPermission(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.CreateRole(authority.Role{Name: "Role B", Slug: "role-b"})
+	auth.CreatePermission(authority.Permission{Name: "Permission A", Slug: "permission-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission B", Slug: "permission-b"})
+	auth.AssignPermissionsToRole("role-a", []string{"permission-a"})
+	auth.AssignPermissionsToRole("role-b", []string{"permission-a"})
+	auth.AssignRoleToUser(1, "role-a")
+	auth.AssignRoleToUser(1, "role-b")
+
+	e, err := auth.ExplainUserPermission(1, "permission-a")
+	if err != nil {
+		t.Error("an error was not expected while explaining the permission", err)
+	}
+	if !e.Allowed || len(e.Roles) != 2 || e.Roles[0].Slug != "role-a" || e.Roles[1].Slug != "role-b" {
+		t.Error("expected the permission to be granted by both roles", e)
+	}
+
+	e, err = auth.ExplainUserPermission(1, "permission-b")
+	if err != nil {
+		t.Error("an error was not expected while explaining the permission", err)
+	}
+	if e.Allowed || len(e.Roles) != 0 {
+		t.Error("expected the permission not to be granted", e)
+	}
+
+	_, err = auth.ExplainUserPermission(1, "permission-x")
+	if !errors.Is(err, authority.ErrPermissionNotFound) {
+		t.Error("expected ErrPermissionNotFound", err)
+	}
+
+	t.Cleanup(func() {
+		db.Where("user_id = ?", "1").Delete(authority.UserRole{})
+		db.Where("1 = 1").Delete(authority.RolePermission{})
+		db.Where("slug IN (?)", []string{"role-a", "role-b"}).Delete(authority.Role{})
+		db.Where("slug IN (?)", []string{"permission-a", "permission-b"}).Delete(authority.Permission{})
+	})
+}
+
+func TestGetRoleUsers(t *testing.T) {
+	forEachDatabase(t, testGetRoleUsers)
+}
+
+func testGetRoleUsers(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.AssignRoleToUser(1, "role-a")
+	auth.AssignRoleToUser(2, "role-a")
+
+	users, err := auth.GetRoleUsers("role-a")
+	if err != nil {
+		t.Error("an error was not expected while getting the role users", err)
+	}
+	if len(users) != 2 || users[0] != "1" || users[1] != "2" {
+		t.Error("failed test get role users", users)
+	}
+
+	_, err = auth.GetRoleUsers("role-x")
+	if !errors.Is(err, authority.ErrRoleNotFound) {
+		t.Error("expected ErrRoleNotFound", err)
+	}
+
+	t.Cleanup(func() {
+		db.Where("user_id IN (?)", []string{"1", "2"}).Delete(authority.UserRole{})
+		db.Where("slug = ?", "role-a").Delete(authority.Role{})
+	})
+}
+
+func TestDialectBehavior(t *testing.T) {
+	forEachDatabase(t, testDialectBehavior)
+}
+
+func testDialectBehavior(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+
+	// user ids are stored as strings, numeric and string ids are the same user
+	err := auth.AssignRoleToUser(1, "role-a")
+	if err != nil {
+		t.Error("an error was not expected while assigning the role", err)
+	}
+	ok, _ := auth.CheckUserRole("1", "role-a")
+	if !ok {
+		t.Error("expected the string user id to match the numeric one")
+	}
+	err = auth.AssignRoleToUser("1", "role-a")
+	if !errors.Is(err, authority.ErrRoleAssigned) {
+		t.Error("expected ErrRoleAssigned", err)
+	}
+
+	// slugs are compared with the collation of the database,
+	// the default mysql collation is case insensitive
+	err = auth.CreateRole(authority.Role{Name: "Role A", Slug: "ROLE-A"})
+	if dialect == testdb.MySQL {
+		if !errors.Is(err, authority.ErrRoleExists) {
+			t.Error("expected mysql to find the role case insensitively", err)
+		}
+	} else if err != nil {
+		t.Error("expected slugs to be case sensitive", err)
+	}
+
+	t.Cleanup(func() {
+		db.Where("user_id = ?", "1").Delete(authority.UserRole{})
+		db.Where("slug IN (?)", []string{"role-a", "ROLE-A"}).Delete(authority.Role{})
+	})
+}
+
+// recordingMetrics keeps the observed outcomes by method
+type recordingMetrics struct {
+	decisions map[string][]string
+	mutations map[string][]string
+}
+
+func (m *recordingMetrics) ObserveDecision(method string, outcome string, elapsed time.Duration) {
+	m.decisions[method] = append(m.decisions[method], outcome)
+}
+
+func (m *recordingMetrics) ObserveMutation(method string, outcome string, elapsed time.Duration) {
+	m.mutations[method] = append(m.mutations[method], outcome)
+}
+
+func TestMetrics(t *testing.T) {
+	forEachDatabase(t, testMetrics)
+}
+
+func testMetrics(t *testing.T) {
+	m := &recordingMetrics{decisions: map[string][]string{}, mutations: map[string][]string{}}
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+		Metrics:      m,
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.AssignRoleToUser(1, "role-a")
+	auth.CheckUserRole(1, "role-a")
+	auth.CheckUserRole(2, "role-a")
+	auth.CheckUserRole(1, "role-x")
+
+	if fmt.Sprint(m.mutations["CreateRole"]) != "[success error]" {
+		t.Error("failed test create role metrics", m.mutations["CreateRole"])
+	}
+	if fmt.Sprint(m.mutations["AssignRoleToUser"]) != "[success]" {
+		t.Error("failed test assign role metrics", m.mutations["AssignRoleToUser"])
+	}
+	if fmt.Sprint(m.decisions["CheckUserRole"]) != "[allowed denied error]" {
+		t.Error("failed test check role metrics", m.decisions["CheckUserRole"])
+	}
+
+	// the transaction instances report to the same metrics
+	tx := auth.BeginTX()
+	tx.RevokeUserRole(1, "role-a")
+	tx.Rollback()
+	if fmt.Sprint(m.mutations["RevokeUserRole"]) != "[success]" {
+		t.Error("failed test transaction metrics", m.mutations["RevokeUserRole"])
+	}
+
+	t.Cleanup(func() {
+		db.Where("user_id = ?", "1").Delete(authority.UserRole{})
+		db.Where("slug = ?", "role-a").Delete(authority.Role{})
+	})
+}
+
+func TestTracing(t *testing.T) {
+	forEachDatabase(t, testTracing)
+}
+
+func testTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	auth := authority.New(authority.Options{
+		TablesPrefix:      "authority_",
+		DB:                db,
+		Tracer:            provider.Tracer("authority_test"),
+		HashTracedUserIDs: true,
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission A", Slug: "permission-a"})
+	auth.AssignPermissionsToRole("role-a", []string{"permission-a"})
+	auth.AssignRoleToUser(1, "role-a")
+
+	ctx, parent := provider.Tracer("authority_test").Start(context.Background(), "request")
+	auth.WithContext(ctx).CheckUserPermission(1, "permission-a")
+	auth.WithContext(ctx).CheckUserPermission(1, "permission-x")
+	parent.End()
+
+	var checks []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "authority.CheckUserPermission" {
+			checks = append(checks, span)
+		}
+	}
+	if len(checks) != 2 {
+		t.Fatal("expected two check spans", len(checks))
+	}
+
+	sum := sha256.Sum256([]byte("1"))
+	attrs := attribute.NewSet(checks[0].Attributes()...)
+	if v, _ := attrs.Value(authority.AttrUserID); v.AsString() != hex.EncodeToString(sum[:]) {
+		t.Error("expected the hashed user id", v.AsString())
+	}
+	if v, _ := attrs.Value(authority.AttrPermission); v.AsString() != "permission-a" {
+		t.Error("expected the permission slug", v.AsString())
+	}
+	if v, _ := attrs.Value(authority.AttrDecision); !v.AsBool() {
+		t.Error("expected the allowed decision")
+	}
+	if v, _ := attrs.Value(authority.AttrQueryCount); v.AsInt64() != 3 {
+		t.Error("expected three queries", v.AsInt64())
+	}
+	if checks[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("expected the span to be a child of the context span")
+	}
+	if checks[1].Status().Code != codes.Error {
+		t.Error("expected the failed check to have the error status", checks[1].Status())
+	}
+
+	t.Cleanup(func() {
+		db.Where("user_id = ?", "1").Delete(authority.UserRole{})
+		db.Where("1 = 1").Delete(authority.RolePermission{})
+		db.Where("slug = ?", "role-a").Delete(authority.Role{})
+		db.Where("slug = ?", "permission-a").Delete(authority.Permission{})
+	})
+}
+
+func TestDecisionLogging(t *testing.T) {
+	forEachDatabase(t, testDecisionLogging)
+}
+
+func testDecisionLogging(t *testing.T) {
+	var buf bytes.Buffer
+	auth := authority.New(authority.Options{
+		TablesPrefix:    "authority_",
+		DB:              db,
+		Logger:          slog.New(slog.NewJSONHandler(&buf, nil)),
+		LogAllowedEvery: 2,
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.AssignRoleToUser(1, "role-a")
+	for i := 0; i < 4; i++ {
+		auth.CheckUserRole(1, "role-a")
+	}
+	auth.CheckUserRole(2, "role-a")
+	auth.CheckUserRole(2, "role-x")
+
+	var events []map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var event map[string]interface{}
+		if err := dec.Decode(&event); err != nil {
+			t.Fatal("failed to decode the log event", err)
+		}
+		events = append(events, event)
+	}
+
+	var outcomes []string
+	for _, event := range events {
+		outcomes = append(outcomes, fmt.Sprintf("%v %v %v", event["level"], event["method"], event["outcome"]))
+	}
+	expected := []string{
+		"INFO CreateRole success",
+		"INFO AssignRoleToUser success",
+		// one of every two allowed checks
+		"INFO CheckUserRole allowed",
+		"INFO CheckUserRole allowed",
+		"WARN CheckUserRole denied",
+		"ERROR CheckUserRole error",
+	}
+	if strings.Join(outcomes, "\n") != strings.Join(expected, "\n") {
+		t.Error("unexpected log events", outcomes)
+	}
+	if len(events) == len(expected) {
+		denied := events[4]
+		if denied["msg"] != "authority decision" || denied["user_id"] != "2" || denied["role"] != "role-a" {
+			t.Error("unexpected denied event", denied)
+		}
+		if events[5]["error"] != authority.ErrRoleNotFound.Error() {
+			t.Error("expected the error of the failed check", events[5])
+		}
+	}
+
+	t.Cleanup(func() {
+		db.Where("user_id = ?", "1").Delete(authority.UserRole{})
+		db.Where("slug = ?", "role-a").Delete(authority.Role{})
+	})
+}
+
+func TestEvents(t *testing.T) {
+	forEachDatabase(t, testEvents)
+}
+
+func testEvents(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+	})
+	var assigned, all []authority.Event
+	auth.OnRoleAssigned(func(e authority.Event) {
+		assigned = append(assigned, e)
+	})
+	auth.OnEvent(func(e authority.Event) {
+		all = append(all, e)
+	})
+	events, unsubscribe := auth.Subscribe(10)
+
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission A", Slug: "permission-a"})
+	auth.AssignPermissionsToRole("role-a", []string{"permission-a"})
+	auth.AssignRoleToUser(1, "role-a")
+	auth.AssignRoleToUser(1, "role-a") // already assigned, no event
+	auth.RevokeUserRole(1, "role-a")
+	auth.RevokeUserRole(1, "role-a") // not assigned, no event
+
+	if len(assigned) != 1 || assigned[0].UserID != "1" || assigned[0].Role != "role-a" || assigned[0].Time.IsZero() {
+		t.Error("failed test role assigned hook", assigned)
+	}
+	var types []string
+	for _, e := range all {
+		types = append(types, string(e.Type))
+	}
+	if strings.Join(types, ",") != "role.created,permission.created,permission.granted,role.assigned,role.revoked" {
+		t.Error("unexpected events", types)
+	}
+	for i := 0; i < len(all); i++ {
+		if e := <-events; e.Type != all[i].Type {
+			t.Error("expected the subscriber to receive the same events", e)
+		}
+	}
+
+	// the events of a transaction are emitted on commit, and dropped on rollback
+	tx := auth.BeginTX()
+	tx.AssignRoleToUser(2, "role-a")
+	if len(assigned) != 1 {
+		t.Error("expected the event to wait for the commit")
+	}
+	tx.Rollback()
+	tx = auth.BeginTX()
+	tx.AssignRoleToUser(3, "role-a")
+	tx.Commit()
+	if len(assigned) != 2 || assigned[1].UserID != "3" {
+		t.Error("expected only the committed event", assigned)
+	}
+
+	// ending the subscription closes the channel and stops blocking the changes
+	unsubscribe()
+	for range events {
+	}
+	auth.RevokeUserRole(3, "role-a")
+
+	// hooks can register hooks and subscribe without deadlocking
+	var nested []authority.Event
+	auth.OnRoleAssigned(func(e authority.Event) {
+		auth.OnRoleRevoked(func(e authority.Event) { nested = append(nested, e) })
+		_, unsubscribe := auth.Subscribe(1)
+		unsubscribe()
+	})
+	// a change blocked on a full subscription is released when it ends
+	blocked, unsubscribeBlocked := auth.Subscribe(0)
+	wait := func(done chan struct{}) {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the change not to deadlock")
+		}
+	}
+	assignedDone := make(chan struct{})
+	go func() {
+		auth.AssignRoleToUser(4, "role-a")
+		close(assignedDone)
+	}()
+	<-blocked
+	wait(assignedDone)
+	revokedDone := make(chan struct{})
+	go func() {
+		auth.RevokeUserRole(4, "role-a")
+		close(revokedDone)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	unsubscribeBlocked()
+	wait(revokedDone)
+	if len(nested) != 1 || nested[0].UserID != "4" {
+		t.Error("expected the hook registered by a hook to receive the next change", nested)
+	}
+
+	t.Cleanup(func() {
+		db.Where("user_id IN (?)", []string{"1", "2", "3", "4"}).Delete(authority.UserRole{})
+		db.Where("1 = 1").Delete(authority.RolePermission{})
+		db.Where("slug = ?", "role-a").Delete(authority.Role{})
+		db.Where("slug = ?", "permission-a").Delete(authority.Permission{})
+	})
+}
+
+func TestOutbox(t *testing.T) {
+	forEachDatabase(t, testOutbox)
+}
+
+func testOutbox(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+		Outbox:       true,
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.AssignRoleToUser(1, "role-a")
+	auth.AssignRoleToUser(1, "role-a") // already assigned, not stored
+
+	// the events of a rolled back transaction are not stored
+	tx := auth.BeginTX()
+	tx.RevokeUserRole(1, "role-a")
+	tx.Rollback()
+	tx = auth.BeginTX()
+	tx.RevokeUserRole(1, "role-a")
+	tx.Commit()
+
+	var events []authority.OutboxEvent
+	db.Order("id").Find(&events)
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	if strings.Join(types, ",") != "role.created,role.assigned,role.revoked" {
+		t.Error("unexpected outbox events", types)
+	}
+	if len(events) == 3 {
+		e := events[2].Event()
+		if e.UserID != "1" || e.Role != "role-a" || events[2].DeliveredAt != nil || events[2].NextAttemptAt.IsZero() {
+			t.Error("unexpected revoke event", events[2])
+		}
+	}
+
+	t.Cleanup(func() {
+		db.Where("1 = 1").Delete(authority.OutboxEvent{})
+		db.Where("slug = ?", "role-a").Delete(authority.Role{})
+	})
+}
+
+func TestSoD(t *testing.T) {
+	forEachDatabase(t, testSoD)
+}
+
+func testSoD(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+	})
+	auth.CreateRole(authority.Role{Name: "Payments Initiator", Slug: "payments-initiator"})
+	auth.CreateRole(authority.Role{Name: "Payments Approver", Slug: "payments-approver"})
+	auth.CreateRole(authority.Role{Name: "Auditor", Slug: "auditor"})
+	// user 2 holds both roles before the constraint
+	auth.AssignRoleToUser(2, "payments-initiator")
+	auth.AssignRoleToUser(2, "payments-approver")
+	// the initiator role of user 4 expired
+	auth.AssignRoleToUserUntil(4, "payments-initiator", time.Now().Add(time.Hour))
+	auth.AssignRoleToUser(4, "payments-approver")
+	db.Model(authority.UserRole{}).Where("user_id = ?", "4").Where("expires_at IS NOT NULL").Update("expires_at", time.Now().Add(-time.Minute))
+	auth.AssignRoleToUserUntil(5, "payments-initiator", time.Now().Add(time.Hour))
+	db.Model(authority.UserRole{}).Where("user_id = ?", "5").Update("expires_at", time.Now().Add(-time.Minute))
+
+	events, unsubscribe := auth.Subscribe(10)
+	err := auth.AddSoDConstraint("payments-approver", "payments-initiator")
+	if err != nil {
+		t.Error("an error was not expected while adding the constraint", err)
+	}
+	if e := <-events; e.Type != authority.EventSoDAdded || e.Role != "payments-approver" || e.ConflictingRole != "payments-initiator" {
+		t.Error("expected a sod.added event", e)
+	}
+	err = auth.AddSoDConstraint("payments-initiator", "payments-approver")
+	if !errors.Is(err, authority.ErrSoDConstraintExists) {
+		t.Error("expected ErrSoDConstraintExists", err)
+	}
+	err = auth.AddSoDConstraint("payments-initiator", "role-x")
+	if !errors.Is(err, authority.ErrRoleNotFound) {
+		t.Error("expected ErrRoleNotFound", err)
+	}
+	conflicts, _ := auth.GetSoDConstraints()
+	if len(conflicts) != 1 || conflicts[0].Role != "payments-approver" || conflicts[0].ConflictingRole != "payments-initiator" {
+		t.Error("unexpected constraints", conflicts)
+	}
+
+	auth.AssignRoleToUser(1, "payments-initiator")
+	err = auth.AssignRoleToUser(1, "payments-approver")
+	var sodErr *authority.SoDError
+	if !errors.Is(err, authority.ErrSoDViolation) || !errors.As(err, &sodErr) {
+		t.Fatal("expected a SoDError", err)
+	}
+	if sodErr.UserID != "1" || sodErr.Role != "payments-approver" || sodErr.ConflictingRole != "payments-initiator" {
+		t.Error("unexpected violation", sodErr)
+	}
+	if ok, _ := auth.CheckUserRole(1, "payments-approver"); ok {
+		t.Error("expected the role not to be assigned")
+	}
+	if err := auth.AssignRoleToUser(1, "auditor"); err != nil {
+		t.Error("an error was not expected while assigning an unrelated role", err)
+	}
+
+	violations, err := auth.ValidateSoD()
+	if err != nil {
+		t.Error("an error was not expected while validating", err)
+	}
+	if len(violations) != 1 || violations[0].UserID != "2" {
+		t.Error("expected the existing violation to be reported without the expired assignments", violations)
+	}
+	if err := auth.AssignRoleToUser(5, "payments-approver"); err != nil {
+		t.Error("an error was not expected while assigning a role excluded by an expired one", err)
+	}
+
+	_, err = auth.ImportPolicy(strings.NewReader(`users:
+  - id: "3"
+    roles: [payments-approver, payments-initiator]
+`))
+	if !errors.Is(err, authority.ErrSoDViolation) {
+		t.Error("expected the import to be rejected", err)
+	}
+
+	err = auth.RemoveSoDConstraint("payments-initiator", "payments-approver")
+	if err != nil {
+		t.Error("an error was not expected while removing the constraint", err)
+	}
+	for e := range events {
+		if e.Type == authority.EventRoleAssigned {
+			continue
+		}
+		if e.Type != authority.EventSoDRemoved || e.Role != "payments-initiator" || e.ConflictingRole != "payments-approver" {
+			t.Error("expected a sod.removed event", e)
+		}
+		break
+	}
+	unsubscribe()
+	err = auth.RemoveSoDConstraint("payments-initiator", "payments-approver")
+	if !errors.Is(err, authority.ErrSoDConstraintNotFound) {
+		t.Error("expected ErrSoDConstraintNotFound", err)
+	}
+	if err := auth.AssignRoleToUser(1, "payments-approver"); err != nil {
+		t.Error("an error was not expected once the constraint is removed", err)
+	}
+
+	t.Cleanup(func() {
+		db.Where("1 = 1").Delete(authority.SoDConstraint{})
+		db.Where("user_id IN (?)", []string{"1", "2", "3", "4", "5"}).Delete(authority.UserRole{})
+		db.Where("user_id IN (?)", []string{"1", "2", "3", "4", "5"}).Delete(authority.UserLock{})
+		db.Where("slug IN (?)", []string{"payments-initiator", "payments-approver", "auditor"}).Delete(authority.Role{})
+	})
+}
+
+func TestCardinality(t *testing.T) {
+	forEachDatabase(t, testCardinality)
+}
+
+func testCardinality(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix:    "authority_",
+		DB:              db,
+		MaxRolesPerUser: 2,
+	})
+	auth.CreateRole(authority.Role{Name: "Super Admin", Slug: "super-admin", MaxHolders: 2})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.CreateRole(authority.Role{Name: "Role B", Slug: "role-b"})
+
+	auth.AssignRoleToUser(1, "super-admin")
+	auth.AssignRoleToUser(2, "super-admin")
+	err := auth.AssignRoleToUser(3, "super-admin")
+	var cardErr *authority.CardinalityError
+	if !errors.Is(err, authority.ErrRoleHoldersExceeded) || !errors.As(err, &cardErr) || cardErr.Limit != 2 || cardErr.Role != "super-admin" {
+		t.Error("expected the role holders limit error", err)
+	}
+
+	// the user limit
+	auth.AssignRoleToUser(1, "role-a")
+	err = auth.AssignRoleToUser(1, "role-b")
+	if !errors.Is(err, authority.ErrUserRolesExceeded) || !errors.As(err, &cardErr) || cardErr.UserID != "1" {
+		t.Error("expected the user roles limit error", err)
+	}
+	if ok, _ := auth.CheckUserRole(1, "role-b"); ok {
+		t.Error("expected the role not to be assigned")
+	}
+
+	// revoking frees a place, raising the limit allows more holders
+	auth.RevokeUserRole(2, "super-admin")
+	if err := auth.AssignRoleToUser(3, "super-admin"); err != nil {
+		t.Error("an error was not expected after a holder was revoked", err)
+	}
+	events, unsubscribe := auth.Subscribe(1)
+	if err := auth.SetRoleMaxHolders("super-admin", 3); err != nil {
+		t.Error("an error was not expected while setting the limit", err)
+	}
+	if e := <-events; e.Type != authority.EventRoleMaxHoldersSet || e.Role != "super-admin" || e.MaxHolders != 3 {
+		t.Error("expected a role.max_holders_set event", e)
+	}
+	unsubscribe()
+	// setting the same limit again is not a missing role
+	if err := auth.SetRoleMaxHolders("super-admin", 3); err != nil {
+		t.Error("an error was not expected while setting the same limit", err)
+	}
+	if err := auth.AssignRoleToUser(2, "super-admin"); err != nil {
+		t.Error("an error was not expected after raising the limit", err)
+	}
+	if err := auth.SetRoleMaxHolders("role-x", 3); !errors.Is(err, authority.ErrRoleNotFound) {
+		t.Error("expected ErrRoleNotFound", err)
+	}
+
+	// policy imports are checked too
+	_, err = auth.ImportPolicy(strings.NewReader(`users:
+  - id: "4"
+    roles: [super-admin]
+`))
+	if !errors.Is(err, authority.ErrRoleHoldersExceeded) {
+		t.Error("expected the import to be rejected", err)
+	}
+
+	// the expired assignments do not count
+	db.Model(authority.UserRole{}).Where("user_id = ?", "3").Update("expires_at", time.Now().Add(-time.Minute))
+	if err := auth.AssignRoleToUser(4, "super-admin"); err != nil {
+		t.Error("an error was not expected while an expired assignment holds the role", err)
+	}
+
+	// concurrent assignments to a user cannot exceed its limit
+	auth.CreateRole(authority.Role{Name: "Role C", Slug: "role-c"})
+	roles := []string{"role-a", "role-b", "role-c"}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	assigned := 0
+	for _, role := range roles {
+		wg.Add(1)
+		go func(role string) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				err := auth.AssignRoleToUser(5, role)
+				if err == nil {
+					mu.Lock()
+					assigned++
+					mu.Unlock()
+					return
+				}
+				var cardErr *authority.CardinalityError
+				if errors.As(err, &cardErr) {
+					return
+				}
+				// the database refused the concurrent transaction, try again
+				time.Sleep(10 * time.Millisecond)
+			}
+			t.Error("expected the assignment to be decided")
+		}(role)
+	}
+	wg.Wait()
+	var held int64
+	db.Model(authority.UserRole{}).Where("user_id = ?", "5").Count(&held)
+	if assigned != 2 || held != 2 {
+		t.Error("expected the user limit to hold under concurrent assignments", assigned, held)
+	}
+
+	// a policy import and a concurrent assignment cannot both take the last place of a role
+	auth.SetRoleMaxHolders("super-admin", 4)
+	decided := func(err error) bool {
+		var cardErr *authority.CardinalityError
+		return err == nil || errors.As(err, &cardErr)
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			_, err := auth.ImportPolicy(strings.NewReader(`users:
+  - id: "6"
+    roles: [super-admin]
+`))
+			if decided(err) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Error("expected the import to be decided")
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			if decided(auth.AssignRoleToUser(7, "super-admin")) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Error("expected the assignment to be decided")
+	}()
+	wg.Wait()
+	var holders int64
+	db.Model(authority.UserRole{}).Where("user_id IN (?)", []string{"6", "7"}).Count(&holders)
+	if holders != 1 {
+		t.Error("expected the holders limit to hold under a concurrent import", holders)
+	}
+
+	t.Cleanup(func() {
+		db.Where("user_id IN (?)", []string{"1", "2", "3", "4", "5", "6", "7"}).Delete(authority.UserRole{})
+		db.Where("user_id IN (?)", []string{"1", "2", "3", "4", "5", "6", "7"}).Delete(authority.UserLock{})
+		db.Where("slug IN (?)", []string{"super-admin", "role-a", "role-b", "role-c"}).Delete(authority.Role{})
+	})
+}
+
+func TestPrivilegeEscalation(t *testing.T) {
+	forEachDatabase(t, testPrivilegeEscalation)
+}
+
+func testPrivilegeEscalation(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+	})
+	auth.CreateRole(authority.Role{Name: "Admin", Slug: "admin"})
+	auth.CreateRole(authority.Role{Name: "Editor", Slug: "editor"})
+	auth.CreateRole(authority.Role{Name: "Delegate", Slug: "delegate"})
+	auth.CreatePermission(authority.Permission{Name: "Edit Posts", Slug: "edit-posts"})
+	auth.CreatePermission(authority.Permission{Name: "Delete Users", Slug: "delete-users"})
+	auth.CreatePermission(authority.Permission{Name: "Grant Admin", Slug: authority.GrantPermission("admin")})
+	auth.EnsureMetaPermissions()
+	auth.AssignPermissionsToRole("admin", []string{"edit-posts", "delete-users"})
+	auth.AssignPermissionsToRole("editor", []string{"edit-posts", authority.PermissionRolesManage, authority.AssignPermission("editor"), authority.AssignPermission("admin")})
+	auth.AssignRoleToUser(1, "editor")
+
+	// the editor holds the permissions of the editor role, not of the admin role
+	if err := auth.AssignRoleToUserAs(1, 2, "editor"); err != nil {
+		t.Error("an error was not expected while assigning a role with held permissions", err)
+	}
+	err := auth.AssignRoleToUserAs(1, 1, "admin")
+	if !errors.Is(err, authority.ErrPrivilegeEscalation) {
+		t.Error("expected ErrPrivilegeEscalation", err)
+	}
+	if ok, _ := auth.CheckUserRole(1, "admin"); ok {
+		t.Error("expected the role not to be assigned")
+	}
+	err = auth.AssignPermissionsToRoleAs(1, "editor", []string{"delete-users"})
+	if !errors.Is(err, authority.ErrPrivilegeEscalation) {
+		t.Error("expected ErrPrivilegeEscalation", err)
+	}
+	err = auth.AssignRoleToUserAs(1, 2, "role-x")
+	if !errors.Is(err, authority.ErrRoleNotFound) {
+		t.Error("expected ErrRoleNotFound", err)
+	}
+
+	// the grant meta-permission allows to grant the role without holding its permissions
+	auth.AssignPermissionsToRole("delegate", []string{authority.GrantPermission("admin")})
+	auth.AssignRoleToUser(3, "delegate")
+	if err := auth.AssignRoleToUserAs(3, 4, "admin"); err != nil {
+		t.Error("an error was not expected with the grant meta-permission", err)
+	}
+	auth.CreatePermission(authority.Permission{Name: "View Users", Slug: "view-users"})
+	if err := auth.AssignPermissionsToRoleAs(3, "admin", []string{"view-users"}); err != nil {
+		t.Error("an error was not expected while granting with the grant meta-permission", err)
+	}
+	if err := auth.AssignPermissionsToRoleAs(3, "editor", []string{"delete-users"}); !errors.Is(err, authority.ErrActorNotAllowed) {
+		t.Error("expected the meta-permission to be limited to its role", err)
+	}
+
+	// meta-permissions cannot be granted on behalf of an actor, so grants cannot be chained
+	auth.AssignPermissionsToRole("editor", []string{authority.GrantPermission("editor")})
+	err = auth.AssignPermissionsToRoleAs(1, "editor", []string{authority.GrantPermission("admin")})
+	if !errors.Is(err, authority.ErrPrivilegeEscalation) {
+		t.Error("expected granting a meta-permission to fail", err)
+	}
+	err = auth.AssignPermissionsToRoleAs(1, "editor", []string{authority.PermissionPermissionsManage})
+	if !errors.Is(err, authority.ErrPrivilegeEscalation) {
+		t.Error("expected granting a built-in meta-permission to fail", err)
+	}
+	if ok, _ := auth.CheckRolePermission("editor", authority.GrantPermission("admin")); ok {
+		t.Error("expected the grant meta-permission not to be granted")
+	}
+	if err := auth.AssignRoleToUserAs(1, 1, "admin"); !errors.Is(err, authority.ErrPrivilegeEscalation) {
+		t.Error("expected the editor not to take the admin role", err)
+	}
+
+	t.Cleanup(func() {
+		db.Where("user_id IN (?)", []string{"1", "2", "3", "4"}).Delete(authority.UserRole{})
+		db.Where("1 = 1").Delete(authority.RolePermission{})
+		db.Where("slug IN (?)", []string{"admin", "editor", "delegate"}).Delete(authority.Role{})
+		db.Where("slug IN (?) OR slug LIKE ? OR slug LIKE ?", []string{"edit-posts", "delete-users", "view-users"}, "authority.%", "grant:%").Delete(authority.Permission{})
+	})
+}
+
+func TestMetaPermissions(t *testing.T) {
+	forEachDatabase(t, testMetaPermissions)
+}
+
+func testMetaPermissions(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+	})
+	auth.CreateRole(authority.Role{Name: "Owner", Slug: "owner"})
+	auth.CreateRole(authority.Role{Name: "Editor", Slug: "editor"})
+	auth.CreateRole(authority.Role{Name: "Tenant Admin", Slug: "tenant-admin"})
+	if err := auth.EnsureMetaPermissions(); err != nil {
+		t.Fatal("an error was not expected while creating the meta-permissions", err)
+	}
+	if err := auth.EnsureMetaPermissions(); err != nil {
+		t.Error("expected creating the meta-permissions to be idempotent", err)
+	}
+	for _, slug := range []string{authority.PermissionRolesManage, authority.PermissionPermissionsManage, "authority.assign.owner", "authority.assign.editor", "grant:owner"} {
+		var count int64
+		db.Model(&authority.Permission{}).Where("slug = ?", slug).Count(&count)
+		if count != 1 {
+			t.Error("expected the meta-permission to exist", slug)
+		}
+	}
+
+	// the tenant admin can assign editor but not owner
+	auth.AssignPermissionsToRole("tenant-admin", []string{authority.AssignPermission("editor")})
+	auth.AssignRoleToUser(1, "tenant-admin")
+	if err := auth.AssignRoleToUserAs(1, 2, "editor"); err != nil {
+		t.Error("an error was not expected while assigning editor", err)
+	}
+	if err := auth.AssignRoleToUserAs(1, 2, "owner"); !errors.Is(err, authority.ErrActorNotAllowed) {
+		t.Error("expected ErrActorNotAllowed while assigning owner", err)
+	}
+	if err := auth.RevokeUserRoleAs(1, 2, "editor"); err != nil {
+		t.Error("an error was not expected while revoking editor", err)
+	}
+
+	// the tenant admin cannot manage roles and permissions
+	m := auth.As(1)
+	if err := m.CreateRole(authority.Role{Name: "Viewer", Slug: "viewer"}); !errors.Is(err, authority.ErrActorNotAllowed) {
+		t.Error("expected ErrActorNotAllowed while creating a role", err)
+	}
+	if err := m.CreatePermission(authority.Permission{Name: "View", Slug: "view"}); !errors.Is(err, authority.ErrActorNotAllowed) {
+		t.Error("expected ErrActorNotAllowed while creating a permission", err)
+	}
+	if err := m.DeleteRole("owner"); !errors.Is(err, authority.ErrActorNotAllowed) {
+		t.Error("expected ErrActorNotAllowed while deleting a role", err)
+	}
+
+	// the manage meta-permissions allow it
+	auth.AssignPermissionsToRole("owner", []string{authority.PermissionRolesManage, authority.PermissionPermissionsManage})
+	auth.AssignRoleToUser(3, "owner")
+	m = auth.As(3)
+	if err := m.CreateRole(authority.Role{Name: "Viewer", Slug: "viewer"}); err != nil {
+		t.Error("an error was not expected while creating a role", err)
+	}
+	if err := m.CreatePermission(authority.Permission{Name: "View", Slug: "view"}); err != nil {
+		t.Error("an error was not expected while creating a permission", err)
+	}
+	if err := m.AssignPermissionsToRole("viewer", []string{"view"}); !errors.Is(err, authority.ErrPrivilegeEscalation) {
+		t.Error("expected the escalation guard to still apply", err)
+	}
+	if err := m.AssignPermissionsToRole("viewer", []string{authority.PermissionPermissionsManage}); !errors.Is(err, authority.ErrPrivilegeEscalation) {
+		t.Error("expected granting a held meta-permission to fail", err)
+	}
+	auth.AssignPermissionsToRole("owner", []string{"view"})
+	if err := m.AssignPermissionsToRole("viewer", []string{"view"}); err != nil {
+		t.Error("an error was not expected while granting a held permission", err)
+	}
+	if err := m.RevokeRolePermission("viewer", "view"); err != nil {
+		t.Error("an error was not expected while revoking a permission", err)
+	}
+	auth.RevokeRolePermission("owner", "view")
+	if err := m.DeletePermission("view"); err != nil {
+		t.Error("an error was not expected while deleting a permission", err)
+	}
+	if err := m.DeleteRole("viewer"); err != nil {
+		t.Error("an error was not expected while deleting a role", err)
+	}
+
+	t.Cleanup(func() {
+		db.Where("user_id IN (?)", []string{"1", "2", "3"}).Delete(authority.UserRole{})
+		db.Where("1 = 1").Delete(authority.RolePermission{})
+		db.Where("slug IN (?)", []string{"owner", "editor", "tenant-admin", "viewer"}).Delete(authority.Role{})
+		db.Where("slug IN (?) OR slug LIKE ? OR slug LIKE ?", []string{"view"}, "authority.%", "grant:%").Delete(authority.Permission{})
+	})
+}
+
+func TestImportPolicyAs(t *testing.T) {
+	forEachDatabase(t, testImportPolicyAs)
+}
+
+func testImportPolicyAs(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+	})
+	auth.CreateRole(authority.Role{Name: "Editor", Slug: "editor"})
+	auth.CreateRole(authority.Role{Name: "Admin", Slug: "admin"})
+	auth.CreatePermission(authority.Permission{Name: "Edit Posts", Slug: "edit-posts"})
+	auth.CreatePermission(authority.Permission{Name: "Delete Users", Slug: "delete-users"})
+	auth.EnsureMetaPermissions()
+	auth.AssignPermissionsToRole("admin", []string{"delete-users"})
+	auth.CreateRole(authority.Role{Name: "Delegate", Slug: "delegate"})
+	auth.AssignPermissionsToRole("delegate", []string{"edit-posts", authority.GrantPermission("editor"), authority.AssignPermission("admin")})
+	auth.AssignRoleToUser(1, "delegate")
+	m := auth.As(1)
+
+	// the delegate manages the editor role with permissions it holds
+	doc := `
+roles:
+  - {name: Editor, slug: editor, permissions: [edit-posts]}
+users:
+  - {id: "2", roles: [editor]}
+`
+	if _, err := m.ImportPolicy(strings.NewReader(doc)); err != nil {
+		t.Error("an error was not expected while importing as the delegate", err)
+	}
+	if ok, _ := auth.CheckUserPermission(2, "edit-posts"); !ok {
+		t.Error("expected the import to be applied")
+	}
+
+	// the grant meta-permission allows to grant delete-users to the editor role without holding it
+	doc = `
+roles:
+  - {name: Editor, slug: editor, permissions: [edit-posts, delete-users]}
+`
+	if _, err := m.ImportPolicy(strings.NewReader(doc)); err != nil {
+		t.Error("an error was not expected while granting with the grant meta-permission", err)
+	}
+
+	denied := map[string]error{
+		// creating a permission needs the permissions manage meta-permission
+		"permissions:\n  - {name: View, slug: view}\n": authority.ErrActorNotAllowed,
+		// granting a meta-permission
+		"roles:\n  - {name: Editor, slug: editor, permissions: [edit-posts, grant:admin]}\n": authority.ErrPrivilegeEscalation,
+		// assigning admin needs delete-users
+		"users:\n  - {id: \"1\", roles: [delegate, admin]}\n": authority.ErrPrivilegeEscalation,
+		// the delegate cannot grant to the admin role
+		"roles:\n  - {name: Admin, slug: admin, permissions: [delete-users, edit-posts]}\n": authority.ErrActorNotAllowed,
+	}
+	for doc, expected := range denied {
+		if _, err := m.ImportPolicy(strings.NewReader(doc)); !errors.Is(err, expected) {
+			t.Errorf("expected %v importing %q, got %v", expected, doc, err)
+		}
+	}
+	if ok, _ := auth.CheckUserRole(1, "admin"); ok {
+		t.Error("expected the denied imports not to be applied")
+	}
+
+	t.Cleanup(func() {
+		db.Where("user_id IN (?)", []string{"1", "2"}).Delete(authority.UserRole{})
+		db.Where("1 = 1").Delete(authority.RolePermission{})
+		db.Where("slug IN (?)", []string{"editor", "admin", "delegate"}).Delete(authority.Role{})
+		db.Where("slug IN (?) OR slug LIKE ? OR slug LIKE ?", []string{"edit-posts", "delete-users"}, "authority.%", "grant:%").Delete(authority.Permission{})
+	})
+}
+
+func TestProtected(t *testing.T) {
+	forEachDatabase(t, testProtected)
+}
+
+func testProtected(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+	})
+	var events []authority.Event
+	auth.OnEvent(func(e authority.Event) { events = append(events, e) })
+	auth.CreateRole(authority.Role{Name: "Admin", Slug: "admin", Protected: true})
+	auth.CreatePermission(authority.Permission{Name: "Manage Users", Slug: "manage-users"})
+	auth.CreatePermission(authority.Permission{Name: "Delete Users", Slug: "delete-users", Protected: true})
+	auth.AssignPermissionsToRole("admin", []string{"manage-users"})
+
+	if err := auth.DeleteRole("admin"); !errors.Is(err, authority.ErrRoleProtected) {
+		t.Error("expected ErrRoleProtected while deleting", err)
+	}
+	if err := auth.RenameRole("admin", "Administrator"); !errors.Is(err, authority.ErrRoleProtected) {
+		t.Error("expected ErrRoleProtected while renaming", err)
+	}
+	if err := auth.RevokeRolePermission("admin", "manage-users"); !errors.Is(err, authority.ErrRoleProtected) {
+		t.Error("expected ErrRoleProtected while revoking", err)
+	}
+	if err := auth.DeletePermission("delete-users"); !errors.Is(err, authority.ErrPermissionProtected) {
+		t.Error("expected ErrPermissionProtected while deleting", err)
+	}
+	if err := auth.RenamePermission("delete-users", "Remove Users"); !errors.Is(err, authority.ErrPermissionProtected) {
+		t.Error("expected ErrPermissionProtected while renaming", err)
+	}
+	if err := auth.SetRoleProtected("admin", false); !errors.Is(err, authority.ErrRoleProtected) {
+		t.Error("expected lifting the protection to require the override", err)
+	}
+	if ok, _ := auth.CheckRolePermission("admin", "manage-users"); !ok {
+		t.Error("expected the permission to be kept")
+	}
+
+	// unprotected ones can be renamed
+	events = nil
+	if err := auth.RenamePermission("manage-users", "Administer Users"); err != nil {
+		t.Error("an error was not expected while renaming", err)
+	}
+	if len(events) != 1 || events[0].Type != authority.EventPermissionRenamed || events[0].Name != "Administer Users" {
+		t.Error("expected the renamed event", events)
+	}
+	events = nil
+	if err := auth.SetPermissionProtected("manage-users", true); err != nil {
+		t.Error("an error was not expected while protecting", err)
+	}
+	if err := auth.Override().SetPermissionProtected("manage-users", false); err != nil {
+		t.Error("an error was not expected while lifting the protection", err)
+	}
+	if len(events) != 2 || events[0].Type != authority.EventPermissionProtected || events[1].Type != authority.EventPermissionUnprotected || events[1].Permission != "manage-users" {
+		t.Error("expected the protection events", events)
+	}
+
+	// the policy import respects the protection
+	_, err := auth.ImportPolicy(strings.NewReader(`roles:
+  - name: Administrator
+    slug: admin
+`))
+	if !errors.Is(err, authority.ErrRoleProtected) {
+		t.Error("expected the import to be rejected", err)
+	}
+	var out strings.Builder
+	auth.ExportPolicy(&out)
+	if !strings.Contains(out.String(), "slug: admin\n    permissions:\n      - manage-users\n    protected: true") {
+		t.Error("expected the protection to be exported", out.String())
+	}
+
+	// the override allows the changes
+	override := auth.Override()
+	if err := override.RenameRole("admin", "Administrator"); err != nil {
+		t.Error("an error was not expected with the override", err)
+	}
+	if err := override.RevokeRolePermission("admin", "manage-users"); err != nil {
+		t.Error("an error was not expected with the override", err)
+	}
+	changes, err := override.ImportPolicy(strings.NewReader(`permissions:
+  - name: Delete Users
+    slug: delete-users
+    protected: false
+roles:
+  - name: Admin
+    slug: admin
+    protected: true
+`))
+	if err != nil || len(changes) != 2 {
+		t.Error("expected the import to rename the role and unprotect the permission", changes, err)
+	}
+	if e := events[len(events)-2]; e.Type != authority.EventPermissionUnprotected || e.Permission != "delete-users" {
+		t.Error("expected the import to emit the unprotected event", events)
+	}
+	if err := auth.DeletePermission("delete-users"); err != nil {
+		t.Error("expected the unprotected permission to be deleted", err)
+	}
+	if err := override.DeleteRole("admin"); err != nil {
+		t.Error("an error was not expected deleting with the override", err)
+	}
+
+	t.Cleanup(func() {
+		db.Where("1 = 1").Delete(authority.RolePermission{})
+		db.Where("slug IN (?)", []string{"admin"}).Delete(authority.Role{})
+		db.Where("slug IN (?)", []string{"manage-users", "delete-users"}).Delete(authority.Permission{})
+	})
+}
+
+func TestRoleExpiry(t *testing.T) {
+	forEachDatabase(t, testRoleExpiry)
+}
+
+func testRoleExpiry(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission A", Slug: "permission-a"})
+	auth.AssignPermissionsToRole("role-a", []string{"permission-a"})
+
+	if err := auth.AssignRoleToUserUntil(1, "role-a", time.Now().Add(time.Hour)); err != nil {
+		t.Error("an error was not expected while assigning the role", err)
+	}
+	if ok, _ := auth.CheckUserPermission(1, "permission-a"); !ok {
+		t.Error("expected the assignment to be active")
+	}
+	auth.AssignRoleToUserUntil(2, "role-a", time.Now().Add(-time.Minute))
+	if ok, _ := auth.CheckUserRole(2, "role-a"); ok {
+		t.Error("expected the expired role to be ignored")
+	}
+	if ok, _ := auth.CheckUserPermission(2, "permission-a"); ok {
+		t.Error("expected the expired permission to be ignored")
+	}
+	if users, _ := auth.GetRoleUsers("role-a"); len(users) != 1 || users[0] != "1" {
+		t.Error("expected the expired holder not to be listed", users)
+	}
+
+	// reassigning replaces the expired assignment
+	if err := auth.AssignRoleToUser(2, "role-a"); err != nil {
+		t.Error("an error was not expected while reassigning an expired role", err)
+	}
+	if ok, _ := auth.CheckUserRole(2, "role-a"); !ok {
+		t.Error("expected the role to be reassigned")
+	}
+
+	auth.AssignRoleToUserUntil(3, "role-a", time.Now().Add(-time.Minute))
+	var revokedEvents []authority.Event
+	auth.OnRoleRevoked(func(e authority.Event) { revokedEvents = append(revokedEvents, e) })
+	revoked, err := auth.RevokeExpiredRoles()
+	if err != nil || revoked != 1 {
+		t.Error("expected one expired assignment to be revoked", revoked, err)
+	}
+	if len(revokedEvents) != 1 || revokedEvents[0].UserID != "3" || revokedEvents[0].Role != "role-a" {
+		t.Error("expected the revoked event", revokedEvents)
+	}
+
+	t.Cleanup(func() {
+		db.Where("user_id IN (?)", []string{"1", "2", "3"}).Delete(authority.UserRole{})
+		db.Where("1 = 1").Delete(authority.RolePermission{})
+		db.Where("slug = ?", "role-a").Delete(authority.Role{})
+		db.Where("slug = ?", "permission-a").Delete(authority.Permission{})
+	})
+}
+
+func TestAccessRequests(t *testing.T) {
+	forEachDatabase(t, testAccessRequests)
+}
+
+func testAccessRequests(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+	})
+	auth.CreateRole(authority.Role{Name: "Editor", Slug: "editor"})
+	auth.CreateRole(authority.Role{Name: "Approver", Slug: "approver"})
+	auth.EnsureMetaPermissions()
+	auth.AssignPermissionsToRole("approver", []string{authority.AssignPermission("editor")})
+	auth.AssignRoleToUser("boss", "approver")
+
+	if _, err := auth.RequestRole(1, "editor", ""); !errors.Is(err, authority.ErrReasonRequired) {
+		t.Error("expected ErrReasonRequired", err)
+	}
+	if _, err := auth.RequestRole(1, "role-x", "writing"); !errors.Is(err, authority.ErrRoleNotFound) {
+		t.Error("expected ErrRoleNotFound", err)
+	}
+	request, err := auth.RequestRole(1, "editor", "writing the release notes")
+	if err != nil || request.Status != authority.RequestPending {
+		t.Fatal("an error was not expected while requesting a role", request, err)
+	}
+	if _, err := auth.RequestRole(1, "editor", "again"); !errors.Is(err, authority.ErrAccessRequestPending) {
+		t.Error("expected ErrAccessRequestPending", err)
+	}
+
+	// only the holders of the meta-permissions approve, never the requester
+	if err := auth.ApproveRequest(2, request.ID, 0); !errors.Is(err, authority.ErrActorNotAllowed) {
+		t.Error("expected ErrActorNotAllowed", err)
+	}
+	if err := auth.ApproveRequest(1, request.ID, 0); !errors.Is(err, authority.ErrActorNotAllowed) {
+		t.Error("expected the requester not to approve its own request", err)
+	}
+	if err := auth.ApproveRequest("boss", request.ID, time.Hour); err != nil {
+		t.Error("an error was not expected while approving", err)
+	}
+	if ok, _ := auth.CheckUserRole(1, "editor"); !ok {
+		t.Error("expected the role to be assigned")
+	}
+	if err := auth.ApproveRequest("boss", request.ID, 0); !errors.Is(err, authority.ErrAccessRequestDecided) {
+		t.Error("expected ErrAccessRequestDecided", err)
+	}
+	if _, err := auth.RequestRole(1, "editor", "again"); !errors.Is(err, authority.ErrRoleAssigned) {
+		t.Error("expected ErrRoleAssigned", err)
+	}
+
+	rejected, _ := auth.RequestRole(2, "editor", "curious")
+	if err := auth.RejectRequest("boss", rejected.ID, "not needed"); err != nil {
+		t.Error("an error was not expected while rejecting", err)
+	}
+	if ok, _ := auth.CheckUserRole(2, "editor"); ok {
+		t.Error("expected the rejected role not to be assigned")
+	}
+	if err := auth.RejectRequest("boss", 999999, ""); !errors.Is(err, authority.ErrAccessRequestNotFound) {
+		t.Error("expected ErrAccessRequestNotFound", err)
+	}
+
+	// the requests are kept as the audit trail
+	requests, _ := auth.GetAccessRequests("")
+	if len(requests) != 2 {
+		t.Fatal("expected the requests to be kept", requests)
+	}
+	approved := requests[0]
+	if approved.Status != authority.RequestApproved || approved.ApproverID != "boss" || approved.DecidedAt == nil || approved.ExpiresAt == nil {
+		t.Error("unexpected approved request", approved)
+	}
+	if requests[1].Status != authority.RequestRejected || requests[1].Comment != "not needed" {
+		t.Error("unexpected rejected request", requests[1])
+	}
+	if pending, _ := auth.GetAccessRequests(authority.RequestPending); len(pending) != 0 {
+		t.Error("expected no pending request", pending)
+	}
+	if mine, _ := auth.GetUserAccessRequests(2); len(mine) != 1 || mine[0].Reason != "curious" {
+		t.Error("unexpected user requests", mine)
+	}
+
+	// the approver must hold the permissions of the role, like AssignRoleToUserAs
+	auth.CreateRole(authority.Role{Name: "Admin", Slug: "admin"})
+	auth.CreatePermission(authority.Permission{Name: "Delete Users", Slug: "delete-users"})
+	auth.AssignPermissionsToRole("admin", []string{"delete-users"})
+	auth.EnsureMetaPermissions()
+	auth.AssignPermissionsToRole("approver", []string{authority.AssignPermission("admin")})
+	escalating, _ := auth.RequestRole(3, "admin", "cleaning up the users")
+	if err := auth.ApproveRequest("boss", escalating.ID, 0); !errors.Is(err, authority.ErrPrivilegeEscalation) {
+		t.Error("expected ErrPrivilegeEscalation", err)
+	}
+	if ok, _ := auth.CheckUserRole(3, "admin"); ok {
+		t.Error("expected the role not to be assigned")
+	}
+	if pending, _ := auth.GetAccessRequests(authority.RequestPending); len(pending) != 1 {
+		t.Error("expected the request to stay pending", pending)
+	}
+
+	t.Cleanup(func() {
+		db.Where("1 = 1").Delete(authority.AccessRequest{})
+		db.Where("user_id IN (?)", []string{"1", "2", "3", "boss"}).Delete(authority.UserRole{})
+		db.Where("1 = 1").Delete(authority.RolePermission{})
+		db.Where("slug IN (?)", []string{"editor", "approver", "admin"}).Delete(authority.Role{})
+		db.Where("slug IN (?) OR slug LIKE ? OR slug LIKE ?", []string{"delete-users"}, "authority.%", "grant:%").Delete(authority.Permission{})
+	})
+}
+
+func TestElevation(t *testing.T) {
+	forEachDatabase(t, testElevation)
+}
+
+func testElevation(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+		MaxElevation: 4 * time.Hour,
+	})
+	auth.CreateRole(authority.Role{Name: "Prod Writer", Slug: "prod-writer"})
+	auth.CreateRole(authority.Role{Name: "DBA", Slug: "dba"})
+	auth.CreateRole(authority.Role{Name: "On Call", Slug: "on-call"})
+	auth.CreateRole(authority.Role{Name: "Auditor", Slug: "auditor"})
+	auth.EnsureMetaPermissions()
+	auth.AssignPermissionsToRole("on-call", []string{authority.ElevatePermission("prod-writer"), authority.PermissionBreakGlass})
+	auth.AssignPermissionsToRole("auditor", []string{authority.PermissionBreakGlassReview})
+	auth.AssignRoleToUser(1, "on-call")
+	auth.AssignRoleToUser(9, "auditor")
+	var events []authority.Event
+	auth.OnEvent(func(e authority.Event) { events = append(events, e) })
+
+	if _, err := auth.Elevate(1, "prod-writer", time.Hour, ""); !errors.Is(err, authority.ErrReasonRequired) {
+		t.Error("expected ErrReasonRequired", err)
+	}
+	if _, err := auth.Elevate(1, "prod-writer", 8*time.Hour, "incident 42"); !errors.Is(err, authority.ErrElevationTooLong) {
+		t.Error("expected ErrElevationTooLong", err)
+	}
+	if _, err := auth.Elevate(1, "dba", time.Hour, "incident 42"); !errors.Is(err, authority.ErrActorNotAllowed) {
+		t.Error("expected the elevation to need its meta-permission", err)
+	}
+	if _, err := auth.Elevate(2, "prod-writer", time.Hour, "incident 42"); !errors.Is(err, authority.ErrActorNotAllowed) {
+		t.Error("expected ErrActorNotAllowed", err)
+	}
+
+	elevation, err := auth.Elevate(1, "prod-writer", time.Hour, "incident 42")
+	if err != nil {
+		t.Fatal("an error was not expected while elevating", err)
+	}
+	if ok, _ := auth.CheckUserRole(1, "prod-writer"); !ok {
+		t.Error("expected the user to be elevated")
+	}
+	if time.Until(elevation.ExpiresAt) > time.Hour || elevation.BreakGlass {
+		t.Error("unexpected elevation", elevation)
+	}
+	if len(events) != 2 || events[0].Type != authority.EventRoleAssigned || events[1].Type != authority.EventRoleElevated {
+		t.Error("expected the assigned and elevated events", events)
+	}
+
+	// break-glass takes any role and is flagged for review
+	events = nil
+	glass, err := auth.BreakGlass(1, "dba", time.Hour, "database down")
+	if err != nil {
+		t.Fatal("an error was not expected while breaking the glass", err)
+	}
+	if ok, _ := auth.CheckUserRole(1, "dba"); !ok {
+		t.Error("expected the user to hold the role")
+	}
+	if len(events) != 2 || events[1].Type != authority.EventBreakGlass || events[1].Role != "dba" {
+		t.Error("expected the break-glass event", events)
+	}
+	unreviewed, _ := auth.GetUnreviewedBreakGlass()
+	if len(unreviewed) != 1 || unreviewed[0].ID != glass.ID || unreviewed[0].Reason != "database down" {
+		t.Error("expected the break-glass elevation to wait for a review", unreviewed)
+	}
+	if err := auth.ReviewBreakGlass(1, glass.ID); !errors.Is(err, authority.ErrActorNotAllowed) {
+		t.Error("expected the user not to review its own elevation", err)
+	}
+	if err := auth.ReviewBreakGlass(9, elevation.ID); !errors.Is(err, authority.ErrElevationNotFound) {
+		t.Error("expected only break-glass elevations to be reviewed", err)
+	}
+	if err := auth.ReviewBreakGlass(9, glass.ID); err != nil {
+		t.Error("an error was not expected while reviewing", err)
+	}
+	if err := auth.ReviewBreakGlass(9, glass.ID); !errors.Is(err, authority.ErrElevationReviewed) {
+		t.Error("expected ErrElevationReviewed", err)
+	}
+	if unreviewed, _ := auth.GetUnreviewedBreakGlass(); len(unreviewed) != 0 {
+		t.Error("expected no elevation to review", unreviewed)
+	}
+
+	// the elevations expire
+	db.Model(authority.UserRole{}).Where("user_id = ?", "1").Where("expires_at IS NOT NULL").Update("expires_at", time.Now().Add(-time.Minute))
+	if ok, _ := auth.CheckUserRole(1, "prod-writer"); ok {
+		t.Error("expected the elevation to expire")
+	}
+	if revoked, _ := auth.RevokeExpiredRoles(); revoked != 2 {
+		t.Error("expected the expired elevations to be revoked", revoked)
+	}
+	if elevations, _ := auth.GetUserElevations(1); len(elevations) != 2 {
+		t.Error("expected the elevations to be kept", elevations)
+	}
+
+	t.Cleanup(func() {
+		db.Where("1 = 1").Delete(authority.Elevation{})
+		db.Where("user_id IN (?)", []string{"1", "2", "9"}).Delete(authority.UserRole{})
+		db.Where("1 = 1").Delete(authority.RolePermission{})
+		db.Where("slug IN (?)", []string{"prod-writer", "dba", "on-call", "auditor"}).Delete(authority.Role{})
+		db.Where("slug LIKE ? OR slug LIKE ?", "authority.%", "grant:%").Delete(authority.Permission{})
+	})
+}
+
+func TestCampaigns(t *testing.T) {
+	forEachDatabase(t, testCampaigns)
+}
+
+func testCampaigns(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           db,
+	})
+	auth.CreateRole(authority.Role{Name: "Admin", Slug: "admin"})
+	auth.CreateRole(authority.Role{Name: "Editor", Slug: "editor"})
+	auth.CreateRole(authority.Role{Name: "Viewer", Slug: "viewer"})
+	auth.AssignRoleToUser(1, "admin")
+	auth.AssignRoleToUser(2, "admin")
+	auth.AssignRoleToUser(3, "editor")
+	auth.AssignRoleToUser(4, "editor")
+	auth.AssignRoleToUser(5, "viewer")
+	auth.AssignRoleToUser(7, "admin")
+
+	if _, err := auth.StartCampaign("Q1", []string{"role-x"}, time.Now().Add(time.Hour)); !errors.Is(err, authority.ErrRoleNotFound) {
+		t.Error("expected ErrRoleNotFound", err)
+	}
+	campaign, err := auth.StartCampaign("Q1", []string{"admin", "editor"}, time.Now().Add(time.Hour))
+	if err != nil || campaign.Status != authority.CampaignOpen {
+		t.Fatal("an error was not expected while starting the campaign", campaign, err)
+	}
+	// the assignments after the snapshot are not reviewed, nor the grants again
+	auth.AssignRoleToUser(6, "editor")
+	auth.RevokeUserRole(7, "admin")
+	auth.AssignRoleToUser(7, "admin")
+	items, _ := auth.GetCampaignItems(campaign.ID)
+	if len(items) != 5 || items[0].RoleSlug != "admin" || items[0].UserID != "1" || items[4].UserID != "4" {
+		t.Fatal("unexpected campaign items", items)
+	}
+
+	if err := auth.AssignCampaignReviewer(campaign.ID, "viewer", 1); !errors.Is(err, authority.ErrCampaignItemNotFound) {
+		t.Error("expected ErrCampaignItemNotFound for a role not in the campaign", err)
+	}
+	auth.AssignCampaignReviewer(campaign.ID, "admin", 1)
+	auth.AssignCampaignReviewer(campaign.ID, "editor", 9)
+	items, _ = auth.GetCampaignItems(campaign.ID)
+	if items[0].ReviewerID != "" || items[1].ReviewerID != "1" {
+		t.Error("expected the own assignment of the reviewer to be skipped", items)
+	}
+	if mine, _ := auth.GetReviewerCampaignItems(9); len(mine) != 2 {
+		t.Error("expected the reviewer items", mine)
+	}
+	if err := auth.ReviewCampaignItem(1, items[0].ID, true, ""); !errors.Is(err, authority.ErrActorNotAllowed) {
+		t.Error("expected the reviewer not to review its own assignment", err)
+	}
+	if err := auth.ReviewCampaignItem(9, items[1].ID, true, ""); !errors.Is(err, authority.ErrActorNotAllowed) {
+		t.Error("expected only the assigned reviewer to review", err)
+	}
+	if err := auth.ReviewCampaignItem(1, items[1].ID, true, "still admin"); err != nil {
+		t.Error("an error was not expected while reviewing", err)
+	}
+	if err := auth.ReviewCampaignItem(9, items[3].ID, true, ""); err != nil {
+		t.Error("an error was not expected while reviewing", err)
+	}
+	if err := auth.ReviewCampaignItem(9, items[4].ID, false, "left the team"); err != nil {
+		t.Error("an error was not expected while reviewing", err)
+	}
+	if mine, _ := auth.GetReviewerCampaignItems(9); len(mine) != 0 {
+		t.Error("expected no item left to the reviewer", mine)
+	}
+
+	// nothing is revoked before the deadline
+	if revoked, _ := auth.FinalizeDueCampaigns(); revoked != 0 {
+		t.Error("expected the campaign not to be due", revoked)
+	}
+	if _, err := auth.FinalizeCampaign(campaign.ID); !errors.Is(err, authority.ErrCampaignNotDue) {
+		t.Error("expected ErrCampaignNotDue", err)
+	}
+	db.Model(authority.Campaign{}).Where("id = ?", campaign.ID).Update("deadline", time.Now().Add(-time.Minute))
+	if err := auth.ReviewCampaignItem(9, items[4].ID, true, ""); !errors.Is(err, authority.ErrCampaignClosed) {
+		t.Error("expected the decisions to close at the deadline", err)
+	}
+
+	// the rejected and the unreviewed assignments are revoked, not the grant after the snapshot
+	revoked, err := auth.FinalizeDueCampaigns()
+	if err != nil || revoked != 2 {
+		t.Error("expected two assignments to be revoked", revoked, err)
+	}
+	for userID, role := range map[int]string{1: "admin", 4: "editor"} {
+		if ok, _ := auth.CheckUserRole(userID, role); ok {
+			t.Error("expected the assignment to be revoked", userID, role)
+		}
+	}
+	for userID, role := range map[int]string{2: "admin", 3: "editor", 5: "viewer", 6: "editor", 7: "admin"} {
+		if ok, _ := auth.CheckUserRole(userID, role); !ok {
+			t.Error("expected the assignment to be kept", userID, role)
+		}
+	}
+	if _, err := auth.FinalizeCampaign(campaign.ID); !errors.Is(err, authority.ErrCampaignClosed) {
+		t.Error("expected ErrCampaignClosed", err)
+	}
+	items, _ = auth.GetCampaignItems(campaign.ID)
+	if !items[0].Revoked || items[1].Revoked || items[2].Revoked || items[4].Comment != "left the team" {
+		t.Error("expected the items to record the outcome", items)
+	}
+
+	t.Cleanup(func() {
+		db.Where("1 = 1").Delete(authority.CampaignItem{})
+		db.Where("1 = 1").Delete(authority.Campaign{})
+		db.Where("user_id IN (?)", []string{"1", "2", "3", "4", "5", "6", "7"}).Delete(authority.UserRole{})
+		db.Where("slug IN (?)", []string{"admin", "editor", "viewer"}).Delete(authority.Role{})
+	})
+}
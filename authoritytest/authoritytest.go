@@ -0,0 +1,652 @@
+// Package authoritytest provides an in-memory fake of authority for unit
+// testing code that depends on the authority interfaces, without a database
+//
+//	fake := authoritytest.New().
+//		Grant("editor", "edit-posts").
+//		Assign(42, "editor")
+//	handler := NewPostsHandler(fake)
+//	// ...
+//	fake.AssertCalled(t, "CheckUserPermission", 42, "edit-posts")
+package authoritytest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/harranali/authority"
+	"gopkg.in/yaml.v3"
+)
+
+// Call is a recorded method call
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// String returns the call in the "Method(arg1, arg2)" form
+func (c Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = fmt.Sprintf("%v", arg)
+	}
+	return c.Method + "(" + strings.Join(args, ", ") + ")"
+}
+
+// TB is the part of testing.TB used by the assertions
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Fake is an in-memory implementation of authority.Authorizer and authority.Manager
+// it returns the same errors as Authority for missing, duplicated, assigned and protected
+// roles and permissions, and records every call
+// separation of duties, cardinality limits and expiring assignments are not simulated,
+// use Authority with an sqlite database to test code relying on them
+type Fake struct {
+	mu     sync.Mutex
+	roles  map[string]authority.Role
+	perms  map[string]authority.Permission
+	grants map[string]map[string]bool // role slug -> permission slugs
+	users  map[string]map[string]bool // user id -> role slugs
+	errors map[string]error
+	calls  []Call
+	nextID uint
+}
+
+var (
+	_ authority.Authorizer = (*Fake)(nil)
+	_ authority.Manager    = (*Fake)(nil)
+)
+
+// New returns an empty fake
+func New() *Fake {
+	return &Fake{
+		roles:  map[string]authority.Role{},
+		perms:  map[string]authority.Permission{},
+		grants: map[string]map[string]bool{},
+		users:  map[string]map[string]bool{},
+		errors: map[string]error{},
+	}
+}
+
+// Grant grants the permissions to the role, creating both if missing
+// it is meant for setting up the fake and is not recorded as a call
+func (f *Fake) Grant(roleSlug string, permSlugs ...string) *Fake {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ensureRole(roleSlug)
+	for _, permSlug := range permSlugs {
+		f.ensurePermission(permSlug)
+		f.grants[roleSlug][permSlug] = true
+	}
+	return f
+}
+
+// Assign assigns the roles to the user, creating the roles if missing
+// it is meant for setting up the fake and is not recorded as a call
+func (f *Fake) Assign(userID interface{}, roleSlugs ...string) *Fake {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := fmt.Sprintf("%v", userID)
+	if f.users[id] == nil {
+		f.users[id] = map[string]bool{}
+	}
+	for _, roleSlug := range roleSlugs {
+		f.ensureRole(roleSlug)
+		f.users[id][roleSlug] = true
+	}
+	return f
+}
+
+// FailWith makes every call of the method return the error, a nil error clears it
+func (f *Fake) FailWith(method string, err error) *Fake {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errors, method)
+	} else {
+		f.errors[method] = err
+	}
+	return f
+}
+
+// Calls returns the recorded calls in order
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// Reset forgets the recorded calls
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+}
+
+// Called reports whether the method was called with the arguments
+// arguments are compared by their formatted value, so 42 matches "42"
+// passing no arguments matches any call of the method
+func (f *Fake) Called(method string, args ...interface{}) bool {
+	for _, call := range f.Calls() {
+		if call.Method == method && (len(args) == 0 || sameArgs(call.Args, args)) {
+			return true
+		}
+	}
+	return false
+}
+
+// AssertCalled fails the test if the method was not called with the arguments
+func (f *Fake) AssertCalled(t TB, method string, args ...interface{}) {
+	t.Helper()
+	if !f.Called(method, args...) {
+		t.Errorf("expected %v to be called, got calls %v", Call{Method: method, Args: args}, f.Calls())
+	}
+}
+
+// AssertNotCalled fails the test if the method was called with the arguments
+func (f *Fake) AssertNotCalled(t TB, method string, args ...interface{}) {
+	t.Helper()
+	if f.Called(method, args...) {
+		t.Errorf("expected %v not to be called", Call{Method: method, Args: args})
+	}
+}
+
+// AssertNumberOfCalls fails the test if the method was not called exactly n times
+func (f *Fake) AssertNumberOfCalls(t TB, method string, n int) {
+	t.Helper()
+	count := 0
+	for _, call := range f.Calls() {
+		if call.Method == method {
+			count++
+		}
+	}
+	if count != n {
+		t.Errorf("expected %v to be called %d times, got %d", method, n, count)
+	}
+}
+
+func sameArgs(a []interface{}, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if fmt.Sprintf("%v", a[i]) != fmt.Sprintf("%v", b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// record stores the call and returns the configured error of the method, the lock must be held
+func (f *Fake) record(method string, args ...interface{}) error {
+	f.calls = append(f.calls, Call{Method: method, Args: args})
+	return f.errors[method]
+}
+
+func (f *Fake) ensureRole(slug string) {
+	if _, ok := f.roles[slug]; !ok {
+		f.nextID++
+		f.roles[slug] = authority.Role{ID: f.nextID, Name: slug, Slug: slug}
+		f.grants[slug] = map[string]bool{}
+	}
+}
+
+func (f *Fake) ensurePermission(slug string) {
+	if _, ok := f.perms[slug]; !ok {
+		f.nextID++
+		f.perms[slug] = authority.Permission{ID: f.nextID, Name: slug, Slug: slug}
+	}
+}
+
+// CheckUserRole checks if a role is assigned to a user
+func (f *Fake) CheckUserRole(userID interface{}, roleSlug string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("CheckUserRole", userID, roleSlug); err != nil {
+		return false, err
+	}
+	if _, ok := f.roles[roleSlug]; !ok {
+		return false, authority.ErrRoleNotFound
+	}
+	return f.users[fmt.Sprintf("%v", userID)][roleSlug], nil
+}
+
+// CheckUserPermission checks if a permission is assigned to a user
+func (f *Fake) CheckUserPermission(userID interface{}, permSlug string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("CheckUserPermission", userID, permSlug); err != nil {
+		return false, err
+	}
+	if _, ok := f.perms[permSlug]; !ok {
+		return false, authority.ErrPermissionNotFound
+	}
+	for roleSlug := range f.users[fmt.Sprintf("%v", userID)] {
+		if f.grants[roleSlug][permSlug] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CheckRolePermission checks if a permission is assigned to a role
+func (f *Fake) CheckRolePermission(roleSlug string, permSlug string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("CheckRolePermission", roleSlug, permSlug); err != nil {
+		return false, err
+	}
+	if _, ok := f.roles[roleSlug]; !ok {
+		return false, authority.ErrRoleNotFound
+	}
+	if _, ok := f.perms[permSlug]; !ok {
+		return false, authority.ErrPermissionNotFound
+	}
+	return f.grants[roleSlug][permSlug], nil
+}
+
+// ExplainUserPermission returns the roles of a user granting a permission sorted by slug
+func (f *Fake) ExplainUserPermission(userID interface{}, permSlug string) (authority.Explanation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e := authority.Explanation{UserID: fmt.Sprintf("%v", userID), Permission: permSlug}
+	if err := f.record("ExplainUserPermission", userID, permSlug); err != nil {
+		return e, err
+	}
+	if _, ok := f.perms[permSlug]; !ok {
+		return e, authority.ErrPermissionNotFound
+	}
+	for roleSlug := range f.users[e.UserID] {
+		if f.grants[roleSlug][permSlug] {
+			e.Roles = append(e.Roles, f.roles[roleSlug])
+		}
+	}
+	sort.Slice(e.Roles, func(i, j int) bool { return e.Roles[i].Slug < e.Roles[j].Slug })
+	e.Allowed = len(e.Roles) > 0
+	return e, nil
+}
+
+// GetAllRoles returns all roles sorted by slug
+func (f *Fake) GetAllRoles() ([]authority.Role, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("GetAllRoles"); err != nil {
+		return nil, err
+	}
+	var roles []authority.Role
+	for _, role := range f.roles {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Slug < roles[j].Slug })
+	return roles, nil
+}
+
+// GetAllPermissions returns all permissions sorted by slug
+func (f *Fake) GetAllPermissions() ([]authority.Permission, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("GetAllPermissions"); err != nil {
+		return nil, err
+	}
+	var perms []authority.Permission
+	for _, perm := range f.perms {
+		perms = append(perms, perm)
+	}
+	sort.Slice(perms, func(i, j int) bool { return perms[i].Slug < perms[j].Slug })
+	return perms, nil
+}
+
+// GetUserRoles returns the roles of a user sorted by slug
+func (f *Fake) GetUserRoles(userID interface{}) ([]authority.Role, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("GetUserRoles", userID); err != nil {
+		return nil, err
+	}
+	var roles []authority.Role
+	for roleSlug := range f.users[fmt.Sprintf("%v", userID)] {
+		roles = append(roles, f.roles[roleSlug])
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Slug < roles[j].Slug })
+	return roles, nil
+}
+
+// GetRoleUsers returns the ids of the users holding a role sorted
+func (f *Fake) GetRoleUsers(roleSlug string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("GetRoleUsers", roleSlug); err != nil {
+		return nil, err
+	}
+	if _, ok := f.roles[roleSlug]; !ok {
+		return nil, authority.ErrRoleNotFound
+	}
+	var userIDs []string
+	for id, roles := range f.users {
+		if roles[roleSlug] {
+			userIDs = append(userIDs, id)
+		}
+	}
+	sort.Strings(userIDs)
+	return userIDs, nil
+}
+
+// GetRolePermissions returns the permissions of a role sorted by slug
+func (f *Fake) GetRolePermissions(roleSlug string) ([]authority.Permission, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("GetRolePermissions", roleSlug); err != nil {
+		return nil, err
+	}
+	if _, ok := f.roles[roleSlug]; !ok {
+		return nil, authority.ErrRoleNotFound
+	}
+	var perms []authority.Permission
+	for permSlug := range f.grants[roleSlug] {
+		perms = append(perms, f.perms[permSlug])
+	}
+	sort.Slice(perms, func(i, j int) bool { return perms[i].Slug < perms[j].Slug })
+	return perms, nil
+}
+
+// CreateRole adds a new role
+func (f *Fake) CreateRole(r authority.Role) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("CreateRole", r); err != nil {
+		return err
+	}
+	if _, ok := f.roles[r.Slug]; ok {
+		return fmt.Errorf("%w: '%v'", authority.ErrRoleExists, r.Slug)
+	}
+	f.nextID++
+	r.ID = f.nextID
+	f.roles[r.Slug] = r
+	f.grants[r.Slug] = map[string]bool{}
+	return nil
+}
+
+// CreatePermission adds a new permission
+func (f *Fake) CreatePermission(p authority.Permission) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("CreatePermission", p); err != nil {
+		return err
+	}
+	if _, ok := f.perms[p.Slug]; ok {
+		return fmt.Errorf("%w: '%v'", authority.ErrPermissionExists, p.Slug)
+	}
+	f.nextID++
+	p.ID = f.nextID
+	f.perms[p.Slug] = p
+	return nil
+}
+
+// AssignPermissionsToRole assigns the permissions to a role
+func (f *Fake) AssignPermissionsToRole(roleSlug string, permSlugs []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("AssignPermissionsToRole", roleSlug, permSlugs); err != nil {
+		return err
+	}
+	if _, ok := f.roles[roleSlug]; !ok {
+		return authority.ErrRoleNotFound
+	}
+	for _, permSlug := range permSlugs {
+		if _, ok := f.perms[permSlug]; !ok {
+			return authority.ErrPermissionNotFound
+		}
+		if f.grants[roleSlug][permSlug] {
+			return fmt.Errorf("%w: permission '%v', role '%v'", authority.ErrPermissionAssigned, permSlug, roleSlug)
+		}
+	}
+	for _, permSlug := range permSlugs {
+		f.grants[roleSlug][permSlug] = true
+	}
+	return nil
+}
+
+// AssignRoleToUser assigns a role to a user
+func (f *Fake) AssignRoleToUser(userID interface{}, roleSlug string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("AssignRoleToUser", userID, roleSlug); err != nil {
+		return err
+	}
+	if _, ok := f.roles[roleSlug]; !ok {
+		return authority.ErrRoleNotFound
+	}
+	id := fmt.Sprintf("%v", userID)
+	if f.users[id][roleSlug] {
+		return fmt.Errorf("%w: '%v'", authority.ErrRoleAssigned, roleSlug)
+	}
+	if f.users[id] == nil {
+		f.users[id] = map[string]bool{}
+	}
+	f.users[id][roleSlug] = true
+	return nil
+}
+
+// RevokeUserRole revokes a role of a user
+func (f *Fake) RevokeUserRole(userID interface{}, roleSlug string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("RevokeUserRole", userID, roleSlug); err != nil {
+		return err
+	}
+	if _, ok := f.roles[roleSlug]; !ok {
+		return authority.ErrRoleNotFound
+	}
+	delete(f.users[fmt.Sprintf("%v", userID)], roleSlug)
+	return nil
+}
+
+// RevokeRolePermission revokes a permission of a role
+func (f *Fake) RevokeRolePermission(roleSlug string, permSlug string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("RevokeRolePermission", roleSlug, permSlug); err != nil {
+		return err
+	}
+	role, ok := f.roles[roleSlug]
+	if !ok {
+		return authority.ErrRoleNotFound
+	}
+	if _, ok := f.perms[permSlug]; !ok {
+		return authority.ErrPermissionNotFound
+	}
+	if role.Protected {
+		return fmt.Errorf("%w: '%v'", authority.ErrRoleProtected, roleSlug)
+	}
+	delete(f.grants[roleSlug], permSlug)
+	return nil
+}
+
+// DeleteRole deletes a role that is not assigned to any user nor protected
+func (f *Fake) DeleteRole(roleSlug string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("DeleteRole", roleSlug); err != nil {
+		return err
+	}
+	role, ok := f.roles[roleSlug]
+	if !ok {
+		return authority.ErrRoleNotFound
+	}
+	if role.Protected {
+		return fmt.Errorf("%w: '%v'", authority.ErrRoleProtected, roleSlug)
+	}
+	for _, roles := range f.users {
+		if roles[roleSlug] {
+			return authority.ErrRoleInUse
+		}
+	}
+	delete(f.roles, roleSlug)
+	delete(f.grants, roleSlug)
+	return nil
+}
+
+// DeletePermission deletes a permission that is not assigned to any role nor protected
+func (f *Fake) DeletePermission(permSlug string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("DeletePermission", permSlug); err != nil {
+		return err
+	}
+	perm, ok := f.perms[permSlug]
+	if !ok {
+		return authority.ErrPermissionNotFound
+	}
+	if perm.Protected {
+		return fmt.Errorf("%w: '%v'", authority.ErrPermissionProtected, permSlug)
+	}
+	for _, perms := range f.grants {
+		if perms[permSlug] {
+			return authority.ErrPermissionInUse
+		}
+	}
+	delete(f.perms, permSlug)
+	return nil
+}
+
+// ImportPolicy applies a policy document and returns the applied changes
+// nothing is applied in case of an error
+func (f *Fake) ImportPolicy(r io.Reader) ([]authority.PolicyChange, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ImportPolicy"); err != nil {
+		return nil, err
+	}
+	changes, err := f.plan(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range changes {
+		switch c.Action {
+		case authority.PolicyRenameRole, authority.PolicyUnprotectRole, authority.PolicyRevokePermission:
+			if f.roles[c.Role].Protected {
+				return nil, fmt.Errorf("%w: '%v'", authority.ErrRoleProtected, c.Role)
+			}
+		case authority.PolicyRenamePermission, authority.PolicyUnprotectPermission:
+			if f.perms[c.Permission].Protected {
+				return nil, fmt.Errorf("%w: '%v'", authority.ErrPermissionProtected, c.Permission)
+			}
+		}
+	}
+
+	for _, c := range changes {
+		switch c.Action {
+		case authority.PolicyCreatePermission:
+			f.nextID++
+			f.perms[c.Permission] = authority.Permission{ID: f.nextID, Name: c.Name, Slug: c.Permission}
+		case authority.PolicyRenamePermission:
+			perm := f.perms[c.Permission]
+			perm.Name = c.Name
+			f.perms[c.Permission] = perm
+		case authority.PolicyProtectPermission, authority.PolicyUnprotectPermission:
+			perm := f.perms[c.Permission]
+			perm.Protected = c.Action == authority.PolicyProtectPermission
+			f.perms[c.Permission] = perm
+		case authority.PolicyCreateRole:
+			f.nextID++
+			f.roles[c.Role] = authority.Role{ID: f.nextID, Name: c.Name, Slug: c.Role}
+			f.grants[c.Role] = map[string]bool{}
+		case authority.PolicyRenameRole:
+			role := f.roles[c.Role]
+			role.Name = c.Name
+			f.roles[c.Role] = role
+		case authority.PolicyProtectRole, authority.PolicyUnprotectRole:
+			role := f.roles[c.Role]
+			role.Protected = c.Action == authority.PolicyProtectRole
+			f.roles[c.Role] = role
+		case authority.PolicyGrantPermission:
+			f.grants[c.Role][c.Permission] = true
+		case authority.PolicyRevokePermission:
+			delete(f.grants[c.Role], c.Permission)
+		case authority.PolicyAssignRole:
+			if f.users[c.UserID] == nil {
+				f.users[c.UserID] = map[string]bool{}
+			}
+			f.users[c.UserID][c.Role] = true
+		case authority.PolicyRevokeRole:
+			delete(f.users[c.UserID], c.Role)
+		}
+	}
+	return changes, nil
+}
+
+// DiffPolicy returns the changes ImportPolicy would apply
+func (f *Fake) DiffPolicy(r io.Reader) ([]authority.PolicyChange, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("DiffPolicy"); err != nil {
+		return nil, err
+	}
+	return f.plan(r)
+}
+
+// ExportPolicy writes the roles, permissions and user assignments as a yaml policy document
+func (f *Fake) ExportPolicy(w io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.record("ExportPolicy"); err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(f.document()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// plan returns the changes bringing the fake in line with the document, the lock must be held
+func (f *Fake) plan(r io.Reader) ([]authority.PolicyChange, error) {
+	doc, err := authority.DecodePolicy(r)
+	if err != nil {
+		return nil, err
+	}
+	return authority.PlanPolicy(f.document(), doc)
+}
+
+// document returns the fake as a policy document, the lock must be held
+func (f *Fake) document() authority.PolicyDocument {
+	var doc authority.PolicyDocument
+	for _, slug := range sortedKeys(f.perms) {
+		perm := f.perms[slug]
+		pp := authority.PolicyPermission{Name: perm.Name, Slug: perm.Slug}
+		if perm.Protected {
+			pp.Protected = &perm.Protected
+		}
+		doc.Permissions = append(doc.Permissions, pp)
+	}
+	for _, slug := range sortedKeys(f.roles) {
+		role := f.roles[slug]
+		pr := authority.PolicyRole{Name: role.Name, Slug: role.Slug, Permissions: sortedKeys(f.grants[slug])}
+		if role.Protected {
+			pr.Protected = &role.Protected
+		}
+		doc.Roles = append(doc.Roles, pr)
+	}
+	for _, id := range sortedKeys(f.users) {
+		if len(f.users[id]) > 0 {
+			doc.Users = append(doc.Users, authority.PolicyUser{ID: id, Roles: sortedKeys(f.users[id])})
+		}
+	}
+	return doc
+}
+
+func sortedKeys[V interface{}](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
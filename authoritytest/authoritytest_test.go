@@ -0,0 +1,231 @@
+package authoritytest_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/harranali/authority"
+	"github.com/harranali/authority/authoritytest"
+	"github.com/harranali/authority/httpauthz"
+	"github.com/harranali/authority/internal/testdb"
+)
+
+type recorder struct {
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestFake(t *testing.T) {
+	fake := authoritytest.New().
+		Grant("editor", "edit-posts").
+		Grant("viewer", "view-posts").
+		Assign(42, "editor")
+
+	if ok, err := fake.CheckUserPermission(42, "edit-posts"); !ok || err != nil {
+		t.Error("expected the user to have the permission", err)
+	}
+	if ok, _ := fake.CheckUserPermission("42", "view-posts"); ok {
+		t.Error("expected the user not to have the permission")
+	}
+	if _, err := fake.CheckUserRole(42, "owner"); !errors.Is(err, authority.ErrRoleNotFound) {
+		t.Error("expected ErrRoleNotFound", err)
+	}
+	if err := fake.AssignRoleToUser(42, "editor"); !errors.Is(err, authority.ErrRoleAssigned) {
+		t.Error("expected ErrRoleAssigned", err)
+	}
+	if err := fake.DeleteRole("editor"); !errors.Is(err, authority.ErrRoleInUse) {
+		t.Error("expected ErrRoleInUse", err)
+	}
+	if err := fake.DeletePermission("view-posts"); !errors.Is(err, authority.ErrPermissionInUse) {
+		t.Error("expected ErrPermissionInUse", err)
+	}
+
+	fake.CreateRole(authority.Role{Name: "Owner", Slug: "owner"})
+	fake.AssignRoleToUser(7, "owner")
+	roles, _ := fake.GetUserRoles(7)
+	if len(roles) != 1 || roles[0].Name != "Owner" {
+		t.Error("unexpected user roles", roles)
+	}
+	fake.CreateRole(authority.Role{Name: "Admin", Slug: "admin", Protected: true})
+	if err := fake.DeleteRole("admin"); !errors.Is(err, authority.ErrRoleProtected) {
+		t.Error("expected ErrRoleProtected", err)
+	}
+
+	boom := errors.New("boom")
+	fake.FailWith("CheckUserPermission", boom)
+	if _, err := fake.CheckUserPermission(42, "edit-posts"); err != boom {
+		t.Error("expected the configured error", err)
+	}
+	fake.FailWith("CheckUserPermission", nil)
+	if _, err := fake.CheckUserPermission(42, "edit-posts"); err != nil {
+		t.Error("expected the configured error to be cleared", err)
+	}
+}
+
+func TestExplainAndRoleUsers(t *testing.T) {
+	fake := authoritytest.New().
+		Grant("editor", "edit-posts").
+		Grant("owner", "edit-posts").
+		Assign(42, "owner", "editor").
+		Assign(7, "editor")
+
+	e, err := fake.ExplainUserPermission(42, "edit-posts")
+	if err != nil || !e.Allowed || len(e.Roles) != 2 || e.Roles[0].Slug != "editor" {
+		t.Error("unexpected explanation", e, err)
+	}
+	if _, err := fake.ExplainUserPermission(42, "view-posts"); !errors.Is(err, authority.ErrPermissionNotFound) {
+		t.Error("expected ErrPermissionNotFound", err)
+	}
+	users, err := fake.GetRoleUsers("editor")
+	if err != nil || !reflect.DeepEqual(users, []string{"42", "7"}) {
+		t.Error("unexpected role users", users, err)
+	}
+	if _, err := fake.GetRoleUsers("viewer"); !errors.Is(err, authority.ErrRoleNotFound) {
+		t.Error("expected ErrRoleNotFound", err)
+	}
+}
+
+// TestMissingRole calls the same methods on a missing role in the fake and in Authority
+func TestMissingRole(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           testdb.SQLite(t),
+	})
+	impls := map[string]interface {
+		authority.Authorizer
+		authority.Manager
+	}{"authority": auth, "fake": authoritytest.New()}
+	for name, m := range impls {
+		if _, err := m.GetRolePermissions("missing"); !errors.Is(err, authority.ErrRoleNotFound) {
+			t.Error("expected ErrRoleNotFound from GetRolePermissions", name, err)
+		}
+		if _, err := m.GetRoleUsers("missing"); !errors.Is(err, authority.ErrRoleNotFound) {
+			t.Error("expected ErrRoleNotFound from GetRoleUsers", name, err)
+		}
+		if _, err := m.CheckUserRole(1, "missing"); !errors.Is(err, authority.ErrRoleNotFound) {
+			t.Error("expected ErrRoleNotFound from CheckUserRole", name, err)
+		}
+		if err := m.AssignRoleToUser(1, "missing"); !errors.Is(err, authority.ErrRoleNotFound) {
+			t.Error("expected ErrRoleNotFound from AssignRoleToUser", name, err)
+		}
+		if err := m.DeleteRole("missing"); !errors.Is(err, authority.ErrRoleNotFound) {
+			t.Error("expected ErrRoleNotFound from DeleteRole", name, err)
+		}
+	}
+}
+
+// TestPolicy imports the same documents in the fake and in Authority
+func TestPolicy(t *testing.T) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           testdb.SQLite(t),
+	})
+	fake := authoritytest.New()
+	managers := map[string]authority.Manager{"authority": auth, "fake": fake}
+
+	docs := []string{`
+permissions:
+  - {name: Edit Posts, slug: edit-posts}
+  - {name: View Posts, slug: view-posts}
+roles:
+  - {name: Editor, slug: editor, permissions: [edit-posts, view-posts]}
+  - {name: Viewer, slug: viewer, permissions: [view-posts], protected: true}
+users:
+  - {id: "42", roles: [editor]}
+`, `
+permissions:
+  - {name: Edit Posts, slug: edit-posts}
+roles:
+  - {name: Editors, slug: editor, permissions: [edit-posts]}
+users:
+  - {id: "42", roles: [viewer]}
+`}
+	for i, doc := range docs {
+		var changes [][]authority.PolicyChange
+		for _, name := range []string{"authority", "fake"} {
+			diff, err := managers[name].DiffPolicy(strings.NewReader(doc))
+			if err != nil {
+				t.Fatal("an error was not expected while diffing", name, err)
+			}
+			applied, err := managers[name].ImportPolicy(strings.NewReader(doc))
+			if err != nil {
+				t.Fatal("an error was not expected while importing", name, err)
+			}
+			if !reflect.DeepEqual(diff, applied) {
+				t.Error("expected the diff to match the applied changes", name, diff, applied)
+			}
+			changes = append(changes, applied)
+		}
+		if !reflect.DeepEqual(changes[0], changes[1]) {
+			t.Errorf("document %d: expected the same changes, got %v and %v", i, changes[0], changes[1])
+		}
+	}
+
+	var exported []string
+	for _, name := range []string{"authority", "fake"} {
+		var buf bytes.Buffer
+		if err := managers[name].ExportPolicy(&buf); err != nil {
+			t.Fatal("an error was not expected while exporting", name, err)
+		}
+		exported = append(exported, buf.String())
+	}
+	if exported[0] != exported[1] {
+		t.Errorf("expected the same export, got\n%v\nand\n%v", exported[0], exported[1])
+	}
+
+	unprotect := `
+roles:
+  - {name: Viewers, slug: viewer}
+`
+	if _, err := fake.ImportPolicy(strings.NewReader(unprotect)); !errors.Is(err, authority.ErrRoleProtected) {
+		t.Error("expected ErrRoleProtected", err)
+	}
+}
+
+func TestAssertions(t *testing.T) {
+	fake := authoritytest.New().Grant("editor", "edit-posts").Assign(42, "editor")
+
+	// the fake is used through the middleware, like a handler under test would
+	e := httpauthz.New(httpauthz.Options{
+		Authority: fake,
+		UserID: func(r *http.Request) (interface{}, bool) {
+			return 42, true
+		},
+	})
+	h := e.RequirePermission("edit-posts")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Error("expected the request to pass, got", w.Code)
+	}
+
+	fake.AssertCalled(t, "CheckUserPermission", 42, "edit-posts")
+	fake.AssertCalled(t, "CheckUserPermission", "42", "edit-posts")
+	fake.AssertCalled(t, "CheckUserPermission")
+	fake.AssertNotCalled(t, "CheckUserRole")
+	fake.AssertNumberOfCalls(t, "CheckUserPermission", 1)
+
+	r := &recorder{}
+	fake.AssertCalled(r, "CheckUserPermission", 43, "edit-posts")
+	fake.AssertNotCalled(r, "CheckUserPermission", 42, "edit-posts")
+	fake.AssertNumberOfCalls(r, "CheckUserPermission", 2)
+	if len(r.failures) != 3 {
+		t.Error("expected the assertions to fail", r.failures)
+	}
+
+	fake.Reset()
+	if len(fake.Calls()) != 0 {
+		t.Error("expected the calls to be forgotten")
+	}
+}
@@ -0,0 +1,43 @@
+package authority
+
+import "io"
+
+// Checker answers whether users and roles have roles and permissions
+type Checker interface {
+	CheckUserRole(userID interface{}, roleSlug string) (bool, error)
+	CheckUserPermission(userID interface{}, permSlug string) (bool, error)
+	CheckRolePermission(roleSlug string, permSlug string) (bool, error)
+}
+
+// Authorizer answers the checks and queries of authority
+// it is implemented by Authority and by the remote client of the client package,
+// so code depending on it works both with direct database access and with an authority server
+type Authorizer interface {
+	Checker
+	ExplainUserPermission(userID interface{}, permSlug string) (Explanation, error)
+	GetAllRoles() ([]Role, error)
+	GetAllPermissions() ([]Permission, error)
+	GetUserRoles(userID interface{}) ([]Role, error)
+	GetRoleUsers(roleSlug string) ([]string, error)
+	GetRolePermissions(roleSlug string) ([]Permission, error)
+}
+
+// Manager changes the stored roles, permissions and their assignments
+type Manager interface {
+	CreateRole(r Role) error
+	CreatePermission(p Permission) error
+	AssignPermissionsToRole(roleSlug string, permSlugs []string) error
+	AssignRoleToUser(userID interface{}, roleSlug string) error
+	RevokeUserRole(userID interface{}, roleSlug string) error
+	RevokeRolePermission(roleSlug string, permSlug string) error
+	DeleteRole(roleSlug string) error
+	DeletePermission(permSlug string) error
+	ImportPolicy(r io.Reader) ([]PolicyChange, error)
+	DiffPolicy(r io.Reader) ([]PolicyChange, error)
+	ExportPolicy(w io.Writer) error
+}
+
+var (
+	_ Authorizer = (*Authority)(nil)
+	_ Manager    = (*Authority)(nil)
+)
@@ -0,0 +1,332 @@
+package authority
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCampaignNotFound     = errors.New("campaign not found")
+	ErrCampaignItemNotFound = errors.New("campaign item not found")
+	ErrCampaignClosed       = errors.New("campaign is closed")
+	ErrCampaignNotDue       = errors.New("campaign deadline not reached")
+)
+
+// The statuses of a recertification campaign
+const (
+	CampaignOpen      = "open"
+	CampaignFinalized = "finalized"
+)
+
+// The decisions of a campaign reviewer
+const (
+	DecisionKeep   = "keep"
+	DecisionRevoke = "revoke"
+)
+
+// The database model of an access recertification campaign
+type Campaign struct {
+	ID          uint       // Unique id (it gets set automatically by the database)
+	Name        string     // The name of the campaign
+	Deadline    time.Time  // The decisions are accepted until this time
+	Status      string     `gorm:"index"` // One of the Campaign* statuses
+	CreatedAt   time.Time  // The time of the snapshot
+	FinalizedAt *time.Time // The time of the finalization, nil while open
+}
+
+// TableName sets the table name
+func (c Campaign) TableName() string {
+	return auth.TablesPrefix + "campaigns"
+}
+
+// The database model of an assignment under review in a campaign
+type CampaignItem struct {
+	ID         uint       // Unique id (it gets set automatically by the database)
+	CampaignID uint       `gorm:"index"` // The campaign id
+	UserID     string     // The user holding the role when the campaign started
+	RoleSlug   string     // The slug of the role
+	UserRoleID uint       // The id of the reviewed assignment, a later grant of the role has another id
+	ReviewerID string     `gorm:"index"` // The id of the user reviewing the assignment, empty while unassigned
+	Decision   string     // One of the Decision* decisions, empty while unreviewed
+	Comment    string     // The comment of the reviewer
+	DecidedAt  *time.Time // The time of the decision, nil while unreviewed
+	Revoked    bool       // Whether the finalization revoked the assignment
+}
+
+// TableName sets the table name
+func (i CampaignItem) TableName() string {
+	return auth.TablesPrefix + "campaign_items"
+}
+
+// Starts a campaign reviewing every current assignment of the given roles
+// the assignments are snapshot when the campaign starts, later ones are not reviewed
+// it returns the campaign
+// it returns an error in case of any
+// in case any of the roles does not exists, an error is returned
+func (a *Authority) StartCampaign(name string, roleSlugs []string, deadline time.Time) (campaign Campaign, err error) {
+	a, op := a.begin("StartCampaign")
+	defer func() { op.mutated(err) }()
+
+	var roles []Role
+	for _, slug := range roleSlugs {
+		var role Role
+		res := a.DB.Where("slug = ?", slug).First(&role)
+		if res.Error != nil {
+			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+				return campaign, fmt.Errorf("%w: '%v'", ErrRoleNotFound, slug)
+			}
+			return campaign, res.Error
+		}
+		roles = append(roles, role)
+	}
+
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		campaign = Campaign{Name: name, Deadline: deadline, Status: CampaignOpen}
+		if err := tx.Create(&campaign).Error; err != nil {
+			return err
+		}
+		var items []CampaignItem
+		for _, role := range roles {
+			var userRoles []UserRole
+			if res := tx.Scopes(active).Where("role_id = ?", role.ID).Order("user_id").Find(&userRoles); res.Error != nil {
+				return res.Error
+			}
+			for _, ur := range userRoles {
+				items = append(items, CampaignItem{CampaignID: campaign.ID, UserID: ur.UserID, RoleSlug: role.Slug, UserRoleID: ur.ID})
+			}
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		return tx.Create(&items).Error
+	})
+	if err != nil {
+		return Campaign{}, err
+	}
+
+	return campaign, nil
+}
+
+// Assigns the reviewer of the items of a role in an open campaign
+// the own assignments of the reviewer are skipped, they keep their reviewer so another one can be assigned first
+// it returns an error in case of any
+// in case the campaign does not exists or is closed, an error is returned
+// in case the role is not part of the campaign, an error is returned
+func (a *Authority) AssignCampaignReviewer(campaignID uint, roleSlug string, reviewerID interface{}) (err error) {
+	a, op := a.begin("AssignCampaignReviewer", AttrRole.String(roleSlug), userAttr(reviewerID))
+	defer func() { op.mutated(err) }()
+
+	if _, err := a.openCampaign(campaignID); err != nil {
+		return err
+	}
+
+	var count int64
+	res := a.DB.Model(CampaignItem{}).Where("campaign_id = ?", campaignID).Where("role_slug = ?", roleSlug).Count(&count)
+	if res.Error != nil {
+		return res.Error
+	}
+	if count == 0 {
+		return fmt.Errorf("%w: role '%v' is not part of campaign %v", ErrCampaignItemNotFound, roleSlug, campaignID)
+	}
+
+	reviewerIDStr := fmt.Sprintf("%v", reviewerID)
+	res = a.DB.Model(CampaignItem{}).Where("campaign_id = ?", campaignID).Where("role_slug = ?", roleSlug).
+		Where("user_id <> ?", reviewerIDStr).Update("reviewer_id", reviewerIDStr)
+	return res.Error
+}
+
+// Records the decision of a reviewer on a campaign item, keep or revoke
+// the decision can be changed until the deadline
+// it returns an error wrapping ErrActorNotAllowed in case the reviewer is not the one of the item, or reviews its own assignment
+// in case the item does not exists, or its campaign is closed or past its deadline, an error is returned
+func (a *Authority) ReviewCampaignItem(reviewerID interface{}, itemID uint, keep bool, comment string) (err error) {
+	a, op := a.begin("ReviewCampaignItem", userAttr(reviewerID))
+	defer func() { op.mutated(err) }()
+
+	var item CampaignItem
+	res := a.DB.Where("id = ?", itemID).First(&item)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %v", ErrCampaignItemNotFound, itemID)
+		}
+		return res.Error
+	}
+	campaign, err := a.openCampaign(item.CampaignID)
+	if err != nil {
+		return err
+	}
+	if !time.Now().Before(campaign.Deadline) {
+		return fmt.Errorf("%w: campaign %v is past its deadline", ErrCampaignClosed, campaign.ID)
+	}
+	reviewerIDStr := fmt.Sprintf("%v", reviewerID)
+	if item.ReviewerID == "" || reviewerIDStr != item.ReviewerID {
+		return fmt.Errorf("%w: actor '%v' is not the reviewer of item %v", ErrActorNotAllowed, reviewerID, itemID)
+	}
+	if reviewerIDStr == item.UserID {
+		return fmt.Errorf("%w: actor '%v' cannot review its own assignment", ErrActorNotAllowed, reviewerID)
+	}
+
+	decision := DecisionRevoke
+	if keep {
+		decision = DecisionKeep
+	}
+
+	return a.DB.Model(CampaignItem{}).Where("id = ?", itemID).Updates(map[string]interface{}{
+		"decision":   decision,
+		"comment":    comment,
+		"decided_at": time.Now(),
+	}).Error
+}
+
+// Returns the items of a campaign, ordered by role and user
+// it returns an error in case of any
+// in case the campaign does not exists, an error is returned
+func (a *Authority) GetCampaignItems(campaignID uint) (items []CampaignItem, err error) {
+	a, op := a.begin("GetCampaignItems")
+	defer func() { op.end(err) }()
+
+	if _, err := a.campaign(campaignID); err != nil {
+		return nil, err
+	}
+	res := a.DB.Where("campaign_id = ?", campaignID).Order("role_slug").Order("user_id").Find(&items)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return items, nil
+}
+
+// Returns the unreviewed items of the open campaigns assigned to a reviewer
+// it returns an error in case of any
+func (a *Authority) GetReviewerCampaignItems(reviewerID interface{}) (items []CampaignItem, err error) {
+	a, op := a.begin("GetReviewerCampaignItems", userAttr(reviewerID))
+	defer func() { op.end(err) }()
+
+	var open []Campaign
+	if res := a.DB.Where("status = ?", CampaignOpen).Find(&open); res.Error != nil {
+		return nil, res.Error
+	}
+	if len(open) == 0 {
+		return nil, nil
+	}
+	var campaignIDs []uint
+	for _, c := range open {
+		campaignIDs = append(campaignIDs, c.ID)
+	}
+
+	res := a.DB.Where("campaign_id IN (?)", campaignIDs).Where("reviewer_id = ?", fmt.Sprintf("%v", reviewerID)).
+		Where("decision = ?", "").Order("id").Find(&items)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return items, nil
+}
+
+// Closes a campaign past its deadline, revoking every assignment not kept by its reviewer
+// an assignment revoked or granted again since the snapshot is not revoked
+// it returns the number of revoked assignments
+// it returns an error in case of any, the finalization can then be retried
+// in case the campaign does not exists or is already finalized, an error is returned
+// in case the deadline is not reached, an error is returned
+func (a *Authority) FinalizeCampaign(campaignID uint) (revoked int, err error) {
+	a, op := a.begin("FinalizeCampaign")
+	defer func() { op.mutated(err) }()
+
+	campaign, err := a.openCampaign(campaignID)
+	if err != nil {
+		return 0, err
+	}
+	if time.Now().Before(campaign.Deadline) {
+		return 0, fmt.Errorf("%w: campaign %v", ErrCampaignNotDue, campaignID)
+	}
+
+	var items []CampaignItem
+	res := a.DB.Where("campaign_id = ?", campaignID).Where("decision <> ?", DecisionKeep).Where("revoked = ?", false).Order("id").Find(&items)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	for _, item := range items {
+		// only the reviewed assignment is revoked, not a later grant of the role
+		deleted := false
+		err := a.write(func(db *gorm.DB) ([]Event, error) {
+			dRes := db.Where("id = ?", item.UserRoleID).Where("user_id = ?", item.UserID).Delete(UserRole{})
+			if dRes.Error != nil || dRes.RowsAffected == 0 {
+				return nil, dRes.Error
+			}
+			deleted = true
+			return []Event{{Type: EventRoleRevoked, UserID: item.UserID, Role: item.RoleSlug}}, nil
+		})
+		if err != nil {
+			return revoked, err
+		}
+		if !deleted {
+			continue
+		}
+		if res := a.DB.Model(CampaignItem{}).Where("id = ?", item.ID).Update("revoked", true); res.Error != nil {
+			return revoked, res.Error
+		}
+		revoked++
+	}
+
+	res = a.DB.Model(Campaign{}).Where("id = ?", campaignID).Where("status = ?", CampaignOpen).
+		Updates(map[string]interface{}{"status": CampaignFinalized, "finalized_at": time.Now()})
+	if res.Error != nil {
+		return revoked, res.Error
+	}
+
+	return revoked, nil
+}
+
+// Finalizes the open campaigns past their deadline, call it periodically
+// it returns the number of revoked assignments
+// it returns an error in case of any
+func (a *Authority) FinalizeDueCampaigns() (revoked int, err error) {
+	a, op := a.begin("FinalizeDueCampaigns")
+	defer func() { op.mutated(err) }()
+
+	var due []Campaign
+	res := a.DB.Where("status = ?", CampaignOpen).Where("deadline <= ?", time.Now()).Order("id").Find(&due)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	for _, c := range due {
+		n, err := a.FinalizeCampaign(c.ID)
+		revoked += n
+		if err != nil {
+			return revoked, err
+		}
+	}
+
+	return revoked, nil
+}
+
+// campaign returns the campaign with the given id
+func (a *Authority) campaign(campaignID uint) (Campaign, error) {
+	var campaign Campaign
+	res := a.DB.Where("id = ?", campaignID).First(&campaign)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return campaign, fmt.Errorf("%w: %v", ErrCampaignNotFound, campaignID)
+		}
+		return campaign, res.Error
+	}
+
+	return campaign, nil
+}
+
+// openCampaign returns the campaign if it is not finalized
+func (a *Authority) openCampaign(campaignID uint) (Campaign, error) {
+	campaign, err := a.campaign(campaignID)
+	if err != nil {
+		return campaign, err
+	}
+	if campaign.Status != CampaignOpen {
+		return campaign, fmt.Errorf("%w: campaign %v is %v", ErrCampaignClosed, campaignID, campaign.Status)
+	}
+
+	return campaign, nil
+}
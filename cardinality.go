@@ -0,0 +1,139 @@
+package authority
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrRoleHoldersExceeded = errors.New("role holders limit exceeded")
+	ErrUserRolesExceeded   = errors.New("user roles limit exceeded")
+)
+
+// CardinalityError is returned when assigning a role would exceed a limit
+// it wraps ErrRoleHoldersExceeded or ErrUserRolesExceeded
+type CardinalityError struct {
+	UserID string // The user id
+	Role   string // The role slug
+	Limit  int    // The exceeded limit
+	err    error
+}
+
+func (e *CardinalityError) Error() string {
+	if errors.Is(e.err, ErrRoleHoldersExceeded) {
+		return fmt.Sprintf("%v: role '%v' allows %v holders", e.err, e.Role, e.Limit)
+	}
+	return fmt.Sprintf("%v: user '%v' may hold %v roles", e.err, e.UserID, e.Limit)
+}
+
+func (e *CardinalityError) Unwrap() error {
+	return e.err
+}
+
+// Sets the maximum number of users holding a role, zero removes the limit
+// the existing assignments are kept even if they exceed the new limit
+// it returns an error in case of any
+// in case the role does not exists, an error is returned
+func (a *Authority) SetRoleMaxHolders(roleSlug string, maxHolders int) (err error) {
+	a, op := a.begin("SetRoleMaxHolders", AttrRole.String(roleSlug))
+	defer func() { op.mutated(err) }()
+
+	if maxHolders < 0 {
+		return fmt.Errorf("invalid max holders %v", maxHolders)
+	}
+	var role Role
+	res := a.DB.Where("slug = ?", roleSlug).First(&role)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: '%v'", ErrRoleNotFound, roleSlug)
+		}
+		return res.Error
+	}
+
+	return a.write(func(db *gorm.DB) ([]Event, error) {
+		if err := db.Model(Role{}).Where("id = ?", role.ID).Update("max_holders", maxHolders).Error; err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventRoleMaxHoldersSet, Role: roleSlug, MaxHolders: maxHolders}}, nil
+	})
+}
+
+// checkCardinality returns a CardinalityError if assigning the role to the user exceeds a limit
+// it locks the role and the user so concurrent assignments of the role or to the user wait for the transaction
+func checkCardinality(db *gorm.DB, userID string, role Role, maxRolesPerUser int) error {
+	if role.MaxHolders == 0 && maxRolesPerUser == 0 {
+		return nil
+	}
+
+	var locked Role
+	res := db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", role.ID).First(&locked)
+	if res.Error != nil {
+		return res.Error
+	}
+	if locked.MaxHolders > 0 {
+		var holders int64
+		if res := db.Model(UserRole{}).Scopes(active).Where("role_id = ?", role.ID).Count(&holders); res.Error != nil {
+			return res.Error
+		}
+		if holders >= int64(locked.MaxHolders) {
+			return &CardinalityError{UserID: userID, Role: role.Slug, Limit: locked.MaxHolders, err: ErrRoleHoldersExceeded}
+		}
+	}
+
+	if maxRolesPerUser > 0 {
+		if err := lockUser(db, userID); err != nil {
+			return err
+		}
+		var held int64
+		if res := db.Model(UserRole{}).Scopes(active).Where("user_id = ?", userID).Count(&held); res.Error != nil {
+			return res.Error
+		}
+		if held >= int64(maxRolesPerUser) {
+			return &CardinalityError{UserID: userID, Role: role.Slug, Limit: maxRolesPerUser, err: ErrUserRolesExceeded}
+		}
+	}
+
+	return nil
+}
+
+// checkPolicyCardinality returns a CardinalityError if the applied changes exceed a limit
+func checkPolicyCardinality(tx *gorm.DB, changes []PolicyChange, maxRolesPerUser int) error {
+	checkedRoles := map[string]bool{}
+	checkedUsers := map[string]bool{}
+	for _, c := range changes {
+		if c.Action != PolicyAssignRole {
+			continue
+		}
+		if !checkedRoles[c.Role] {
+			checkedRoles[c.Role] = true
+			var role Role
+			if res := tx.Where("slug = ?", c.Role).First(&role); res.Error != nil {
+				return res.Error
+			}
+			if role.MaxHolders > 0 {
+				var holders int64
+				if res := tx.Model(UserRole{}).Scopes(active).Where("role_id = ?", role.ID).Count(&holders); res.Error != nil {
+					return res.Error
+				}
+				if holders > int64(role.MaxHolders) {
+					return &CardinalityError{UserID: c.UserID, Role: role.Slug, Limit: role.MaxHolders, err: ErrRoleHoldersExceeded}
+				}
+			}
+		}
+		if maxRolesPerUser > 0 && !checkedUsers[c.UserID] {
+			checkedUsers[c.UserID] = true
+			var held int64
+			if res := tx.Model(UserRole{}).Scopes(active).Where("user_id = ?", c.UserID).Count(&held); res.Error != nil {
+				return res.Error
+			}
+			if held > int64(maxRolesPerUser) {
+				return &CardinalityError{UserID: c.UserID, Role: c.Role, Limit: maxRolesPerUser, err: ErrUserRolesExceeded}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+// Package client implements authority.Authorizer on top of a remote
+// authority server, see the server package and the authority-server command
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/harranali/authority"
+	"github.com/harranali/authority/api"
+	"github.com/harranali/authority/server"
+)
+
+// Options has the options for initiating the client
+type Options struct {
+	URL        string       // The base url of the authority server, for example "http://authority:8080"
+	HTTPClient *http.Client // The http client sending the requests, defaults to a client with a 10 seconds timeout
+}
+
+// Client talks to a remote authority server
+// the roles and permissions it returns only have their name and slug set
+type Client struct {
+	url  string
+	http *http.Client
+}
+
+var _ authority.Authorizer = (*Client)(nil)
+
+// New initiates a client
+func New(opts Options) *Client {
+	c := &Client{
+		url:  strings.TrimSuffix(opts.URL, "/"),
+		http: opts.HTTPClient,
+	}
+	if c.http == nil {
+		c.http = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return c
+}
+
+// Checks if a role is assigned to a user
+func (c *Client) CheckUserRole(userID interface{}, roleSlug string) (bool, error) {
+	return c.checkOne(server.Check{UserID: fmt.Sprintf("%v", userID), Role: roleSlug})
+}
+
+// Checks if a permission is assigned to a user
+func (c *Client) CheckUserPermission(userID interface{}, permSlug string) (bool, error) {
+	return c.checkOne(server.Check{UserID: fmt.Sprintf("%v", userID), Permission: permSlug})
+}
+
+// Checks if a permission is assigned to a role
+func (c *Client) CheckRolePermission(roleSlug string, permSlug string) (bool, error) {
+	return c.checkOne(server.Check{Role: roleSlug, Permission: permSlug})
+}
+
+// Check sends a batch of checks in a single request
+// it returns the results in the order of the checks
+// the errors of single checks are reported in their results
+func (c *Client) Check(checks []server.Check) ([]server.Result, error) {
+	body, err := json.Marshal(server.CheckRequest{Checks: checks})
+	if err != nil {
+		return nil, err
+	}
+	var res server.CheckResponse
+	if err := c.do(http.MethodPost, "/v1/check", body, &res); err != nil {
+		return nil, err
+	}
+	if len(res.Results) != len(checks) {
+		return nil, fmt.Errorf("authority server: expected %d results, got %d", len(checks), len(res.Results))
+	}
+
+	return res.Results, nil
+}
+
+func (c *Client) checkOne(check server.Check) (bool, error) {
+	results, err := c.Check([]server.Check{check})
+	if err != nil {
+		return false, err
+	}
+	if results[0].Code != "" {
+		return false, toError(results[0].Code, results[0].Error)
+	}
+
+	return results[0].Allowed, nil
+}
+
+// Returns all stored roles
+func (c *Client) GetAllRoles() ([]authority.Role, error) {
+	var roles []api.Role
+	if err := c.do(http.MethodGet, "/v1/roles", nil, &roles); err != nil {
+		return nil, err
+	}
+	return toRoles(roles), nil
+}
+
+// Returns all stored permissions
+func (c *Client) GetAllPermissions() ([]authority.Permission, error) {
+	var perms []api.Permission
+	if err := c.do(http.MethodGet, "/v1/permissions", nil, &perms); err != nil {
+		return nil, err
+	}
+	return toPermissions(perms), nil
+}
+
+// Returns all user assigned roles
+func (c *Client) GetUserRoles(userID interface{}) ([]authority.Role, error) {
+	var roles []api.Role
+	path := "/v1/users/" + url.PathEscape(fmt.Sprintf("%v", userID)) + "/roles"
+	if err := c.do(http.MethodGet, path, nil, &roles); err != nil {
+		return nil, err
+	}
+	return toRoles(roles), nil
+}
+
+// Returns the ids of all users assigned a given role
+func (c *Client) GetRoleUsers(roleSlug string) ([]string, error) {
+	var users []string
+	if err := c.do(http.MethodGet, "/v1/roles/"+url.PathEscape(roleSlug)+"/users", nil, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Explains a permission check of a user
+// the roles of the explanation only have their name and slug set
+func (c *Client) ExplainUserPermission(userID interface{}, permSlug string) (authority.Explanation, error) {
+	var e api.ExplainResponse
+	q := url.Values{"user_id": {fmt.Sprintf("%v", userID)}, "permission": {permSlug}}
+	if err := c.do(http.MethodGet, "/v1/explain?"+q.Encode(), nil, &e); err != nil {
+		return authority.Explanation{}, err
+	}
+	return authority.Explanation{
+		UserID:     e.UserID,
+		Permission: e.Permission,
+		Allowed:    e.Allowed,
+		Roles:      toRoles(e.Roles),
+	}, nil
+}
+
+// Returns all role assigned permissions
+func (c *Client) GetRolePermissions(roleSlug string) ([]authority.Permission, error) {
+	var perms []api.Permission
+	if err := c.do(http.MethodGet, "/v1/roles/"+url.PathEscape(roleSlug)+"/permissions", nil, &perms); err != nil {
+		return nil, err
+	}
+	return toPermissions(perms), nil
+}
+
+func (c *Client) do(method string, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequest(method, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("authority server: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var e server.ErrorResponse
+		if err := json.NewDecoder(res.Body).Decode(&e); err != nil || e.Code == "" {
+			return fmt.Errorf("authority server: unexpected status %v", res.Status)
+		}
+		return toError(e.Code, e.Error)
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// toError maps the error codes of the server to the authority errors
+func toError(code string, msg string) error {
+	switch code {
+	case server.CodeRoleNotFound:
+		return authority.ErrRoleNotFound
+	case server.CodePermissionNotFound:
+		return authority.ErrPermissionNotFound
+	}
+	return fmt.Errorf("authority server: %v", msg)
+}
+
+func toRoles(roles []api.Role) []authority.Role {
+	res := make([]authority.Role, len(roles))
+	for i, role := range roles {
+		res[i] = authority.Role{Name: role.Name, Slug: role.Slug}
+	}
+	return res
+}
+
+func toPermissions(perms []api.Permission) []authority.Permission {
+	res := make([]authority.Permission, len(perms))
+	for i, perm := range perms {
+		res[i] = authority.Permission{Name: perm.Name, Slug: perm.Slug}
+	}
+	return res
+}
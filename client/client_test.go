@@ -0,0 +1,111 @@
+package client_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/harranali/authority"
+	"github.com/harranali/authority/client"
+	"github.com/harranali/authority/internal/testdb"
+	"github.com/harranali/authority/server"
+)
+
+func setup(t *testing.T) (*authority.Authority, *client.Client) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           testdb.SQLite(t),
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission A", Slug: "permission-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission B", Slug: "permission-b"})
+	auth.AssignPermissionsToRole("role-a", []string{"permission-a"})
+	auth.AssignRoleToUser(1, "role-a")
+
+	srv := httptest.NewServer(server.New(server.Options{Authority: auth}))
+	t.Cleanup(srv.Close)
+
+	return auth, client.New(client.Options{URL: srv.URL})
+}
+
+// testAuthorizer runs the same assertions against any implementation
+func testAuthorizer(t *testing.T, a authority.Authorizer) {
+	if ok, err := a.CheckUserPermission(1, "permission-a"); !ok || err != nil {
+		t.Error("expected the user to have the permission", err)
+	}
+	if ok, err := a.CheckUserPermission(1, "permission-b"); ok || err != nil {
+		t.Error("expected the user not to have the permission", err)
+	}
+	if _, err := a.CheckUserPermission(1, "permission-x"); !errors.Is(err, authority.ErrPermissionNotFound) {
+		t.Error("expected ErrPermissionNotFound", err)
+	}
+	if ok, err := a.CheckUserRole(1, "role-a"); !ok || err != nil {
+		t.Error("expected the user to have the role", err)
+	}
+	if _, err := a.CheckUserRole(1, "role-x"); !errors.Is(err, authority.ErrRoleNotFound) {
+		t.Error("expected ErrRoleNotFound", err)
+	}
+	if ok, err := a.CheckRolePermission("role-a", "permission-a"); !ok || err != nil {
+		t.Error("expected the role to have the permission", err)
+	}
+
+	roles, err := a.GetAllRoles()
+	if err != nil || len(roles) != 1 || roles[0].Slug != "role-a" {
+		t.Error("unexpected roles", roles, err)
+	}
+	perms, err := a.GetAllPermissions()
+	if err != nil || len(perms) != 2 {
+		t.Error("unexpected permissions", perms, err)
+	}
+	roles, err = a.GetUserRoles(1)
+	if err != nil || len(roles) != 1 || roles[0].Name != "Role A" {
+		t.Error("unexpected user roles", roles, err)
+	}
+	perms, err = a.GetRolePermissions("role-a")
+	if err != nil || len(perms) != 1 || perms[0].Slug != "permission-a" {
+		t.Error("unexpected role permissions", perms, err)
+	}
+	users, err := a.GetRoleUsers("role-a")
+	if err != nil || len(users) != 1 || users[0] != "1" {
+		t.Error("unexpected role users", users, err)
+	}
+	if _, err := a.GetRoleUsers("role-x"); !errors.Is(err, authority.ErrRoleNotFound) {
+		t.Error("expected ErrRoleNotFound", err)
+	}
+	e, err := a.ExplainUserPermission(1, "permission-a")
+	if err != nil || !e.Allowed || len(e.Roles) != 1 || e.Roles[0].Slug != "role-a" {
+		t.Error("unexpected explanation", e, err)
+	}
+	if _, err := a.ExplainUserPermission(1, "permission-x"); !errors.Is(err, authority.ErrPermissionNotFound) {
+		t.Error("expected ErrPermissionNotFound", err)
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	auth, c := setup(t)
+
+	t.Run("embedded", func(t *testing.T) { testAuthorizer(t, auth) })
+	t.Run("remote", func(t *testing.T) { testAuthorizer(t, c) })
+}
+
+func TestCheck(t *testing.T) {
+	_, c := setup(t)
+
+	results, err := c.Check([]server.Check{
+		{UserID: "1", Permission: "permission-a"},
+		{UserID: "2", Permission: "permission-a"},
+	})
+	if err != nil {
+		t.Fatal("an error was not expected", err)
+	}
+	if !results[0].Allowed || results[1].Allowed {
+		t.Error("unexpected results", results)
+	}
+}
+
+func TestUnreachableServer(t *testing.T) {
+	c := client.New(client.Options{URL: "http://127.0.0.1:1"})
+	if _, err := c.CheckUserPermission(1, "permission-a"); err == nil {
+		t.Error("expected an error for an unreachable server")
+	}
+}
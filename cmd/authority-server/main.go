@@ -0,0 +1,169 @@
+// Command authority-server answers authority checks and queries over http json
+//
+// Usage:
+//
+//	authority-server [-addr :8080] [-driver mysql] [-dsn dsn] [-prefix authority_] [-cache-ttl 5s]
+//		[-management -management-actors actor:token,... | -management-token token]
+//		[-metrics] [-log] [-log-allowed-every 100]
+//
+// The dsn defaults to the AUTHORITY_DSN environment variable. See the server
+// package for the endpoints, the -metrics flag adds the prometheus metrics
+// under /metrics and the -log flag writes the decisions and changes to stderr as
+// json
+//
+// The -management flag exposes the management api under /api/, its requests
+// must send a token as a bearer token and the changes require the
+// meta-permissions of the user making them. The -management-actors flag,
+// defaulting to the AUTHORITY_MANAGEMENT_ACTORS environment variable, gives
+// every user its own token, the token then identifies the user. The
+// -management-token flag, defaulting to the AUTHORITY_MANAGEMENT_TOKEN
+// environment variable, sets a token shared by the callers, which send the id
+// of the user in the X-Authority-Actor header. That header is trusted as is,
+// any holder of the shared token can claim any user, so only share it with
+// callers authenticating their users themselves
+//
+// Cached check results are only purged by changes made through this server,
+// changes made by other processes are seen once the results expire
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/harranali/authority"
+	"github.com/harranali/authority/internal/database"
+	"github.com/harranali/authority/metrics"
+	"github.com/harranali/authority/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// actorHeader carries the id of the user making a change through the management api with the shared token
+const actorHeader = "X-Authority-Actor"
+
+type actorKey struct{}
+
+func main() {
+	addr := flag.String("addr", ":8080", "the address to listen on")
+	driver := flag.String("driver", "mysql", "the database driver: mysql, postgres or sqlite")
+	dsn := flag.String("dsn", os.Getenv("AUTHORITY_DSN"), "the database dsn")
+	prefix := flag.String("prefix", "authority_", "the tables prefix")
+	cacheTTL := flag.Duration("cache-ttl", 5*time.Second, "how long check results are cached, 0 disables the cache. "+
+		"only changes made through this server purge the cache, changes made by other processes are seen once the results expire")
+	management := flag.Bool("management", false, "expose the management api under /api/, requires -management-token")
+	managementToken := flag.String("management-token", os.Getenv("AUTHORITY_MANAGEMENT_TOKEN"),
+		"the bearer token shared by the callers of the management api, which trusts their X-Authority-Actor header")
+	managementActors := flag.String("management-actors", os.Getenv("AUTHORITY_MANAGEMENT_ACTORS"),
+		"the bearer tokens of the users of the management api, as actor:token pairs separated by commas")
+	withMetrics := flag.Bool("metrics", false, "expose the prometheus metrics under /metrics")
+	withLog := flag.Bool("log", false, "log the denied checks, sampled allowed checks and the changes to stderr")
+	logAllowedEvery := flag.Int("log-allowed-every", 100, "log one of every n allowed checks")
+	flag.Parse()
+	if *dsn == "" || (*management && *managementToken == "" && *managementActors == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	actors, err := parseActors(*managementActors)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := database.Open(*driver, *dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := authority.Options{
+		TablesPrefix: *prefix,
+		DB:           db,
+	}
+	if *withLog {
+		opts.Logger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+		opts.LogAllowedEvery = *logAllowedEvery
+	}
+	serverOpts := server.Options{
+		CacheTTL:   *cacheTTL,
+		Management: *management,
+		ManagementActor: func(r *http.Request) (interface{}, bool) {
+			actorID, _ := r.Context().Value(actorKey{}).(string)
+			return actorID, actorID != ""
+		},
+	}
+	mux := http.NewServeMux()
+	if *withMetrics {
+		reg := prometheus.NewRegistry()
+		collector, err := metrics.New(metrics.Options{Registerer: reg})
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Metrics = collector
+		serverOpts.CacheMetrics = collector
+		mux.Handle("GET /metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	}
+	serverOpts.Authority = authority.New(opts)
+	handler := server.New(serverOpts)
+	mux.Handle("/", handler)
+	if *management {
+		mux.Handle("/api/", requireToken(*managementToken, actors, handler))
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	log.Printf("authority-server listening on %v", *addr)
+	log.Fatal(srv.ListenAndServe())
+}
+
+// parseActors returns the actor ids by token of the actor:token pairs
+func parseActors(pairs string) (map[string]string, error) {
+	actors := map[string]string{}
+	for _, pair := range strings.Split(pairs, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		actorID, token, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || actorID == "" || token == "" {
+			return nil, fmt.Errorf("invalid management actor '%v', expected actor:token", actorID+":...")
+		}
+		actors[token] = actorID
+	}
+
+	return actors, nil
+}
+
+// requireToken rejects the requests without a known bearer token or an actor
+// the actor is the user of the token, or the X-Authority-Actor header with the shared token
+func requireToken(shared string, actors map[string]string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		actorID := ""
+		if ok {
+			for token, id := range actors {
+				if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+					actorID = id
+				}
+			}
+			if actorID == "" && shared != "" && subtle.ConstantTimeCompare([]byte(got), []byte(shared)) == 1 {
+				actorID = r.Header.Get(actorHeader)
+			}
+		}
+		if actorID == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), actorKey{}, actorID)))
+	})
+}
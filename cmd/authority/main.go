@@ -0,0 +1,349 @@
+// Command authority administers an authority database
+//
+// Usage:
+//
+//	authority [flags] <command> [arguments]
+//
+// The commands are:
+//
+//	roles list
+//	roles create <slug> <name>
+//	roles rename <slug> <name>
+//	roles delete <slug>
+//	roles protect <slug>
+//	roles unprotect <slug>
+//	perms list
+//	perms create <slug> <name>
+//	perms rename <slug> <name>
+//	perms delete <slug>
+//	perms protect <slug>
+//	perms unprotect <slug>
+//	assign user <user-id> <role>
+//	assign role <role> <permission>...
+//	revoke user <user-id> <role>
+//	revoke role <role> <permission>
+//	revoke expired
+//	check user <user-id> <permission>
+//	check role <role> <permission>
+//	explain <user-id> <permission>
+//	sod list
+//	sod add <role> <role>
+//	sod remove <role> <role>
+//	sod validate
+//	import <file>
+//	export [file]
+//	diff <file>
+//
+// The database is selected with the -driver and -dsn flags, the dsn defaults
+// to the AUTHORITY_DSN environment variable. The -override flag allows changing
+// protected roles and permissions
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/harranali/authority"
+	"github.com/harranali/authority/internal/database"
+)
+
+var errUsage = errors.New(`usage: authority [-driver mysql|postgres|sqlite] [-dsn dsn] [-prefix authority_] [-override] <command> [arguments]
+
+commands:
+  roles list | create <slug> <name> | rename <slug> <name> | delete <slug> | protect <slug> | unprotect <slug>
+  perms list | create <slug> <name> | rename <slug> <name> | delete <slug> | protect <slug> | unprotect <slug>
+  assign user <user-id> <role> | role <role> <permission>...
+  revoke user <user-id> <role> | role <role> <permission> | expired
+  check user <user-id> <permission> | role <role> <permission>
+  explain <user-id> <permission>
+  sod list | add <role> <role> | remove <role> <role> | validate
+  import <file>
+  export [file]
+  diff <file>`)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("authority", flag.ContinueOnError)
+	driver := fs.String("driver", "mysql", "the database driver: mysql, postgres or sqlite")
+	dsn := fs.String("dsn", os.Getenv("AUTHORITY_DSN"), "the database dsn")
+	prefix := fs.String("prefix", "authority_", "the tables prefix")
+	override := fs.Bool("override", false, "allow changing protected roles and permissions")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || *dsn == "" {
+		return errUsage
+	}
+
+	db, err := database.Open(*driver, *dsn)
+	if err != nil {
+		return err
+	}
+	auth := authority.New(authority.Options{
+		TablesPrefix: *prefix,
+		DB:           db,
+	})
+	if *override {
+		auth = auth.Override()
+	}
+
+	return dispatch(auth, fs.Args(), stdout)
+}
+
+func dispatch(auth *authority.Authority, args []string, stdout io.Writer) error {
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "roles":
+		return roles(auth, args, stdout)
+	case "perms":
+		return perms(auth, args, stdout)
+	case "assign":
+		return assign(auth, args, stdout)
+	case "revoke":
+		return revoke(auth, args, stdout)
+	case "check":
+		return check(auth, args, stdout)
+	case "explain":
+		return explain(auth, args, stdout)
+	case "sod":
+		return sod(auth, args, stdout)
+	case "import":
+		return importPolicy(auth, args, stdout, false)
+	case "diff":
+		return importPolicy(auth, args, stdout, true)
+	case "export":
+		return exportPolicy(auth, args, stdout)
+	}
+
+	return fmt.Errorf("unknown command '%v'", cmd)
+}
+
+func roles(auth *authority.Authority, args []string, stdout io.Writer) error {
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		roles, err := auth.GetAllRoles()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SLUG\tNAME\tPROTECTED")
+		for _, role := range roles {
+			fmt.Fprintf(w, "%v\t%v\t%v\n", role.Slug, role.Name, role.Protected)
+		}
+		return w.Flush()
+	case len(args) == 3 && args[0] == "create":
+		return auth.CreateRole(authority.Role{Slug: args[1], Name: args[2]})
+	case len(args) == 3 && args[0] == "rename":
+		return auth.RenameRole(args[1], args[2])
+	case len(args) == 2 && args[0] == "delete":
+		return auth.DeleteRole(args[1])
+	case len(args) == 2 && (args[0] == "protect" || args[0] == "unprotect"):
+		return auth.SetRoleProtected(args[1], args[0] == "protect")
+	}
+
+	return errors.New("usage: authority roles list | create <slug> <name> | rename <slug> <name> | delete <slug> | protect <slug> | unprotect <slug>")
+}
+
+func perms(auth *authority.Authority, args []string, stdout io.Writer) error {
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		perms, err := auth.GetAllPermissions()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SLUG\tNAME\tPROTECTED")
+		for _, perm := range perms {
+			fmt.Fprintf(w, "%v\t%v\t%v\n", perm.Slug, perm.Name, perm.Protected)
+		}
+		return w.Flush()
+	case len(args) == 3 && args[0] == "create":
+		return auth.CreatePermission(authority.Permission{Slug: args[1], Name: args[2]})
+	case len(args) == 3 && args[0] == "rename":
+		return auth.RenamePermission(args[1], args[2])
+	case len(args) == 2 && args[0] == "delete":
+		return auth.DeletePermission(args[1])
+	case len(args) == 2 && (args[0] == "protect" || args[0] == "unprotect"):
+		return auth.SetPermissionProtected(args[1], args[0] == "protect")
+	}
+
+	return errors.New("usage: authority perms list | create <slug> <name> | rename <slug> <name> | delete <slug> | protect <slug> | unprotect <slug>")
+}
+
+func assign(auth *authority.Authority, args []string, stdout io.Writer) error {
+	switch {
+	case len(args) == 3 && args[0] == "user":
+		return auth.AssignRoleToUser(args[1], args[2])
+	case len(args) >= 3 && args[0] == "role":
+		return auth.AssignPermissionsToRole(args[1], args[2:])
+	}
+
+	return errors.New("usage: authority assign user <user-id> <role> | role <role> <permission>...")
+}
+
+func revoke(auth *authority.Authority, args []string, stdout io.Writer) error {
+	switch {
+	case len(args) == 3 && args[0] == "user":
+		return auth.RevokeUserRole(args[1], args[2])
+	case len(args) == 3 && args[0] == "role":
+		return auth.RevokeRolePermission(args[1], args[2])
+	case len(args) == 1 && args[0] == "expired":
+		revoked, err := auth.RevokeExpiredRoles()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%v expired assignments revoked\n", revoked)
+		return nil
+	}
+
+	return errors.New("usage: authority revoke user <user-id> <role> | role <role> <permission> | expired")
+}
+
+func check(auth *authority.Authority, args []string, stdout io.Writer) error {
+	if len(args) != 3 || (args[0] != "user" && args[0] != "role") {
+		return errors.New("usage: authority check user <user-id> <permission> | role <role> <permission>")
+	}
+
+	var ok bool
+	var err error
+	if args[0] == "user" {
+		ok, err = auth.CheckUserPermission(args[1], args[2])
+	} else {
+		ok, err = auth.CheckRolePermission(args[1], args[2])
+	}
+	if err != nil {
+		return err
+	}
+	if ok {
+		fmt.Fprintln(stdout, "allowed")
+	} else {
+		fmt.Fprintln(stdout, "denied")
+	}
+
+	return nil
+}
+
+func explain(auth *authority.Authority, args []string, stdout io.Writer) error {
+	if len(args) != 2 {
+		return errors.New("usage: authority explain <user-id> <permission>")
+	}
+
+	e, err := auth.ExplainUserPermission(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if !e.Allowed {
+		fmt.Fprintf(stdout, "denied: no role of user %v grants %v\n", e.UserID, e.Permission)
+		return nil
+	}
+	var slugs []string
+	for _, role := range e.Roles {
+		slugs = append(slugs, role.Slug)
+	}
+	fmt.Fprintf(stdout, "allowed: %v granted to user %v by %v\n", e.Permission, e.UserID, strings.Join(slugs, ", "))
+
+	return nil
+}
+
+func sod(auth *authority.Authority, args []string, stdout io.Writer) error {
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		conflicts, err := auth.GetSoDConstraints()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ROLE\tEXCLUDES")
+		for _, c := range conflicts {
+			fmt.Fprintf(w, "%v\t%v\n", c.Role, c.ConflictingRole)
+		}
+		return w.Flush()
+	case len(args) == 3 && args[0] == "add":
+		return auth.AddSoDConstraint(args[1], args[2])
+	case len(args) == 3 && args[0] == "remove":
+		return auth.RemoveSoDConstraint(args[1], args[2])
+	case len(args) == 1 && args[0] == "validate":
+		violations, err := auth.ValidateSoD()
+		if err != nil {
+			return err
+		}
+		if len(violations) == 0 {
+			fmt.Fprintln(stdout, "no violations")
+			return nil
+		}
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "USER\tROLE\tEXCLUDES")
+		for _, v := range violations {
+			fmt.Fprintf(w, "%v\t%v\t%v\n", v.UserID, v.Role, v.ConflictingRole)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		return fmt.Errorf("%v separation of duties violations", len(violations))
+	}
+
+	return errors.New("usage: authority sod list | add <role> <role> | remove <role> <role> | validate")
+}
+
+func importPolicy(auth *authority.Authority, args []string, stdout io.Writer, dryRun bool) error {
+	if len(args) != 1 {
+		if dryRun {
+			return errors.New("usage: authority diff <file>")
+		}
+		return errors.New("usage: authority import <file>")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var changes []authority.PolicyChange
+	if dryRun {
+		changes, err = auth.DiffPolicy(f)
+	} else {
+		changes, err = auth.ImportPolicy(f)
+	}
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		fmt.Fprintln(stdout, change)
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(stdout, "no changes")
+	}
+
+	return nil
+}
+
+func exportPolicy(auth *authority.Authority, args []string, stdout io.Writer) error {
+	switch len(args) {
+	case 0:
+		return auth.ExportPolicy(stdout)
+	case 1:
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		if err := auth.ExportPolicy(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	return errors.New("usage: authority export [file]")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "authority.db")
+	do := func(args ...string) (string, error) {
+		var out strings.Builder
+		err := run(append([]string{"-driver", "sqlite", "-dsn", dsn}, args...), &out)
+		return out.String(), err
+	}
+
+	steps := [][]string{
+		{"roles", "create", "role-a", "Role A"},
+		{"perms", "create", "permission-a", "Permission A"},
+		{"perms", "create", "permission-b", "Permission B"},
+		{"assign", "role", "role-a", "permission-a", "permission-b"},
+		{"assign", "user", "1", "role-a"},
+		{"revoke", "role", "role-a", "permission-b"},
+	}
+	for _, step := range steps {
+		if _, err := do(step...); err != nil {
+			t.Fatal("an error was not expected running", step, err)
+		}
+	}
+
+	out, _ := do("roles", "list")
+	if !strings.Contains(out, "role-a") || !strings.Contains(out, "Role A") {
+		t.Error("expected the role to be listed", out)
+	}
+	out, _ = do("check", "user", "1", "permission-a")
+	if out != "allowed\n" {
+		t.Error("expected the user to have the permission", out)
+	}
+	out, _ = do("check", "user", "1", "permission-b")
+	if out != "denied\n" {
+		t.Error("expected the revoked permission to be denied", out)
+	}
+	out, _ = do("explain", "1", "permission-a")
+	if out != "allowed: permission-a granted to user 1 by role-a\n" {
+		t.Error("unexpected explanation", out)
+	}
+	if _, err := do("roles", "create", "role-a", "Role A"); err == nil {
+		t.Error("expected an error creating a duplicated role")
+	}
+	if _, err := do("check", "user", "1"); err == nil {
+		t.Error("expected a usage error")
+	}
+
+	// export and diff against the exported file
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	if _, err := do("export", file); err != nil {
+		t.Fatal("an error was not expected exporting the policy", err)
+	}
+	out, _ = do("diff", file)
+	if out != "no changes\n" {
+		t.Error("expected no changes against the exported policy", out)
+	}
+
+	os.WriteFile(file, []byte("roles:\n  - {name: Role B, slug: role-b, permissions: [permission-b]}\n"), 0644)
+	out, _ = do("diff", file)
+	if out != "create-role role-b (Role B)\ngrant-permission permission-b to role role-b\n" {
+		t.Error("unexpected diff", out)
+	}
+	do("import", file)
+	out, _ = do("check", "role", "role-b", "permission-b")
+	if out != "allowed\n" {
+		t.Error("expected the imported grant", out)
+	}
+
+	// separation of duties, user 1 holds role-a and is assigned role-b directly
+	do("roles", "create", "role-c", "Role C")
+	do("assign", "user", "1", "role-b")
+	if _, err := do("sod", "add", "role-a", "role-b"); err != nil {
+		t.Fatal("an error was not expected adding the constraint", err)
+	}
+	out, _ = do("sod", "list")
+	if !strings.Contains(out, "role-a  role-b") {
+		t.Error("expected the constraint to be listed", out)
+	}
+	out, err := do("sod", "validate")
+	if err == nil || !strings.Contains(out, "1     role-a  role-b") {
+		t.Error("expected the violation to be reported", out, err)
+	}
+	do("sod", "add", "role-a", "role-c")
+	if _, err := do("assign", "user", "1", "role-c"); err == nil {
+		t.Error("expected the assignment to be rejected")
+	}
+
+	// protected roles need the override flag
+	do("roles", "protect", "role-c")
+	if _, err := do("roles", "delete", "role-c"); err == nil {
+		t.Error("expected the protected role not to be deleted")
+	}
+	if _, err := do("roles", "rename", "role-c", "Role C Renamed"); err == nil {
+		t.Error("expected the protected role not to be renamed")
+	}
+	if _, err := do("-override", "roles", "rename", "role-c", "Role C Renamed"); err != nil {
+		t.Error("an error was not expected renaming with the override flag", err)
+	}
+	out, _ = do("roles", "list")
+	if !strings.Contains(out, "Role C Renamed  true") {
+		t.Error("expected the role to be renamed and protected", out)
+	}
+
+	out, _ = do("revoke", "expired")
+	if out != "0 expired assignments revoked\n" {
+		t.Error("unexpected output", out)
+	}
+}
@@ -0,0 +1,172 @@
+package authority
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrElevationNotFound = errors.New("elevation not found")
+	ErrElevationReviewed = errors.New("elevation is already reviewed")
+	ErrElevationTooLong  = errors.New("elevation exceeds the maximum duration")
+)
+
+// The database model of a temporary elevation of a user into a role
+// the elevations are kept once expired, they are the audit trail of the temporary access
+type Elevation struct {
+	ID         uint       // Unique id (it gets set automatically by the database)
+	UserID     string     `gorm:"index"` // The id of the elevated user
+	RoleSlug   string     // The slug of the role
+	Reason     string     // Why the user needed the role
+	BreakGlass bool       `gorm:"index"` // Whether the role was taken with break-glass access
+	ExpiresAt  time.Time  // The expiry of the assignment
+	CreatedAt  time.Time  // The time of the elevation
+	ReviewedBy string     // The id of the user who reviewed the break-glass access
+	ReviewedAt *time.Time // The time of the review, nil while not reviewed
+}
+
+// TableName sets the table name
+func (e Elevation) TableName() string {
+	return auth.TablesPrefix + "elevations"
+}
+
+// Elevates a user into a pre-approved role for a limited time
+// the user must hold the elevate meta-permission of the role
+// the role is assigned until the elevation expires, RevokeExpiredRoles removes it
+// it returns the elevation
+// it returns an error wrapping ErrActorNotAllowed in case the user does not hold the meta-permission
+// in case the reason is empty or the duration exceeds MaxElevation, an error is returned
+// it returns the errors of AssignRoleToUser otherwise
+func (a *Authority) Elevate(userID interface{}, roleSlug string, duration time.Duration, reason string) (elevation Elevation, err error) {
+	a, op := a.begin("Elevate", userAttr(userID), AttrRole.String(roleSlug))
+	defer func() { op.mutated(err) }()
+
+	return a.elevate(fmt.Sprintf("%v", userID), roleSlug, duration, reason, false)
+}
+
+// Grants a user any role for a limited time in an emergency
+// the user must hold the break-glass meta-permission
+// the elevation is flagged for review and a role.break_glass event is emitted
+// it returns the elevation
+// it returns an error wrapping ErrActorNotAllowed in case the user does not hold the meta-permission
+// in case the reason is empty or the duration exceeds MaxElevation, an error is returned
+// it returns the errors of AssignRoleToUser otherwise
+func (a *Authority) BreakGlass(userID interface{}, roleSlug string, duration time.Duration, reason string) (elevation Elevation, err error) {
+	a, op := a.begin("BreakGlass", userAttr(userID), AttrRole.String(roleSlug))
+	defer func() { op.mutated(err) }()
+
+	return a.elevate(fmt.Sprintf("%v", userID), roleSlug, duration, reason, true)
+}
+
+// Returns the break-glass elevations waiting for a review, oldest first
+// it returns an error in case of any
+func (a *Authority) GetUnreviewedBreakGlass() (elevations []Elevation, err error) {
+	a, op := a.begin("GetUnreviewedBreakGlass")
+	defer func() { op.end(err) }()
+
+	res := a.DB.Where("break_glass = ?", true).Where("reviewed_at IS NULL").Order("id").Find(&elevations)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return elevations, nil
+}
+
+// Returns the elevations of a user, oldest first
+// it returns an error in case of any
+func (a *Authority) GetUserElevations(userID interface{}) (elevations []Elevation, err error) {
+	a, op := a.begin("GetUserElevations", userAttr(userID))
+	defer func() { op.end(err) }()
+
+	res := a.DB.Where("user_id = ?", fmt.Sprintf("%v", userID)).Order("id").Find(&elevations)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return elevations, nil
+}
+
+// Marks a break-glass elevation as reviewed
+// the reviewer must hold the break-glass review meta-permission, and cannot review its own elevation
+// it returns an error wrapping ErrActorNotAllowed in case the reviewer is not allowed
+// in case the elevation does not exists, is not a break-glass one or is already reviewed, an error is returned
+func (a *Authority) ReviewBreakGlass(reviewerID interface{}, elevationID uint) (err error) {
+	a, op := a.begin("ReviewBreakGlass", userAttr(reviewerID))
+	defer func() { op.mutated(err) }()
+
+	var elevation Elevation
+	res := a.DB.Where("id = ?", elevationID).Where("break_glass = ?", true).First(&elevation)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %v", ErrElevationNotFound, elevationID)
+		}
+		return res.Error
+	}
+	reviewerIDStr := fmt.Sprintf("%v", reviewerID)
+	if reviewerIDStr == elevation.UserID {
+		return fmt.Errorf("%w: actor '%v' cannot review its own elevation", ErrActorNotAllowed, reviewerID)
+	}
+	if err := a.checkMeta(reviewerID, PermissionBreakGlassReview); err != nil {
+		return err
+	}
+
+	res = a.DB.Model(Elevation{}).Where("id = ?", elevationID).Where("reviewed_at IS NULL").
+		Updates(map[string]interface{}{"reviewed_by": reviewerIDStr, "reviewed_at": time.Now()})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("%w: %v", ErrElevationReviewed, elevationID)
+	}
+
+	return nil
+}
+
+// elevate assigns the role until the elevation expires and records the elevation in the same transaction
+func (a *Authority) elevate(userID string, roleSlug string, duration time.Duration, reason string, breakGlass bool) (Elevation, error) {
+	elevation := Elevation{UserID: userID, RoleSlug: roleSlug, Reason: reason, BreakGlass: breakGlass}
+	if reason == "" {
+		return elevation, ErrReasonRequired
+	}
+	if duration <= 0 {
+		return elevation, fmt.Errorf("invalid elevation duration %v", duration)
+	}
+	if a.maxElevation > 0 && duration > a.maxElevation {
+		return elevation, fmt.Errorf("%w: %v > %v", ErrElevationTooLong, duration, a.maxElevation)
+	}
+	var role Role
+	res := a.DB.Where("slug = ?", roleSlug).First(&role)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return elevation, ErrRoleNotFound
+		}
+		return elevation, res.Error
+	}
+
+	eventType := EventRoleElevated
+	metaPermSlug := ElevatePermission(roleSlug)
+	if breakGlass {
+		eventType = EventBreakGlass
+		metaPermSlug = PermissionBreakGlass
+	}
+	if err := a.checkMeta(userID, metaPermSlug); err != nil {
+		return elevation, err
+	}
+
+	elevation.CreatedAt = time.Now()
+	elevation.ExpiresAt = elevation.CreatedAt.Add(duration)
+	err := a.assignRole(userID, roleSlug, &elevation.ExpiresAt, nil, func(db *gorm.DB) ([]Event, error) {
+		if err := db.Create(&elevation).Error; err != nil {
+			return nil, err
+		}
+		return []Event{{Type: eventType, UserID: userID, Role: roleSlug}}, nil
+	})
+	if err != nil {
+		return Elevation{}, err
+	}
+
+	return elevation, nil
+}
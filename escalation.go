@@ -0,0 +1,180 @@
+package authority
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var ErrPrivilegeEscalation = errors.New("privilege escalation")
+
+// GrantPermissionPrefix prefixes the meta-permission allowing to grant a role
+// "grant:<role>" stands for both "authority.assign.<role>" and "authority.roles.manage" limited to the role:
+// a user holding it can assign and revoke the role, and grant and revoke its permissions,
+// without holding the permissions being granted
+const GrantPermissionPrefix = "grant:"
+
+// GrantPermission returns the slug of the meta-permission allowing to grant the role
+func GrantPermission(roleSlug string) string {
+	return GrantPermissionPrefix + roleSlug
+}
+
+// IsMetaPermission returns whether the slug is a meta-permission, a built-in one or a grant one
+func IsMetaPermission(permSlug string) bool {
+	return strings.HasPrefix(permSlug, "authority.") || strings.HasPrefix(permSlug, GrantPermissionPrefix)
+}
+
+// Assigns a role to a user on behalf of an actor
+// the actor must hold the grant meta-permission of the role, or the assign one and every permission of the role
+// it accepts the actor id as the first parameter, the user id and the role slug as the next ones
+// it returns an error wrapping ErrActorNotAllowed in case the actor cannot assign the role
+// it returns an error wrapping ErrPrivilegeEscalation in case the actor does not hold the permissions of the role
+// it returns the errors of AssignRoleToUser otherwise
+func (a *Authority) AssignRoleToUserAs(actorID interface{}, userID interface{}, roleSlug string) (err error) {
+	a, op := a.begin("AssignRoleToUser", userAttr(userID), AttrRole.String(roleSlug))
+	defer func() { op.mutated(err) }()
+
+	// the permissions of the role are read in the transaction of the assignment,
+	// so a grant committed meanwhile is checked as well
+	return a.assignRole(fmt.Sprintf("%v", userID), roleSlug, nil, func(db *gorm.DB) error {
+		slugs, err := rolePermissionSlugs(db, roleSlug)
+		if err != nil {
+			return err
+		}
+		return checkGrant(db, actorID, AssignPermission(roleSlug), roleSlug, slugs)
+	}, nil)
+}
+
+// Assigns a group of permissions to a role on behalf of an actor
+// the actor must hold the grant meta-permission of the role, or the roles manage one and every permission being granted
+// meta-permissions cannot be granted on behalf of an actor
+// it accepts the actor id as the first parameter, the role slug and the permission slugs as the next ones
+// it returns an error wrapping ErrActorNotAllowed in case the actor cannot manage the role
+// it returns an error wrapping ErrPrivilegeEscalation in case the actor does not hold the permissions
+// or any of them is a meta-permission
+// it returns the errors of AssignPermissionsToRole otherwise
+func (a *Authority) AssignPermissionsToRoleAs(actorID interface{}, roleSlug string, permSlugs []string) (err error) {
+	a, op := a.begin("AssignPermissionsToRole", AttrRole.String(roleSlug), AttrPermissions.StringSlice(permSlugs))
+	defer func() { op.mutated(err) }()
+
+	for _, slug := range permSlugs {
+		if IsMetaPermission(slug) {
+			return fmt.Errorf("%w: meta-permission '%v' cannot be granted on behalf of actor '%v'", ErrPrivilegeEscalation, slug, actorID)
+		}
+	}
+
+	return a.assignPermissions(roleSlug, permSlugs, func(db *gorm.DB) error {
+		return checkGrant(db, actorID, PermissionRolesManage, roleSlug, permSlugs)
+	})
+}
+
+// checkGrant returns nil if the actor holds the grant meta-permission of the role,
+// or the given meta-permission and every granted permission
+func checkGrant(db *gorm.DB, actorID interface{}, metaPermSlug string, roleSlug string, permSlugs []string) error {
+	held, err := userPermissions(db, fmt.Sprintf("%v", actorID))
+	if err != nil {
+		return err
+	}
+
+	return checkHeld(actorID, held, permSlugs, metaPermSlug, GrantPermission(roleSlug))
+}
+
+// checkHeld returns nil if the held permissions include one of the meta-permissions and every granted permission
+// a held grant meta-permission does not require the granted permissions
+func checkHeld(actorID interface{}, held map[string]bool, permSlugs []string, metaPermSlugs ...string) error {
+	allowed := false
+	for _, slug := range metaPermSlugs {
+		if held[slug] && strings.HasPrefix(slug, GrantPermissionPrefix) {
+			return nil
+		}
+		allowed = allowed || held[slug]
+	}
+	if !allowed {
+		return fmt.Errorf("%w: actor '%v' does not hold '%v'", ErrActorNotAllowed, actorID, metaPermSlugs[0])
+	}
+
+	var missing []string
+	for _, slug := range permSlugs {
+		if !held[slug] {
+			missing = append(missing, slug)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: actor '%v' does not hold '%v'", ErrPrivilegeEscalation, actorID, strings.Join(missing, "', '"))
+	}
+
+	return nil
+}
+
+// rolePermissionSlugs returns the slugs of the permissions of the role
+func rolePermissionSlugs(db *gorm.DB, roleSlug string) ([]string, error) {
+	var role Role
+	if res := db.Where("slug = ?", roleSlug).First(&role); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, res.Error
+	}
+	var rolePerms []RolePermission
+	if res := db.Where("role_id = ?", role.ID).Find(&rolePerms); res.Error != nil {
+		return nil, res.Error
+	}
+	if len(rolePerms) == 0 {
+		return nil, nil
+	}
+	var permIDs []interface{}
+	for _, rp := range rolePerms {
+		permIDs = append(permIDs, rp.PermissionID)
+	}
+
+	var perms []Permission
+	if res := db.Where("id IN (?)", permIDs).Find(&perms); res.Error != nil {
+		return nil, res.Error
+	}
+	slugs := make([]string, len(perms))
+	for i, perm := range perms {
+		slugs[i] = perm.Slug
+	}
+
+	return slugs, nil
+}
+
+// userPermissions returns the slugs of the permissions the user holds through its roles
+func userPermissions(db *gorm.DB, userID string) (map[string]bool, error) {
+	held := map[string]bool{}
+	var userRoles []UserRole
+	if res := db.Scopes(active).Where("user_id = ?", userID).Find(&userRoles); res.Error != nil {
+		return nil, res.Error
+	}
+	if len(userRoles) == 0 {
+		return held, nil
+	}
+	var roleIDs []interface{}
+	for _, r := range userRoles {
+		roleIDs = append(roleIDs, r.RoleID)
+	}
+
+	var rolePerms []RolePermission
+	if res := db.Where("role_id IN (?)", roleIDs).Find(&rolePerms); res.Error != nil {
+		return nil, res.Error
+	}
+	if len(rolePerms) == 0 {
+		return held, nil
+	}
+	var permIDs []interface{}
+	for _, rp := range rolePerms {
+		permIDs = append(permIDs, rp.PermissionID)
+	}
+
+	var perms []Permission
+	if res := db.Where("id IN (?)", permIDs).Find(&perms); res.Error != nil {
+		return nil, res.Error
+	}
+	for _, perm := range perms {
+		held[perm.Slug] = true
+	}
+
+	return held, nil
+}
@@ -0,0 +1,226 @@
+package authority
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType is the kind of a change event
+type EventType string
+
+// The change events emitted by the mutating methods
+const (
+	EventRoleCreated           EventType = "role.created"
+	EventRoleRenamed           EventType = "role.renamed"
+	EventRoleDeleted           EventType = "role.deleted"
+	EventPermissionCreated     EventType = "permission.created"
+	EventPermissionRenamed     EventType = "permission.renamed"
+	EventPermissionDeleted     EventType = "permission.deleted"
+	EventRoleProtected         EventType = "role.protected"
+	EventRoleUnprotected       EventType = "role.unprotected"
+	EventPermissionProtected   EventType = "permission.protected"
+	EventPermissionUnprotected EventType = "permission.unprotected"
+	EventPermissionGranted     EventType = "permission.granted"   // A permission was assigned to a role
+	EventPermissionRevoked     EventType = "permission.revoked"   // A permission was revoked from a role
+	EventRoleAssigned          EventType = "role.assigned"        // A role was assigned to a user
+	EventRoleRevoked           EventType = "role.revoked"         // A role was revoked from a user
+	EventRoleElevated          EventType = "role.elevated"        // A user elevated itself into a role, after its role.assigned event
+	EventBreakGlass            EventType = "role.break_glass"     // A user took a role in an emergency, after its role.assigned event
+	EventRoleMaxHoldersSet     EventType = "role.max_holders_set" // The limit of the holders of a role was changed
+	EventSoDAdded              EventType = "sod.added"            // Two roles were declared mutually exclusive
+	EventSoDRemoved            EventType = "sod.removed"          // Two roles are no longer mutually exclusive
+)
+
+// Event describes a committed change
+// only the fields relevant to its type are set
+type Event struct {
+	Type       EventType `json:"type"`
+	UserID     string    `json:"user_id,omitempty"`
+	Role       string    `json:"role,omitempty"`
+	Permission string    `json:"permission,omitempty"`
+	Name       string    `json:"name,omitempty"` // The name of a created or renamed role or permission
+	// The limit of the holders of the role, zero when it was removed
+	MaxHolders int `json:"max_holders,omitempty"`
+	// The role excluded by Role, in the separation of duties events
+	ConflictingRole string    `json:"conflicting_role,omitempty"`
+	Time            time.Time `json:"time"`
+}
+
+// dispatcher delivers the events to the hooks and subscribers
+type dispatcher struct {
+	mu          sync.RWMutex
+	hooks       map[EventType][]func(Event)
+	all         []func(Event)
+	subscribers map[int]*subscriber
+	nextID      int
+}
+
+// subscriber is the channel of a subscription
+// done is closed when the subscription ends, so a blocked send gives up before the channel is closed
+type subscriber struct {
+	mu     sync.RWMutex
+	ch     chan Event
+	done   chan struct{}
+	closed bool
+}
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{hooks: map[EventType][]func(Event){}, subscribers: map[int]*subscriber{}}
+}
+
+// dispatch delivers the events without holding the lock,
+// so the hooks and subscribers can register hooks and subscribe
+func (d *dispatcher) dispatch(events ...Event) {
+	d.mu.RLock()
+	hooks := make(map[EventType][]func(Event), len(d.hooks))
+	for t, typed := range d.hooks {
+		hooks[t] = typed
+	}
+	all := d.all
+	subscribers := make([]*subscriber, 0, len(d.subscribers))
+	for _, sub := range d.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	d.mu.RUnlock()
+
+	for _, e := range events {
+		for _, hook := range hooks[e.Type] {
+			hook(e)
+		}
+		for _, hook := range all {
+			hook(e)
+		}
+		for _, sub := range subscribers {
+			sub.send(e)
+		}
+	}
+}
+
+// send blocks until the event is received or the subscription ends
+func (s *subscriber) send(e Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- e:
+	case <-s.done:
+	}
+}
+
+// close ends the subscription, a blocked send gives up first
+func (s *subscriber) close() {
+	close(s.done)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	close(s.ch)
+}
+
+// emit dispatches the events of a change
+// inside a transaction the events are queued until Commit
+func (a *Authority) emit(events ...Event) {
+	if a.pending != nil {
+		*a.pending = append(*a.pending, events...)
+		return
+	}
+	a.events.dispatch(events...)
+}
+
+// On registers a hook called after every committed change of the given type
+// the hooks run in the goroutine of the change, in the order they were registered
+// a hook may register hooks and subscribe, they receive the next changes
+func (a *Authority) On(t EventType, hook func(Event)) {
+	a.events.mu.Lock()
+	defer a.events.mu.Unlock()
+	a.events.hooks[t] = append(a.events.hooks[t], hook)
+}
+
+// OnEvent registers a hook called after every committed change
+func (a *Authority) OnEvent(hook func(Event)) {
+	a.events.mu.Lock()
+	defer a.events.mu.Unlock()
+	a.events.all = append(a.events.all, hook)
+}
+
+// OnRoleCreated registers a hook called after a role is created
+func (a *Authority) OnRoleCreated(hook func(Event)) { a.On(EventRoleCreated, hook) }
+
+// OnRoleRenamed registers a hook called after a role is renamed
+func (a *Authority) OnRoleRenamed(hook func(Event)) { a.On(EventRoleRenamed, hook) }
+
+// OnRoleDeleted registers a hook called after a role is deleted
+func (a *Authority) OnRoleDeleted(hook func(Event)) { a.On(EventRoleDeleted, hook) }
+
+// OnPermissionCreated registers a hook called after a permission is created
+func (a *Authority) OnPermissionCreated(hook func(Event)) { a.On(EventPermissionCreated, hook) }
+
+// OnPermissionRenamed registers a hook called after a permission is renamed
+func (a *Authority) OnPermissionRenamed(hook func(Event)) { a.On(EventPermissionRenamed, hook) }
+
+// OnPermissionDeleted registers a hook called after a permission is deleted
+func (a *Authority) OnPermissionDeleted(hook func(Event)) { a.On(EventPermissionDeleted, hook) }
+
+// OnRoleProtected registers a hook called after a role is protected
+func (a *Authority) OnRoleProtected(hook func(Event)) { a.On(EventRoleProtected, hook) }
+
+// OnRoleUnprotected registers a hook called after the protection of a role is lifted
+func (a *Authority) OnRoleUnprotected(hook func(Event)) { a.On(EventRoleUnprotected, hook) }
+
+// OnPermissionProtected registers a hook called after a permission is protected
+func (a *Authority) OnPermissionProtected(hook func(Event)) { a.On(EventPermissionProtected, hook) }
+
+// OnPermissionUnprotected registers a hook called after the protection of a permission is lifted
+func (a *Authority) OnPermissionUnprotected(hook func(Event)) { a.On(EventPermissionUnprotected, hook) }
+
+// OnPermissionGranted registers a hook called after a permission is assigned to a role
+func (a *Authority) OnPermissionGranted(hook func(Event)) { a.On(EventPermissionGranted, hook) }
+
+// OnPermissionRevoked registers a hook called after a permission is revoked from a role
+func (a *Authority) OnPermissionRevoked(hook func(Event)) { a.On(EventPermissionRevoked, hook) }
+
+// OnRoleAssigned registers a hook called after a role is assigned to a user
+func (a *Authority) OnRoleAssigned(hook func(Event)) { a.On(EventRoleAssigned, hook) }
+
+// OnRoleRevoked registers a hook called after a role is revoked from a user
+func (a *Authority) OnRoleRevoked(hook func(Event)) { a.On(EventRoleRevoked, hook) }
+
+// OnRoleElevated registers a hook called after a user elevates itself into a role
+func (a *Authority) OnRoleElevated(hook func(Event)) { a.On(EventRoleElevated, hook) }
+
+// OnBreakGlass registers a hook called after a user takes a role with break-glass access
+func (a *Authority) OnBreakGlass(hook func(Event)) { a.On(EventBreakGlass, hook) }
+
+// OnRoleMaxHoldersSet registers a hook called after the limit of the holders of a role is changed
+func (a *Authority) OnRoleMaxHoldersSet(hook func(Event)) { a.On(EventRoleMaxHoldersSet, hook) }
+
+// OnSoDAdded registers a hook called after two roles are declared mutually exclusive
+func (a *Authority) OnSoDAdded(hook func(Event)) { a.On(EventSoDAdded, hook) }
+
+// OnSoDRemoved registers a hook called after two roles are no longer mutually exclusive
+func (a *Authority) OnSoDRemoved(hook func(Event)) { a.On(EventSoDRemoved, hook) }
+
+// Subscribe returns a channel receiving every committed change and a function ending the subscription
+// the buffer is the capacity of the channel, changes block while it is full
+// so the channel must be read until the subscription is ended
+func (a *Authority) Subscribe(buffer int) (<-chan Event, func()) {
+	sub := &subscriber{ch: make(chan Event, buffer), done: make(chan struct{})}
+	a.events.mu.Lock()
+	id := a.events.nextID
+	a.events.nextID++
+	a.events.subscribers[id] = sub
+	a.events.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			a.events.mu.Lock()
+			delete(a.events.subscribers, id)
+			a.events.mu.Unlock()
+			sub.close()
+		})
+	}
+
+	return sub.ch, unsubscribe
+}
@@ -0,0 +1,63 @@
+package authority
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Assigns a role to a given user until the given time
+// the checks ignore the assignment once it expires, RevokeExpiredRoles removes it
+// it returns the errors of AssignRoleToUser
+func (a *Authority) AssignRoleToUserUntil(userID interface{}, roleSlug string, expiresAt time.Time) (err error) {
+	a, op := a.begin("AssignRoleToUserUntil", userAttr(userID), AttrRole.String(roleSlug))
+	defer func() { op.mutated(err) }()
+
+	return a.assignRole(fmt.Sprintf("%v", userID), roleSlug, &expiresAt, nil, nil)
+}
+
+// Revokes the expired role assignments, call it periodically
+// a role.revoked event is emitted for every revoked assignment
+// it returns the number of revoked assignments
+// it returns an error in case of any
+func (a *Authority) RevokeExpiredRoles() (revoked int, err error) {
+	a, op := a.begin("RevokeExpiredRoles")
+	defer func() { op.mutated(err) }()
+
+	err = a.writeAtomic(func(db *gorm.DB) ([]Event, error) {
+		var expired []UserRole
+		if res := db.Where("expires_at <= ?", time.Now()).Order("id").Find(&expired); res.Error != nil {
+			return nil, res.Error
+		}
+		if len(expired) == 0 {
+			return nil, nil
+		}
+		slugs, err := roleSlugs(db)
+		if err != nil {
+			return nil, err
+		}
+
+		var ids []uint
+		var events []Event
+		for _, ur := range expired {
+			ids = append(ids, ur.ID)
+			events = append(events, Event{Type: EventRoleRevoked, UserID: ur.UserID, Role: slugs[ur.RoleID]})
+		}
+		if res := db.Where("id IN (?)", ids).Delete(UserRole{}); res.Error != nil {
+			return nil, res.Error
+		}
+		revoked = len(ids)
+		return events, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return revoked, nil
+}
+
+// active restricts a user roles query to the assignments that have not expired
+func active(db *gorm.DB) *gorm.DB {
+	return db.Where("expires_at IS NULL OR expires_at > ?", time.Now())
+}
@@ -0,0 +1,142 @@
+// Package grpcauthz provides grpc server interceptors that enforce authority
+// permissions on incoming calls
+package grpcauthz
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/harranali/authority"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UserIDFunc extracts the id of the user making the call
+// it returns false if the call is not authenticated
+type UserIDFunc func(ctx context.Context) (interface{}, bool)
+
+// Options has the options for initiating the interceptors
+type Options struct {
+	Authority authority.Checker // The checker used for the checks, an Authority, a remote client or a fake
+	// Methods maps full method names ("/pkg.Service/Method") to the permissions the user must all have
+	// a key ending with "/*" applies to every method of the service
+	// a method mapped to no permissions only requires an authenticated user
+	Methods       map[string][]string
+	Public        []string   // Full method names callable without authentication
+	UserID        UserIDFunc // Extracts the user id, defaults to UserIDFromContext
+	AllowUnmapped bool       // Lets authenticated calls to unmapped methods through instead of denying them
+	// Logger receives the check errors, their details are not sent to the caller
+	// defaults to slog.Default()
+	Logger *slog.Logger
+}
+
+type authorizer struct {
+	auth          authority.Checker
+	methods       map[string][]string
+	public        map[string]bool
+	userID        UserIDFunc
+	allowUnmapped bool
+	logger        *slog.Logger
+}
+
+func newAuthorizer(opts Options) *authorizer {
+	a := &authorizer{
+		auth:          opts.Authority,
+		methods:       opts.Methods,
+		public:        map[string]bool{},
+		userID:        opts.UserID,
+		allowUnmapped: opts.AllowUnmapped,
+		logger:        opts.Logger,
+	}
+	for _, method := range opts.Public {
+		a.public[method] = true
+	}
+	if a.userID == nil {
+		a.userID = UserIDFromContext
+	}
+	if a.logger == nil {
+		a.logger = slog.Default()
+	}
+
+	return a
+}
+
+// UnaryServerInterceptor returns an interceptor checking the permissions of unary calls
+func UnaryServerInterceptor(opts Options) grpc.UnaryServerInterceptor {
+	a := newAuthorizer(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := a.authorize(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns an interceptor checking the permissions of streaming calls
+func StreamServerInterceptor(opts Options) grpc.StreamServerInterceptor {
+	a := newAuthorizer(opts)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := a.authorize(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// authorize returns a grpc status error if the call is not allowed
+func (a *authorizer) authorize(ctx context.Context, fullMethod string) error {
+	if a.public[fullMethod] {
+		return nil
+	}
+	userID, ok := a.userID(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "authentication required")
+	}
+	perms, ok := a.lookup(fullMethod)
+	if !ok {
+		if a.allowUnmapped {
+			return nil
+		}
+		return status.Errorf(codes.PermissionDenied, "no policy for method %v", fullMethod)
+	}
+	for _, perm := range perms {
+		ok, err := a.auth.CheckUserPermission(userID, perm)
+		if err != nil {
+			a.logger.ErrorContext(ctx, "authority grpc check failed", "method", fullMethod, "permission", perm, "error", err)
+			return status.Error(codes.Internal, "internal error")
+		}
+		if !ok {
+			return status.Errorf(codes.PermissionDenied, "permission '%v' required", perm)
+		}
+	}
+
+	return nil
+}
+
+func (a *authorizer) lookup(fullMethod string) ([]string, bool) {
+	if perms, ok := a.methods[fullMethod]; ok {
+		return perms, true
+	}
+	if i := strings.LastIndex(fullMethod, "/"); i > 0 {
+		perms, ok := a.methods[fullMethod[:i]+"/*"]
+		return perms, ok
+	}
+
+	return nil, false
+}
+
+type contextKey struct{}
+
+// WithUserID returns a copy of the context carrying the user id
+// it is meant to be called by an authentication interceptor running before these ones
+func WithUserID(ctx context.Context, userID interface{}) context.Context {
+	return context.WithValue(ctx, contextKey{}, userID)
+}
+
+// UserIDFromContext returns the user id stored in the context by WithUserID
+func UserIDFromContext(ctx context.Context) (interface{}, bool) {
+	userID := ctx.Value(contextKey{})
+	return userID, userID != nil
+}
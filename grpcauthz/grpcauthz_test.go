@@ -0,0 +1,135 @@
+package grpcauthz_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/harranali/authority"
+	"github.com/harranali/authority/grpcauthz"
+	"github.com/harranali/authority/internal/testdb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func setup(t *testing.T, opts grpcauthz.Options) healthpb.HealthClient {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           testdb.SQLite(t),
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission A", Slug: "permission-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission B", Slug: "permission-b"})
+	auth.AssignPermissionsToRole("role-a", []string{"permission-a"})
+	auth.AssignRoleToUser(1, "role-a")
+
+	opts.Authority = auth
+	opts.UserID = func(ctx context.Context) (interface{}, bool) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if ids := md.Get("user-id"); len(ids) != 0 {
+			return ids[0], true
+		}
+		return nil, false
+	}
+
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(grpcauthz.UnaryServerInterceptor(opts)),
+		grpc.StreamInterceptor(grpcauthz.StreamServerInterceptor(opts)),
+	)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal("failed to dial the server", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Stop()
+	})
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func as(userID string) context.Context {
+	if userID == "" {
+		return context.Background()
+	}
+	return metadata.AppendToOutgoingContext(context.Background(), "user-id", userID)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	client := setup(t, grpcauthz.Options{
+		Methods: map[string][]string{
+			healthpb.Health_Check_FullMethodName: {"permission-a"},
+		},
+	})
+
+	if _, err := client.Check(as("1"), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Error("an error was not expected for the permitted user", err)
+	}
+	_, err := client.Check(as("2"), &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Error("expected PermissionDenied for the user without the permission, got", err)
+	}
+	_, err = client.Check(as(""), &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Error("expected Unauthenticated for the anonymous call, got", err)
+	}
+
+	client = setup(t, grpcauthz.Options{
+		Methods: map[string][]string{
+			"/grpc.health.v1.Health/*": {"permission-x"},
+		},
+	})
+	_, err = client.Check(as("1"), &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.Internal || status.Convert(err).Message() != "internal error" {
+		t.Error("expected Internal without the details for an unknown permission, got", err)
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	client := setup(t, grpcauthz.Options{
+		Methods: map[string][]string{
+			"/grpc.health.v1.Health/*": {"permission-b"},
+		},
+		Public: []string{healthpb.Health_Check_FullMethodName},
+	})
+
+	stream, err := client.Watch(as("1"), &healthpb.HealthCheckRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Error("expected PermissionDenied for the stream, got", err)
+	}
+
+	if _, err := client.Check(as(""), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Error("an error was not expected for a public method", err)
+	}
+}
+
+func TestUnmappedMethods(t *testing.T) {
+	client := setup(t, grpcauthz.Options{})
+	_, err := client.Check(as("1"), &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Error("expected unmapped methods to be denied, got", err)
+	}
+
+	client = setup(t, grpcauthz.Options{AllowUnmapped: true})
+	if _, err := client.Check(as("1"), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Error("an error was not expected with AllowUnmapped", err)
+	}
+}
@@ -0,0 +1,180 @@
+// Package httpauthz provides net/http middleware that enforces authority
+// roles and permissions on incoming requests
+package httpauthz
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/harranali/authority"
+)
+
+// UserIDFunc extracts the id of the user making the request
+// it returns false if the request is not authenticated
+type UserIDFunc func(r *http.Request) (interface{}, bool)
+
+// ErrorFunc handles an error returned while checking a requirement
+type ErrorFunc func(w http.ResponseWriter, r *http.Request, err error)
+
+// Options has the options for initiating the middleware
+type Options struct {
+	Authority    authority.Checker // The checker used for the checks, an Authority, a remote client or a fake
+	UserID       UserIDFunc        // Extracts the user id, defaults to UserIDFromContext
+	Unauthorized http.Handler      // Called when there is no user id, defaults to 401
+	Forbidden    http.Handler      // Called when a requirement is not met, defaults to 403
+	Error        ErrorFunc         // Called when a check fails with an error, defaults to 500
+}
+
+// Enforcer builds middleware that guards handlers with roles and permissions
+type Enforcer struct {
+	auth         authority.Checker
+	userID       UserIDFunc
+	unauthorized http.Handler
+	forbidden    http.Handler
+	error        ErrorFunc
+}
+
+// New initiates an enforcer
+// any handler left empty in the options is replaced with its default
+func New(opts Options) *Enforcer {
+	e := &Enforcer{
+		auth:         opts.Authority,
+		userID:       opts.UserID,
+		unauthorized: opts.Unauthorized,
+		forbidden:    opts.Forbidden,
+		error:        opts.Error,
+	}
+	if e.userID == nil {
+		e.userID = UserIDFromContext
+	}
+	if e.unauthorized == nil {
+		e.unauthorized = statusHandler(http.StatusUnauthorized)
+	}
+	if e.forbidden == nil {
+		e.forbidden = statusHandler(http.StatusForbidden)
+	}
+	if e.error == nil {
+		e.error = func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	}
+
+	return e
+}
+
+// Requirement is a single role or permission a user must have
+type Requirement struct {
+	role bool
+	slug string
+}
+
+// Permission returns a requirement satisfied by users having the given permission
+func Permission(permSlug string) Requirement {
+	return Requirement{slug: permSlug}
+}
+
+// Role returns a requirement satisfied by users having the given role
+func Role(roleSlug string) Requirement {
+	return Requirement{role: true, slug: roleSlug}
+}
+
+// String returns a readable form of the requirement
+func (req Requirement) String() string {
+	if req.role {
+		return "role:" + req.slug
+	}
+	return "permission:" + req.slug
+}
+
+func (req Requirement) check(auth authority.Checker, userID interface{}) (bool, error) {
+	if req.role {
+		return auth.CheckUserRole(userID, req.slug)
+	}
+	return auth.CheckUserPermission(userID, req.slug)
+}
+
+// RequirePermission only lets through users having the given permission
+func (e *Enforcer) RequirePermission(permSlug string) func(http.Handler) http.Handler {
+	return e.RequireAll(Permission(permSlug))
+}
+
+// RequireRole only lets through users having the given role
+func (e *Enforcer) RequireRole(roleSlug string) func(http.Handler) http.Handler {
+	return e.RequireAll(Role(roleSlug))
+}
+
+// RequireAny lets through users meeting at least one of the requirements
+// it denies every request if no requirements are given
+func (e *Enforcer) RequireAny(reqs ...Requirement) func(http.Handler) http.Handler {
+	return e.middleware(func(userID interface{}) (bool, error) {
+		for _, req := range reqs {
+			ok, err := req.check(e.auth, userID)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+}
+
+// RequireAll lets through users meeting every one of the requirements
+// it denies every request if no requirements are given
+func (e *Enforcer) RequireAll(reqs ...Requirement) func(http.Handler) http.Handler {
+	return e.middleware(func(userID interface{}) (bool, error) {
+		if len(reqs) == 0 {
+			return false, nil
+		}
+		for _, req := range reqs {
+			ok, err := req.check(e.auth, userID)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+func (e *Enforcer) middleware(allowed func(userID interface{}) (bool, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := e.userID(r)
+			if !ok {
+				e.unauthorized.ServeHTTP(w, r)
+				return
+			}
+			ok, err := allowed(userID)
+			if err != nil {
+				e.error(w, r, err)
+				return
+			}
+			if !ok {
+				e.forbidden.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type contextKey struct{}
+
+// WithUserID returns a copy of the context carrying the user id
+// it is meant to be called by the authentication middleware
+func WithUserID(ctx context.Context, userID interface{}) context.Context {
+	return context.WithValue(ctx, contextKey{}, userID)
+}
+
+// UserIDFromContext returns the user id stored in the request context by WithUserID
+func UserIDFromContext(r *http.Request) (interface{}, bool) {
+	userID := r.Context().Value(contextKey{})
+	return userID, userID != nil
+}
+
+func statusHandler(code int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(code), code)
+	})
+}
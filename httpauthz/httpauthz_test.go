@@ -0,0 +1,219 @@
+package httpauthz_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/harranali/authority"
+	"github.com/harranali/authority/httpauthz"
+	"github.com/harranali/authority/internal/testdb"
+)
+
+func setup(t *testing.T) *authority.Authority {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           testdb.SQLite(t),
+	})
+
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.CreateRole(authority.Role{Name: "Role B", Slug: "role-b"})
+	auth.CreatePermission(authority.Permission{Name: "Permission A", Slug: "permission-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission B", Slug: "permission-b"})
+	auth.AssignPermissionsToRole("role-a", []string{"permission-a"})
+	auth.AssignRoleToUser(1, "role-a")
+
+	return auth
+}
+
+func serve(h http.Handler, userID interface{}) int {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if userID != nil {
+		r = r.WithContext(httpauthz.WithUserID(r.Context(), userID))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestRequirePermission(t *testing.T) {
+	e := httpauthz.New(httpauthz.Options{Authority: setup(t)})
+	h := e.RequirePermission("permission-a")(ok)
+
+	if code := serve(h, 1); code != http.StatusOK {
+		t.Error("expected the user with the permission to pass, got", code)
+	}
+	if code := serve(h, 2); code != http.StatusForbidden {
+		t.Error("expected the user without the permission to be forbidden, got", code)
+	}
+	if code := serve(h, nil); code != http.StatusUnauthorized {
+		t.Error("expected the anonymous request to be unauthorized, got", code)
+	}
+
+	h = e.RequirePermission("permission-x")(ok)
+	if code := serve(h, 1); code != http.StatusInternalServerError {
+		t.Error("expected an unknown permission to fail, got", code)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	e := httpauthz.New(httpauthz.Options{Authority: setup(t)})
+
+	if code := serve(e.RequireRole("role-a")(ok), 1); code != http.StatusOK {
+		t.Error("expected the user with the role to pass, got", code)
+	}
+	if code := serve(e.RequireRole("role-b")(ok), 1); code != http.StatusForbidden {
+		t.Error("expected the user without the role to be forbidden, got", code)
+	}
+}
+
+func TestRequireAnyAndAll(t *testing.T) {
+	e := httpauthz.New(httpauthz.Options{Authority: setup(t)})
+
+	either := e.RequireAny(httpauthz.Role("role-b"), httpauthz.Permission("permission-a"))(ok)
+	if code := serve(either, 1); code != http.StatusOK {
+		t.Error("expected RequireAny to pass with one requirement met, got", code)
+	}
+
+	all := e.RequireAll(httpauthz.Role("role-a"), httpauthz.Permission("permission-b"))(ok)
+	if code := serve(all, 1); code != http.StatusForbidden {
+		t.Error("expected RequireAll to fail with one requirement missing, got", code)
+	}
+
+	all = e.RequireAll(httpauthz.Role("role-a"), httpauthz.Permission("permission-a"))(ok)
+	if code := serve(all, 1); code != http.StatusOK {
+		t.Error("expected RequireAll to pass with every requirement met, got", code)
+	}
+
+	if code := serve(e.RequireAny()(ok), 1); code != http.StatusForbidden {
+		t.Error("expected RequireAny without requirements to deny, got", code)
+	}
+	if code := serve(e.RequireAll()(ok), 1); code != http.StatusForbidden {
+		t.Error("expected RequireAll without requirements to deny, got", code)
+	}
+}
+
+func TestCustomHandlers(t *testing.T) {
+	var gotErr error
+	e := httpauthz.New(httpauthz.Options{
+		Authority: setup(t),
+		UserID: func(r *http.Request) (interface{}, bool) {
+			id := r.Header.Get("X-User")
+			return id, id != ""
+		},
+		Unauthorized: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}),
+		Forbidden: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}),
+		Error: func(w http.ResponseWriter, r *http.Request, err error) {
+			gotErr = err
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	})
+
+	do := func(h http.Handler, user string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if user != "" {
+			r.Header.Set("X-User", user)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	h := e.RequirePermission("permission-a")(ok)
+	if code := do(h, "1"); code != http.StatusOK {
+		t.Error("expected the extracted user to pass, got", code)
+	}
+	if code := do(h, ""); code != http.StatusTeapot {
+		t.Error("expected the custom unauthorized handler, got", code)
+	}
+	if code := do(h, "2"); code != http.StatusNotFound {
+		t.Error("expected the custom forbidden handler, got", code)
+	}
+	if code := do(e.RequireRole("role-x")(ok), "1"); code != http.StatusBadGateway {
+		t.Error("expected the custom error handler, got", code)
+	}
+	if !errors.Is(gotErr, authority.ErrRoleNotFound) {
+		t.Error("expected the error handler to receive ErrRoleNotFound, got", gotErr)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	policy, err := httpauthz.LoadPolicy(strings.NewReader(`{
+		"rules": [
+			{"method": "GET", "path": "/health", "public": true},
+			{"method": "GET", "path": "/posts/{id}", "permissions": ["permission-a"]},
+			{"method": "DELETE", "path": "/posts/{id}", "roles": ["role-b"]},
+			{"path": "/admin/*", "roles": ["role-a"], "permissions": ["permission-a"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal("an error was not expected while loading the policy", err)
+	}
+
+	e := httpauthz.New(httpauthz.Options{Authority: setup(t)})
+	h := e.Enforce(policy, ok)
+
+	do := func(method string, path string, userID interface{}) int {
+		r := httptest.NewRequest(method, path, nil)
+		if userID != nil {
+			r = r.WithContext(httpauthz.WithUserID(r.Context(), userID))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := do(http.MethodGet, "/health", nil); code != http.StatusOK {
+		t.Error("expected the public route to pass, got", code)
+	}
+	if code := do(http.MethodGet, "/posts/5", 1); code != http.StatusOK {
+		t.Error("expected the permitted user to pass, got", code)
+	}
+	if code := do(http.MethodDelete, "/posts/5", 1); code != http.StatusForbidden {
+		t.Error("expected the user without the role to be forbidden, got", code)
+	}
+	if code := do(http.MethodPost, "/admin/users/1", 1); code != http.StatusOK {
+		t.Error("expected the wildcard route to pass, got", code)
+	}
+	if code := do(http.MethodGet, "/posts", 1); code != http.StatusForbidden {
+		t.Error("expected the unmapped route to be denied, got", code)
+	}
+
+	uncovered := policy.Uncovered([]httpauthz.Route{
+		{Method: http.MethodGet, Path: "/posts/{id}"},
+		{Method: http.MethodPut, Path: "/posts/{id}"},
+		{Method: http.MethodGet, Path: "/admin/settings"},
+	})
+	expected := []httpauthz.Route{{Method: http.MethodPut, Path: "/posts/{id}"}}
+	if !reflect.DeepEqual(uncovered, expected) {
+		t.Error("unexpected uncovered routes", uncovered)
+	}
+
+	_, err = httpauthz.LoadPolicy(strings.NewReader(`{"rules": [{"path": "/a/*/b"}]}`))
+	if err == nil {
+		t.Error("expected an error for a misplaced wildcard")
+	}
+	_, err = httpauthz.LoadPolicy(strings.NewReader(`{"rules": [{"path": "/a", "public": true, "roles": ["role-a"]}]}`))
+	if err == nil {
+		t.Error("expected an error for a public route with requirements")
+	}
+	_, err = httpauthz.LoadPolicy(strings.NewReader(`{"rules": [{"path": "/a"}]}`))
+	if err == nil {
+		t.Error("expected an error for a route without requirements")
+	}
+
+	// an unvalidated rule without requirements denies everyone
+	h = e.Enforce(&httpauthz.Policy{Rules: []httpauthz.Rule{{Path: "/a"}}}, ok)
+	if code := do(http.MethodGet, "/a", 1); code != http.StatusForbidden {
+		t.Error("expected a rule without requirements to deny, got", code)
+	}
+}
@@ -0,0 +1,167 @@
+package httpauthz
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Rule maps a method and a path pattern to the requirements of the route
+// a path segment written as "{name}" matches any single segment
+// a trailing "*" segment matches the rest of the path
+type Rule struct {
+	Method      string   `json:"method"`      // The http method, empty or "*" matches any method
+	Path        string   `json:"path"`        // The path pattern, for example "/posts/{id}"
+	Permissions []string `json:"permissions"` // Permissions the user must all have
+	Roles       []string `json:"roles"`       // Roles the user must all have
+	Public      bool     `json:"public"`      // Lets every request through, even unauthenticated ones
+}
+
+// Policy is an ordered list of rules, the first matching rule applies
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Route is a method and path registered by the application
+type Route struct {
+	Method string
+	Path   string
+}
+
+// String returns the route in the "METHOD /path" form
+func (r Route) String() string {
+	return r.Method + " " + r.Path
+}
+
+// LoadPolicy reads a json encoded policy and validates it
+func LoadPolicy(r io.Reader) (*Policy, error) {
+	var p Policy
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, err
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// Validate checks the rules are well formed
+// it returns an error describing the first invalid rule
+func (p *Policy) Validate() error {
+	for i, rule := range p.Rules {
+		if !strings.HasPrefix(rule.Path, "/") {
+			return fmt.Errorf("rule %d: path '%v' must start with '/'", i, rule.Path)
+		}
+		segs := splitPath(rule.Path)
+		for j, seg := range segs {
+			if seg == "*" && j != len(segs)-1 {
+				return fmt.Errorf("rule %d: '*' must be the last segment of '%v'", i, rule.Path)
+			}
+		}
+		if rule.Public && (len(rule.Permissions) != 0 || len(rule.Roles) != 0) {
+			return fmt.Errorf("rule %d: public route '%v' cannot have requirements", i, rule.Path)
+		}
+		if !rule.Public && len(rule.Permissions) == 0 && len(rule.Roles) == 0 {
+			return fmt.Errorf("rule %d: route '%v' must be public or have requirements", i, rule.Path)
+		}
+	}
+
+	return nil
+}
+
+// Match returns the first rule matching the method and path
+func (p *Policy) Match(method string, path string) (Rule, bool) {
+	for _, rule := range p.Rules {
+		if rule.matches(method, path) {
+			return rule, true
+		}
+	}
+
+	return Rule{}, false
+}
+
+// Uncovered returns the routes no rule applies to
+// the routes are not discovered, call it at startup with every route registered on your router
+// so nothing ships unprotected
+func (p *Policy) Uncovered(routes []Route) []Route {
+	var uncovered []Route
+	for _, route := range routes {
+		if _, ok := p.Match(route.Method, route.Path); !ok {
+			uncovered = append(uncovered, route)
+		}
+	}
+
+	return uncovered
+}
+
+// Enforce wraps the handler with the requirements of the policy
+// requests matching no rule, or a non public rule without requirements, are denied with the forbidden handler
+func (e *Enforcer) Enforce(p *Policy, next http.Handler) http.Handler {
+	handlers := make([]http.Handler, len(p.Rules))
+	for i, rule := range p.Rules {
+		if rule.Public {
+			handlers[i] = next
+			continue
+		}
+		var reqs []Requirement
+		for _, perm := range rule.Permissions {
+			reqs = append(reqs, Permission(perm))
+		}
+		for _, role := range rule.Roles {
+			reqs = append(reqs, Role(role))
+		}
+		if len(reqs) == 0 {
+			// like RequireAny, no requirements deny every request
+			handlers[i] = e.RequireAny()(next)
+			continue
+		}
+		handlers[i] = e.RequireAll(reqs...)(next)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i, rule := range p.Rules {
+			if rule.matches(r.Method, r.URL.Path) {
+				handlers[i].ServeHTTP(w, r)
+				return
+			}
+		}
+		e.forbidden.ServeHTTP(w, r)
+	})
+}
+
+func (rule Rule) matches(method string, path string) bool {
+	if rule.Method != "" && rule.Method != "*" && !strings.EqualFold(rule.Method, method) {
+		return false
+	}
+	patSegs := splitPath(rule.Path)
+	pathSegs := splitPath(path)
+	for i, seg := range patSegs {
+		if seg == "*" {
+			return true
+		}
+		if i >= len(pathSegs) {
+			return false
+		}
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			continue
+		}
+		if seg != pathSegs[i] {
+			return false
+		}
+	}
+
+	return len(patSegs) == len(pathSegs)
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,217 @@
+package authority
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// The outcomes reported to Metrics
+const (
+	OutcomeAllowed = "allowed" // The check passed
+	OutcomeDenied  = "denied"  // The check failed
+	OutcomeSuccess = "success" // The change was applied
+	OutcomeError   = "error"   // The operation returned an error
+)
+
+// The attributes of the tracing spans
+const (
+	AttrUserID          = attribute.Key("authority.user_id")
+	AttrRole            = attribute.Key("authority.role")
+	AttrConflictingRole = attribute.Key("authority.conflicting_role")
+	AttrPermission      = attribute.Key("authority.permission")
+	AttrPermissions     = attribute.Key("authority.permissions")
+	AttrDecision        = attribute.Key("authority.decision")
+	AttrQueryCount      = attribute.Key("authority.query_count")
+)
+
+// Metrics receives measurements of the authority operations
+// the metrics package provides a prometheus implementation
+type Metrics interface {
+	// ObserveDecision is called after every check with its outcome and duration
+	ObserveDecision(method string, outcome string, elapsed time.Duration)
+	// ObserveMutation is called after every change with its outcome and duration
+	ObserveMutation(method string, outcome string, elapsed time.Duration)
+}
+
+// WithContext returns a copy of the authority using the given context
+// for its queries and as the parent of its tracing spans
+func (a *Authority) WithContext(ctx context.Context) *Authority {
+	withCtx := *a
+	withCtx.ctx = ctx
+	withCtx.DB = a.DB.WithContext(ctx)
+
+	return &withCtx
+}
+
+func (a *Authority) context() context.Context {
+	if a.ctx == nil {
+		return context.Background()
+	}
+
+	return a.ctx
+}
+
+// operation measures a single call of an Authority method
+type operation struct {
+	a       *Authority
+	method  string
+	attrs   []attribute.KeyValue
+	start   time.Time
+	ctx     context.Context
+	span    trace.Span
+	queries *atomic.Int64
+}
+
+// begin starts measuring a method call
+// when tracing, it returns a copy of the authority whose queries belong to the method span
+func (a *Authority) begin(method string, attrs ...attribute.KeyValue) (*Authority, *operation) {
+	op := &operation{a: a, method: method, attrs: attrs, start: time.Now(), ctx: a.context()}
+	if a.tracer == nil {
+		return a, op
+	}
+
+	spanAttrs := attrs
+	if a.hashUserIDs {
+		spanAttrs = make([]attribute.KeyValue, len(attrs))
+		for i, attr := range attrs {
+			if attr.Key == AttrUserID {
+				sum := sha256.Sum256([]byte(attr.Value.AsString()))
+				attr = AttrUserID.String(hex.EncodeToString(sum[:]))
+			}
+			spanAttrs[i] = attr
+		}
+	}
+	ctx, span := a.tracer.Start(op.ctx, "authority."+method, trace.WithAttributes(spanAttrs...))
+	op.span = span
+	op.queries = &atomic.Int64{}
+	op.ctx = context.WithValue(ctx, queryCounterKey{}, op.queries)
+
+	return a.WithContext(op.ctx), op
+}
+
+// decided ends a check
+func (op *operation) decided(ok bool, err error) {
+	outcome := OutcomeDenied
+	if err != nil {
+		outcome = OutcomeError
+	} else if ok {
+		outcome = OutcomeAllowed
+	}
+	if op.a.metrics != nil {
+		op.a.metrics.ObserveDecision(op.method, outcome, time.Since(op.start))
+	}
+	if op.span != nil && err == nil {
+		op.span.SetAttributes(AttrDecision.Bool(ok))
+	}
+	if op.a.logger != nil && (!ok || op.a.sampleAllowed()) {
+		level := slog.LevelInfo
+		if err != nil {
+			level = slog.LevelError
+		} else if !ok {
+			level = slog.LevelWarn
+		}
+		op.log(level, "authority decision", outcome, err)
+	}
+	op.end(err)
+}
+
+// mutated ends a change
+func (op *operation) mutated(err error) {
+	outcome := OutcomeSuccess
+	if err != nil {
+		outcome = OutcomeError
+	}
+	if op.a.metrics != nil {
+		op.a.metrics.ObserveMutation(op.method, outcome, time.Since(op.start))
+	}
+	if op.a.logger != nil {
+		level := slog.LevelInfo
+		if err != nil {
+			level = slog.LevelError
+		}
+		op.log(level, "authority mutation", outcome, err)
+	}
+	op.end(err)
+}
+
+// log writes the event of the call with its attributes
+func (op *operation) log(level slog.Level, msg string, outcome string, err error) {
+	attrs := []slog.Attr{
+		slog.String("method", op.method),
+		slog.String("outcome", outcome),
+	}
+	for _, attr := range op.attrs {
+		key := strings.TrimPrefix(string(attr.Key), "authority.")
+		attrs = append(attrs, slog.Any(key, attr.Value.AsInterface()))
+	}
+	attrs = append(attrs, slog.Duration("elapsed", time.Since(op.start)))
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	op.a.logger.LogAttrs(op.ctx, level, msg, attrs...)
+}
+
+// sampleAllowed reports whether an allowed decision is logged
+func (a *Authority) sampleAllowed() bool {
+	if a.logAllowedEvery <= 1 {
+		return true
+	}
+
+	return a.allowed.Add(1)%uint64(a.logAllowedEvery) == 1
+}
+
+// end ends the span of the call
+func (op *operation) end(err error) {
+	if op.span == nil {
+		return
+	}
+	op.span.SetAttributes(AttrQueryCount.Int64(op.queries.Load()))
+	if err != nil {
+		op.span.RecordError(err)
+		op.span.SetStatus(codes.Error, err.Error())
+	}
+	op.span.End()
+}
+
+// userAttr returns the user id attribute, the spans hash it if HashTracedUserIDs is set
+func userAttr(userID interface{}) attribute.KeyValue {
+	return AttrUserID.String(fmt.Sprintf("%v", userID))
+}
+
+// queryCounterKey is the context key of the query counter of a traced call
+type queryCounterKey struct{}
+
+const countQueriesCallback = "authority:count_queries"
+
+// registerQueryCounter counts the queries of the traced calls, it is registered once per gorm instance
+func registerQueryCounter(db *gorm.DB) {
+	if db.Callback().Query().Get(countQueriesCallback) != nil {
+		return
+	}
+
+	count := func(db *gorm.DB) {
+		if db.Statement.Context == nil {
+			return
+		}
+		if queries, ok := db.Statement.Context.Value(queryCounterKey{}).(*atomic.Int64); ok {
+			queries.Add(1)
+		}
+	}
+	db.Callback().Create().Register(countQueriesCallback, count)
+	db.Callback().Query().Register(countQueriesCallback, count)
+	db.Callback().Update().Register(countQueriesCallback, count)
+	db.Callback().Delete().Register(countQueriesCallback, count)
+	db.Callback().Row().Register(countQueriesCallback, count)
+	db.Callback().Raw().Register(countQueriesCallback, count)
+}
@@ -0,0 +1,101 @@
+// Package testdb opens the databases the tests run against
+//
+// SQLite, backed by a file and in memory, is always available. MySQL and
+// Postgres are added when their dsn is set in the AUTHORITY_TEST_MYSQL_DSN
+// and AUTHORITY_TEST_POSTGRES_DSN environment variables, for example
+//
+//	AUTHORITY_TEST_MYSQL_DSN="root:root@tcp(127.0.0.1:3306)/db_test?charset=utf8mb4&parseTime=True&loc=Local"
+//	AUTHORITY_TEST_POSTGRES_DSN="host=127.0.0.1 user=postgres password=postgres dbname=db_test sslmode=disable"
+package testdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The names of the dialects
+const (
+	SQLiteFile   = "sqlite-file"
+	SQLiteMemory = "sqlite-memory"
+	MySQL        = "mysql"
+	Postgres     = "postgres"
+)
+
+// Dialect is a database the tests can run against
+type Dialect struct {
+	Name      string
+	Dialector func(dir string) gorm.Dialector
+}
+
+var memoryDBs int64
+
+// Dialects returns the available dialects, sqlite first
+func Dialects() []Dialect {
+	dialects := []Dialect{
+		{Name: SQLiteFile, Dialector: func(dir string) gorm.Dialector {
+			return sqlite.Open(filepath.Join(dir, "authority.db"))
+		}},
+		{Name: SQLiteMemory, Dialector: func(dir string) gorm.Dialector {
+			n := atomic.AddInt64(&memoryDBs, 1)
+			return sqlite.Open(fmt.Sprintf("file:authority-%d?mode=memory&cache=shared", n))
+		}},
+	}
+	if dsn := os.Getenv("AUTHORITY_TEST_MYSQL_DSN"); dsn != "" {
+		dialects = append(dialects, Dialect{Name: MySQL, Dialector: func(string) gorm.Dialector {
+			return mysql.Open(dsn)
+		}})
+	}
+	if dsn := os.Getenv("AUTHORITY_TEST_POSTGRES_DSN"); dsn != "" {
+		dialects = append(dialects, Dialect{Name: Postgres, Dialector: func(string) gorm.Dialector {
+			return postgres.Open(dsn)
+		}})
+	}
+
+	return dialects
+}
+
+// Open connects to the dialect
+// sqlite databases are created in the directory and in memory databases are
+// limited to a single connection, as sqlite only allows one writer at a time
+func Open(d Dialect, dir string) (*gorm.DB, error) {
+	db, err := gorm.Open(d.Dialector(dir), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if d.Name == SQLiteMemory {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	return db, nil
+}
+
+// SQLite returns a new sqlite database stored in a temporary directory of the test
+func SQLite(t testing.TB) *gorm.DB {
+	t.Helper()
+	db, err := Open(Dialects()[0], t.TempDir())
+	if err != nil {
+		t.Fatal("failed to open the database", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	return db
+}
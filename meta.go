@@ -0,0 +1,201 @@
+package authority
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrActorNotAllowed = errors.New("actor is not allowed")
+
+// The built-in meta-permissions governing the management of authority itself
+const (
+	PermissionRolesManage       = "authority.roles.manage"       // Create and delete roles, grant and revoke their permissions
+	PermissionPermissionsManage = "authority.permissions.manage" // Create and delete permissions
+	AssignPermissionPrefix      = "authority.assign."            // Followed by a role slug, assign and revoke the role
+	ElevatePermissionPrefix     = "authority.elevate."           // Followed by a role slug, elevate oneself into the role
+	PermissionBreakGlass        = "authority.breakglass"         // Take any role in an emergency, flagged for review
+	PermissionBreakGlassReview  = "authority.breakglass.review"  // Review the break-glass elevations
+)
+
+// AssignPermission returns the slug of the meta-permission allowing to assign and revoke the role
+func AssignPermission(roleSlug string) string {
+	return AssignPermissionPrefix + roleSlug
+}
+
+// ElevatePermission returns the slug of the meta-permission allowing to elevate oneself into the role
+func ElevatePermission(roleSlug string) string {
+	return ElevatePermissionPrefix + roleSlug
+}
+
+// Creates the built-in meta-permissions missing from the database,
+// including the assign, grant and elevate meta-permissions of every role
+// call it again after creating roles
+// it returns an error in case of any
+func (a *Authority) EnsureMetaPermissions() error {
+	roles, err := a.GetAllRoles()
+	if err != nil {
+		return err
+	}
+	perms := []Permission{
+		{Name: "Manage Roles", Slug: PermissionRolesManage},
+		{Name: "Manage Permissions", Slug: PermissionPermissionsManage},
+		{Name: "Break Glass", Slug: PermissionBreakGlass},
+		{Name: "Review Break Glass", Slug: PermissionBreakGlassReview},
+	}
+	for _, role := range roles {
+		perms = append(perms,
+			Permission{Name: "Assign " + role.Name, Slug: AssignPermission(role.Slug)},
+			Permission{Name: "Grant " + role.Name, Slug: GrantPermission(role.Slug)},
+			Permission{Name: "Elevate to " + role.Name, Slug: ElevatePermission(role.Slug)},
+		)
+	}
+
+	for _, perm := range perms {
+		err := a.CreatePermission(perm)
+		if err != nil && !errors.Is(err, ErrPermissionExists) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Creates a role on behalf of an actor holding the roles manage meta-permission
+// it returns an error wrapping ErrActorNotAllowed in case the actor does not hold it
+// it returns the errors of CreateRole otherwise
+func (a *Authority) CreateRoleAs(actorID interface{}, r Role) error {
+	if err := a.checkMeta(actorID, PermissionRolesManage); err != nil {
+		return err
+	}
+
+	return a.CreateRole(r)
+}
+
+// Deletes a role on behalf of an actor holding the roles manage meta-permission
+// it returns an error wrapping ErrActorNotAllowed in case the actor does not hold it
+// it returns the errors of DeleteRole otherwise
+func (a *Authority) DeleteRoleAs(actorID interface{}, roleSlug string) error {
+	if err := a.checkMeta(actorID, PermissionRolesManage); err != nil {
+		return err
+	}
+
+	return a.DeleteRole(roleSlug)
+}
+
+// Creates a permission on behalf of an actor holding the permissions manage meta-permission
+// it returns an error wrapping ErrActorNotAllowed in case the actor does not hold it
+// it returns the errors of CreatePermission otherwise
+func (a *Authority) CreatePermissionAs(actorID interface{}, p Permission) error {
+	if err := a.checkMeta(actorID, PermissionPermissionsManage); err != nil {
+		return err
+	}
+
+	return a.CreatePermission(p)
+}
+
+// Deletes a permission on behalf of an actor holding the permissions manage meta-permission
+// it returns an error wrapping ErrActorNotAllowed in case the actor does not hold it
+// it returns the errors of DeletePermission otherwise
+func (a *Authority) DeletePermissionAs(actorID interface{}, permSlug string) error {
+	if err := a.checkMeta(actorID, PermissionPermissionsManage); err != nil {
+		return err
+	}
+
+	return a.DeletePermission(permSlug)
+}
+
+// Revokes a user's role on behalf of an actor holding the assign or the grant meta-permission of the role
+// it returns an error wrapping ErrActorNotAllowed in case the actor holds neither
+// it returns the errors of RevokeUserRole otherwise
+func (a *Authority) RevokeUserRoleAs(actorID interface{}, userID interface{}, roleSlug string) error {
+	if err := a.checkMeta(actorID, AssignPermission(roleSlug), GrantPermission(roleSlug)); err != nil {
+		return err
+	}
+
+	return a.RevokeUserRole(userID, roleSlug)
+}
+
+// Revokes a role's permission on behalf of an actor holding the roles manage or the grant meta-permission of the role
+// it returns an error wrapping ErrActorNotAllowed in case the actor holds neither
+// it returns the errors of RevokeRolePermission otherwise
+func (a *Authority) RevokeRolePermissionAs(actorID interface{}, roleSlug string, permSlug string) error {
+	if err := a.checkMeta(actorID, PermissionRolesManage, GrantPermission(roleSlug)); err != nil {
+		return err
+	}
+
+	return a.RevokeRolePermission(roleSlug, permSlug)
+}
+
+// As returns a Manager making every change on behalf of the actor, through the As methods
+func (a *Authority) As(actorID interface{}) Manager {
+	return actorManager{a: a, actorID: actorID}
+}
+
+// checkMeta returns an error wrapping ErrActorNotAllowed unless the actor holds one of the meta-permissions
+func (a *Authority) checkMeta(actorID interface{}, metaPermSlugs ...string) error {
+	held, err := userPermissions(a.DB, fmt.Sprintf("%v", actorID))
+	if err != nil {
+		return err
+	}
+	for _, slug := range metaPermSlugs {
+		if held[slug] {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: actor '%v' does not hold '%v'", ErrActorNotAllowed, actorID, metaPermSlugs[0])
+}
+
+// actorManager implements Manager with the As methods
+type actorManager struct {
+	a       *Authority
+	actorID interface{}
+}
+
+func (m actorManager) CreateRole(r Role) error {
+	return m.a.CreateRoleAs(m.actorID, r)
+}
+
+func (m actorManager) CreatePermission(p Permission) error {
+	return m.a.CreatePermissionAs(m.actorID, p)
+}
+
+func (m actorManager) AssignPermissionsToRole(roleSlug string, permSlugs []string) error {
+	return m.a.AssignPermissionsToRoleAs(m.actorID, roleSlug, permSlugs)
+}
+
+func (m actorManager) AssignRoleToUser(userID interface{}, roleSlug string) error {
+	return m.a.AssignRoleToUserAs(m.actorID, userID, roleSlug)
+}
+
+func (m actorManager) RevokeUserRole(userID interface{}, roleSlug string) error {
+	return m.a.RevokeUserRoleAs(m.actorID, userID, roleSlug)
+}
+
+func (m actorManager) RevokeRolePermission(roleSlug string, permSlug string) error {
+	return m.a.RevokeRolePermissionAs(m.actorID, roleSlug, permSlug)
+}
+
+func (m actorManager) DeleteRole(roleSlug string) error {
+	return m.a.DeleteRoleAs(m.actorID, roleSlug)
+}
+
+func (m actorManager) DeletePermission(permSlug string) error {
+	return m.a.DeletePermissionAs(m.actorID, permSlug)
+}
+
+func (m actorManager) ImportPolicy(r io.Reader) ([]PolicyChange, error) {
+	return m.a.ImportPolicyAs(m.actorID, r)
+}
+
+// DiffPolicy and ExportPolicy only read, they are not checked
+func (m actorManager) DiffPolicy(r io.Reader) ([]PolicyChange, error) {
+	return m.a.DiffPolicy(r)
+}
+
+func (m actorManager) ExportPolicy(w io.Writer) error {
+	return m.a.ExportPolicy(w)
+}
+
+var _ Manager = actorManager{}
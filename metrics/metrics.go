@@ -0,0 +1,101 @@
+// Package metrics exports the authority measurements to prometheus
+//
+// pass the collector as the Metrics option of the authority and as the
+// CacheMetrics option of the server to record the checks, the changes and
+// the cache lookups
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Options has the options for initiating the collector
+type Options struct {
+	Registerer prometheus.Registerer // Where the metrics are registered, defaults to prometheus.DefaultRegisterer
+	Namespace  string                // The metric names prefix, defaults to "authority"
+	Buckets    []float64             // The decision and mutation duration buckets, defaults to prometheus.DefBuckets
+}
+
+// Collector records the authority measurements as prometheus metrics
+type Collector struct {
+	decisions        *prometheus.CounterVec
+	decisionDuration *prometheus.HistogramVec
+	mutations        *prometheus.CounterVec
+	mutationDuration *prometheus.HistogramVec
+	cacheLookups     *prometheus.CounterVec
+}
+
+// New creates the collector and registers its metrics
+// it returns an error in case of any
+func New(opts Options) (*Collector, error) {
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = "authority"
+	}
+	if opts.Buckets == nil {
+		opts.Buckets = prometheus.DefBuckets
+	}
+
+	c := &Collector{
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Name:      "decisions_total",
+			Help:      "The number of checks by method and outcome.",
+		}, []string{"method", "outcome"}),
+		decisionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace,
+			Name:      "decision_duration_seconds",
+			Help:      "The duration of the checks by method.",
+			Buckets:   opts.Buckets,
+		}, []string{"method"}),
+		mutations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Name:      "mutations_total",
+			Help:      "The number of changes by method and outcome.",
+		}, []string{"method", "outcome"}),
+		mutationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace,
+			Name:      "mutation_duration_seconds",
+			Help:      "The duration of the changes by method.",
+			Buckets:   opts.Buckets,
+		}, []string{"method"}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Name:      "cache_lookups_total",
+			Help:      "The number of server cache lookups by result.",
+		}, []string{"result"}),
+	}
+
+	for _, m := range []prometheus.Collector{c.decisions, c.decisionDuration, c.mutations, c.mutationDuration, c.cacheLookups} {
+		if err := opts.Registerer.Register(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// ObserveDecision records a check
+func (c *Collector) ObserveDecision(method string, outcome string, elapsed time.Duration) {
+	c.decisions.WithLabelValues(method, outcome).Inc()
+	c.decisionDuration.WithLabelValues(method).Observe(elapsed.Seconds())
+}
+
+// ObserveMutation records a change
+func (c *Collector) ObserveMutation(method string, outcome string, elapsed time.Duration) {
+	c.mutations.WithLabelValues(method, outcome).Inc()
+	c.mutationDuration.WithLabelValues(method).Observe(elapsed.Seconds())
+}
+
+// ObserveCacheLookup records a server cache lookup
+func (c *Collector) ObserveCacheLookup(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	c.cacheLookups.WithLabelValues(result).Inc()
+}
@@ -0,0 +1,69 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/harranali/authority"
+	"github.com/harranali/authority/internal/testdb"
+	"github.com/harranali/authority/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c, err := metrics.New(metrics.Options{Registerer: reg})
+	if err != nil {
+		t.Fatal("an error was not expected while creating the collector", err)
+	}
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           testdb.SQLite(t),
+		Metrics:      c,
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission A", Slug: "permission-a"})
+	auth.AssignPermissionsToRole("role-a", []string{"permission-a"})
+	auth.AssignRoleToUser(1, "role-a")
+	auth.CheckUserPermission(1, "permission-a")
+	auth.CheckUserPermission(2, "permission-a")
+	auth.CheckUserPermission(1, "permission-x")
+	c.ObserveCacheLookup(true)
+	c.ObserveCacheLookup(false)
+	c.ObserveCacheLookup(false)
+
+	expected := `
+# HELP authority_decisions_total The number of checks by method and outcome.
+# TYPE authority_decisions_total counter
+authority_decisions_total{method="CheckUserPermission",outcome="allowed"} 1
+authority_decisions_total{method="CheckUserPermission",outcome="denied"} 1
+authority_decisions_total{method="CheckUserPermission",outcome="error"} 1
+# HELP authority_mutations_total The number of changes by method and outcome.
+# TYPE authority_mutations_total counter
+authority_mutations_total{method="AssignPermissionsToRole",outcome="success"} 1
+authority_mutations_total{method="AssignRoleToUser",outcome="success"} 1
+authority_mutations_total{method="CreatePermission",outcome="success"} 1
+authority_mutations_total{method="CreateRole",outcome="success"} 1
+# HELP authority_cache_lookups_total The number of server cache lookups by result.
+# TYPE authority_cache_lookups_total counter
+authority_cache_lookups_total{result="hit"} 1
+authority_cache_lookups_total{result="miss"} 2
+`
+	err = testutil.GatherAndCompare(reg, strings.NewReader(expected), "authority_decisions_total", "authority_mutations_total", "authority_cache_lookups_total")
+	if err != nil {
+		t.Error("unexpected metrics", err)
+	}
+	if n := testutil.CollectAndCount(reg, "authority_decision_duration_seconds"); n != 1 {
+		t.Error("expected one decision duration series", n)
+	}
+	if n := testutil.CollectAndCount(reg, "authority_mutation_duration_seconds"); n != 4 {
+		t.Error("expected a mutation duration series by method", n)
+	}
+
+	_, err = metrics.New(metrics.Options{Registerer: reg})
+	if err == nil {
+		t.Error("expected an error while registering the metrics twice")
+	}
+
+}
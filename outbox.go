@@ -0,0 +1,111 @@
+package authority
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The database model of a change event waiting in the outbox
+// it is written in the same transaction as the change, the outbox package delivers it
+type OutboxEvent struct {
+	ID              uint       // Unique id (it gets set automatically by the database), the events are delivered in its order
+	Type            string     // The event type
+	UserID          string     // The user id of the event
+	Role            string     // The role slug of the event
+	Permission      string     // The permission slug of the event
+	Name            string     // The name of a created or renamed role or permission
+	MaxHolders      int        // The limit of the holders of the role
+	ConflictingRole string     // The role excluded by Role, in the separation of duties events
+	CreatedAt       time.Time  // The time of the change
+	Attempts        int        // The number of failed deliveries
+	NextAttemptAt   time.Time  `gorm:"index"` // The event is not delivered before this time
+	DeliveredAt     *time.Time `gorm:"index"` // The time of the delivery, nil while pending
+	LastError       string     // The error of the last failed delivery
+	LockedUntil     *time.Time // The end of the lease of the dispatcher delivering the event, nil while not leased
+	LockToken       string     `gorm:"index"` // Identifies the dispatcher holding the lease
+}
+
+// TableName sets the table name
+func (e OutboxEvent) TableName() string {
+	return auth.TablesPrefix + "outbox_events"
+}
+
+// Event returns the change event of the outbox event
+func (e OutboxEvent) Event() Event {
+	return Event{
+		Type:            EventType(e.Type),
+		UserID:          e.UserID,
+		Role:            e.Role,
+		Permission:      e.Permission,
+		Name:            e.Name,
+		MaxHolders:      e.MaxHolders,
+		ConflictingRole: e.ConflictingRole,
+		Time:            e.CreatedAt,
+	}
+}
+
+// record stamps the events of a change
+// when the outbox is enabled, they are stored with the given transaction of the change
+func (a *Authority) record(db *gorm.DB, events ...Event) error {
+	now := time.Now()
+	for i := range events {
+		events[i].Time = now
+	}
+	if !a.outbox || len(events) == 0 {
+		return nil
+	}
+
+	rows := make([]OutboxEvent, len(events))
+	for i, e := range events {
+		rows[i] = OutboxEvent{
+			Type:            string(e.Type),
+			UserID:          e.UserID,
+			Role:            e.Role,
+			Permission:      e.Permission,
+			Name:            e.Name,
+			MaxHolders:      e.MaxHolders,
+			ConflictingRole: e.ConflictingRole,
+			CreatedAt:       e.Time,
+			NextAttemptAt:   e.Time,
+		}
+	}
+
+	return db.Create(&rows).Error
+}
+
+// write applies a single statement change and records its events
+// it runs in a transaction when the outbox is enabled, the events are emitted once it is committed
+func (a *Authority) write(change func(db *gorm.DB) ([]Event, error)) error {
+	return a.apply(a.outbox, change)
+}
+
+// writeAtomic applies a change reading and writing several rows in a transaction and records its events
+func (a *Authority) writeAtomic(change func(db *gorm.DB) ([]Event, error)) error {
+	return a.apply(true, change)
+}
+
+func (a *Authority) apply(transaction bool, change func(db *gorm.DB) ([]Event, error)) error {
+	var events []Event
+	apply := func(db *gorm.DB) error {
+		var err error
+		events, err = change(db)
+		if err != nil {
+			return err
+		}
+		return a.record(db, events...)
+	}
+
+	var err error
+	if transaction {
+		err = a.DB.Transaction(apply)
+	} else {
+		err = apply(a.DB)
+	}
+	if err != nil {
+		return err
+	}
+
+	a.emit(events...)
+	return nil
+}
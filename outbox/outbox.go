@@ -0,0 +1,215 @@
+// Package outbox delivers the change events of the outbox table to a sink
+//
+// enable the Outbox option of the authority to store every change event in
+// the same transaction as the change, then run a dispatcher to deliver them.
+// the events are delivered at least once and in the order of their ids,
+// a failed delivery is retried with an exponential backoff and the events
+// after it wait for it
+//
+// the ids are assigned when the events are written, not when their changes
+// are committed, so the events of concurrent changes can be delivered out of
+// the order of the commits. several dispatchers can run against the same
+// outbox, each leases the events it delivers and the others skip them
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/harranali/authority"
+	"gorm.io/gorm"
+)
+
+// Sink receives the events of the outbox
+// the event id identifies the event across the retries, so the sink can ignore duplicates
+type Sink interface {
+	Deliver(ctx context.Context, e authority.OutboxEvent) error
+}
+
+// SinkFunc is a function implementing Sink
+type SinkFunc func(ctx context.Context, e authority.OutboxEvent) error
+
+// Deliver calls the function
+func (f SinkFunc) Deliver(ctx context.Context, e authority.OutboxEvent) error {
+	return f(ctx, e)
+}
+
+// Options has the options for initiating the dispatcher
+type Options struct {
+	Authority    *authority.Authority // The authority instance, created with the Outbox option
+	Sink         Sink                 // Receives the events
+	BatchSize    int                  // The maximum number of events read at once, defaults to 100
+	PollInterval time.Duration        // How often Run looks for new events, defaults to 1s
+	MinBackoff   time.Duration        // The delay after the first failed delivery, defaults to 1s
+	MaxBackoff   time.Duration        // The maximum delay between the deliveries of an event, defaults to 5m
+	// Lease is how long a dispatcher holds the events it is delivering, the other dispatchers skip them meanwhile
+	// it must exceed the time to deliver a batch, defaults to 5m
+	Lease time.Duration
+	Error func(err error) // Receives the database errors of Run, optional
+}
+
+// Dispatcher delivers the events of the outbox
+type Dispatcher struct {
+	opts Options
+}
+
+// New returns a dispatcher
+func New(opts Options) *Dispatcher {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 5 * time.Minute
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = opts.MinBackoff
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = 5 * time.Minute
+	}
+
+	return &Dispatcher{opts: opts}
+}
+
+// Run delivers the events until the context is done
+// it returns the error of the context
+func (d *Dispatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		// keep delivering while full batches are read
+		for {
+			n, err := d.Dispatch(ctx)
+			if err != nil && d.opts.Error != nil && ctx.Err() == nil {
+				d.opts.Error(err)
+			}
+			if err != nil || n < d.opts.BatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch delivers the pending events once, in their order
+// it stops at the first event not due yet, failing or leased by another dispatcher, so the next events wait for it
+// it returns the number of delivered events
+// it returns an error in case of any, a failed delivery is recorded on the event and is not returned
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	db := d.opts.Authority.DB.WithContext(ctx)
+	var events []authority.OutboxEvent
+	res := db.Where("delivered_at IS NULL").Order("id").Limit(d.opts.BatchSize).Find(&events)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+
+	// the due events before the first one not due or leased
+	now := time.Now()
+	var ids []uint
+	for _, e := range events {
+		if e.NextAttemptAt.After(now) || (e.LockedUntil != nil && e.LockedUntil.After(now)) {
+			break
+		}
+		ids = append(ids, e.ID)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// lease them, the conditional update lets a single dispatcher take each event
+	token := newToken()
+	res = db.Model(&authority.OutboxEvent{}).
+		Where("id IN (?)", ids).
+		Where("delivered_at IS NULL").
+		Where("(locked_until IS NULL OR locked_until <= ?)", now).
+		Updates(map[string]interface{}{"locked_until": now.Add(d.opts.Lease), "lock_token": token})
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	defer d.release(db, token)
+	var leased []authority.OutboxEvent
+	if res := db.Where("lock_token = ?", token).Order("id").Find(&leased); res.Error != nil {
+		return 0, res.Error
+	}
+
+	delivered := 0
+	for i, e := range leased {
+		// another dispatcher leased an earlier event
+		if e.ID != ids[i] {
+			break
+		}
+
+		if err := d.opts.Sink.Deliver(ctx, e); err != nil {
+			e.Attempts++
+			res = db.Model(&authority.OutboxEvent{}).Where("id = ?", e.ID).Updates(map[string]interface{}{
+				"attempts":        e.Attempts,
+				"next_attempt_at": time.Now().Add(d.backoff(e.Attempts)),
+				"last_error":      err.Error(),
+			})
+			return delivered, res.Error
+		}
+
+		res = db.Model(&authority.OutboxEvent{}).Where("id = ?", e.ID).Update("delivered_at", time.Now())
+		if res.Error != nil {
+			return delivered, res.Error
+		}
+		delivered++
+	}
+
+	return delivered, nil
+}
+
+// release ends the lease of the events, the undelivered ones can be taken again
+func (d *Dispatcher) release(db *gorm.DB, token string) {
+	db.Model(&authority.OutboxEvent{}).Where("lock_token = ?", token).
+		Updates(map[string]interface{}{"locked_until": nil, "lock_token": ""})
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// backoff returns the delay after the given number of failed deliveries
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.opts.MinBackoff
+	for i := 1; i < attempts && delay < d.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.opts.MaxBackoff {
+		delay = d.opts.MaxBackoff
+	}
+
+	return delay
+}
+
+// Pending returns the number of events waiting for delivery
+// it returns an error in case of any
+func (d *Dispatcher) Pending() (int64, error) {
+	var count int64
+	res := d.opts.Authority.DB.Model(&authority.OutboxEvent{}).Where("delivered_at IS NULL").Count(&count)
+
+	return count, res.Error
+}
+
+// Purge deletes the events delivered before the given time
+// it returns the number of deleted events
+// it returns an error in case of any
+func (d *Dispatcher) Purge(before time.Time) (int64, error) {
+	res := d.opts.Authority.DB.Where("delivered_at < ?", before).Delete(&authority.OutboxEvent{})
+
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,190 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/harranali/authority"
+	"github.com/harranali/authority/internal/testdb"
+	"github.com/harranali/authority/outbox"
+)
+
+func setup(t *testing.T) *authority.Authority {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           testdb.SQLite(t),
+		Outbox:       true,
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.AssignRoleToUser(1, "role-a")
+	auth.RevokeUserRole(1, "role-a")
+
+	return auth
+}
+
+func TestDispatch(t *testing.T) {
+	auth := setup(t)
+	var delivered []authority.OutboxEvent
+	d := outbox.New(outbox.Options{
+		Authority: auth,
+		Sink: outbox.SinkFunc(func(ctx context.Context, e authority.OutboxEvent) error {
+			delivered = append(delivered, e)
+			return nil
+		}),
+	})
+
+	n, err := d.Dispatch(context.Background())
+	if err != nil {
+		t.Error("an error was not expected while dispatching", err)
+	}
+	if n != 3 || len(delivered) != 3 {
+		t.Fatal("expected three delivered events", n, len(delivered))
+	}
+	if delivered[0].Type != string(authority.EventRoleCreated) || delivered[2].Type != string(authority.EventRoleRevoked) {
+		t.Error("expected the events in the order of the changes", delivered)
+	}
+	if pending, _ := d.Pending(); pending != 0 {
+		t.Error("expected no pending events", pending)
+	}
+
+	n, _ = d.Dispatch(context.Background())
+	if n != 0 {
+		t.Error("expected the delivered events not to be delivered again", n)
+	}
+
+	purged, err := d.Purge(time.Now().Add(time.Second))
+	if err != nil || purged != 3 {
+		t.Error("expected the delivered events to be purged", purged, err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	auth := setup(t)
+	fail := true
+	var delivered []uint
+	d := outbox.New(outbox.Options{
+		Authority:  auth,
+		MinBackoff: time.Hour,
+		Sink: outbox.SinkFunc(func(ctx context.Context, e authority.OutboxEvent) error {
+			if fail && e.Type == string(authority.EventRoleAssigned) {
+				return errors.New("sink unavailable")
+			}
+			delivered = append(delivered, e.ID)
+			return nil
+		}),
+	})
+
+	// the failed event blocks the next ones
+	n, err := d.Dispatch(context.Background())
+	if err != nil {
+		t.Error("an error was not expected while dispatching", err)
+	}
+	if n != 1 {
+		t.Error("expected the events after the failed one to wait", n)
+	}
+	var failed authority.OutboxEvent
+	auth.DB.Where("type = ?", authority.EventRoleAssigned).First(&failed)
+	if failed.Attempts != 1 || failed.LastError != "sink unavailable" || failed.NextAttemptAt.Before(time.Now().Add(59*time.Minute)) {
+		t.Error("expected the failure to be recorded with the backoff", failed)
+	}
+
+	// it is retried once the backoff is over
+	fail = false
+	n, _ = d.Dispatch(context.Background())
+	if n != 0 {
+		t.Error("expected the event to wait for its backoff", n)
+	}
+	auth.DB.Model(&authority.OutboxEvent{}).Where("id = ?", failed.ID).Update("next_attempt_at", time.Now())
+	n, _ = d.Dispatch(context.Background())
+	if n != 2 || len(delivered) != 3 {
+		t.Error("expected the remaining events to be delivered", n, delivered)
+	}
+}
+
+func TestLease(t *testing.T) {
+	auth := setup(t)
+	counts := map[uint]int{}
+	otherDelivered := 0
+	other := outbox.New(outbox.Options{
+		Authority: auth,
+		Sink: outbox.SinkFunc(func(ctx context.Context, e authority.OutboxEvent) error {
+			counts[e.ID]++
+			return nil
+		}),
+	})
+	d := outbox.New(outbox.Options{
+		Authority: auth,
+		Sink: outbox.SinkFunc(func(ctx context.Context, e authority.OutboxEvent) error {
+			// another dispatcher runs while the events are leased
+			if counts[e.ID] == 0 && otherDelivered == 0 {
+				n, err := other.Dispatch(ctx)
+				if err != nil {
+					t.Error("an error was not expected while dispatching", err)
+				}
+				otherDelivered += n
+			}
+			counts[e.ID]++
+			return nil
+		}),
+	})
+
+	n, err := d.Dispatch(context.Background())
+	if err != nil {
+		t.Error("an error was not expected while dispatching", err)
+	}
+	if n != 3 || otherDelivered != 0 {
+		t.Error("expected the leased events to be skipped by the other dispatcher", n, otherDelivered)
+	}
+	for id, count := range counts {
+		if count != 1 {
+			t.Error("expected the event to be delivered once", id, count)
+		}
+	}
+
+	// an expired lease is taken again
+	auth.CreateRole(authority.Role{Name: "Role B", Slug: "role-b"})
+	auth.DB.Model(&authority.OutboxEvent{}).Where("delivered_at IS NULL").
+		Updates(map[string]interface{}{"locked_until": time.Now().Add(-time.Second), "lock_token": "gone"})
+	n, _ = other.Dispatch(context.Background())
+	if n != 1 {
+		t.Error("expected the event of an expired lease to be delivered", n)
+	}
+}
+
+func TestRun(t *testing.T) {
+	auth := setup(t)
+	received := make(chan authority.OutboxEvent, 10)
+	d := outbox.New(outbox.Options{
+		Authority:    auth,
+		PollInterval: 10 * time.Millisecond,
+		Sink: outbox.SinkFunc(func(ctx context.Context, e authority.OutboxEvent) error {
+			received <- e
+			return nil
+		}),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- d.Run(ctx)
+	}()
+
+	for i := 0; i < 3; i++ {
+		<-received
+	}
+	auth.AssignRoleToUser(2, "role-a")
+	select {
+	case e := <-received:
+		if e.UserID != "2" {
+			t.Error("unexpected event", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the new event to be delivered")
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Error("expected Run to return the context error", err)
+	}
+}
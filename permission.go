@@ -5,6 +5,8 @@ type Permission struct {
 	ID   uint   // The permission id (it gets set automatically by the database)
 	Name string // The permission name
 	Slug string // String based unique identifier of the permission, (use hyphen seperated permission name '-', instead of space)
+	// Protected blocks deleting and renaming the permission, unless overridden
+	Protected bool
 }
 
 // TableName sets the table name
@@ -0,0 +1,685 @@
+package authority
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PolicyDocument is the declarative form of the roles, permissions and their assignments
+// it is read and written as yaml, json documents are accepted as well
+type PolicyDocument struct {
+	Permissions []PolicyPermission `yaml:"permissions" json:"permissions"`
+	Roles       []PolicyRole       `yaml:"roles" json:"roles"`
+	Users       []PolicyUser       `yaml:"users,omitempty" json:"users,omitempty"`
+}
+
+// PolicyPermission describes a permission in a policy document
+type PolicyPermission struct {
+	Name string `yaml:"name" json:"name"`
+	Slug string `yaml:"slug" json:"slug"`
+	// Protected sets the protection of the permission, it is left untouched when omitted
+	Protected *bool `yaml:"protected,omitempty" json:"protected,omitempty"`
+}
+
+// PolicyRole describes a role and the slugs of its permissions in a policy document
+type PolicyRole struct {
+	Name        string   `yaml:"name" json:"name"`
+	Slug        string   `yaml:"slug" json:"slug"`
+	Permissions []string `yaml:"permissions,omitempty" json:"permissions,omitempty"`
+	// Protected sets the protection of the role, it is left untouched when omitted
+	Protected *bool `yaml:"protected,omitempty" json:"protected,omitempty"`
+}
+
+// PolicyUser describes a user and the slugs of its roles in a policy document
+type PolicyUser struct {
+	ID    string   `yaml:"id" json:"id"`
+	Roles []string `yaml:"roles" json:"roles"`
+}
+
+// The kinds of changes applied by a policy import
+const (
+	PolicyCreatePermission    = "create-permission"
+	PolicyRenamePermission    = "rename-permission"
+	PolicyCreateRole          = "create-role"
+	PolicyRenameRole          = "rename-role"
+	PolicyGrantPermission     = "grant-permission"
+	PolicyRevokePermission    = "revoke-permission"
+	PolicyAssignRole          = "assign-role"
+	PolicyRevokeRole          = "revoke-role"
+	PolicyProtectPermission   = "protect-permission"
+	PolicyUnprotectPermission = "unprotect-permission"
+	PolicyProtectRole         = "protect-role"
+	PolicyUnprotectRole       = "unprotect-role"
+)
+
+// PolicyChange is a single change needed to bring the database in line with a policy document
+type PolicyChange struct {
+	Action     string // One of the Policy* change kinds
+	Role       string // The role slug, if any
+	Permission string // The permission slug, if any
+	UserID     string // The user id, if any
+	Name       string // The new name of a created or renamed role or permission
+}
+
+// String returns a readable form of the change
+func (c PolicyChange) String() string {
+	switch c.Action {
+	case PolicyCreatePermission, PolicyRenamePermission:
+		return fmt.Sprintf("%v %v (%v)", c.Action, c.Permission, c.Name)
+	case PolicyCreateRole, PolicyRenameRole:
+		return fmt.Sprintf("%v %v (%v)", c.Action, c.Role, c.Name)
+	case PolicyGrantPermission, PolicyRevokePermission:
+		return fmt.Sprintf("%v %v to role %v", c.Action, c.Permission, c.Role)
+	case PolicyProtectPermission, PolicyUnprotectPermission:
+		return fmt.Sprintf("%v %v", c.Action, c.Permission)
+	case PolicyProtectRole, PolicyUnprotectRole:
+		return fmt.Sprintf("%v %v", c.Action, c.Role)
+	default:
+		return fmt.Sprintf("%v %v to user %v", c.Action, c.Role, c.UserID)
+	}
+}
+
+// Reads a policy document and applies it in a single transaction
+// missing roles and permissions are created and renamed ones are updated
+// the permissions of every role in the document are synced, extra grants are revoked
+// the roles of every user in the document are synced, extra assignments are revoked
+// roles, permissions and users not mentioned in the document are left untouched
+// it returns the applied changes
+// it returns a SoDError in case the document assigns mutually exclusive roles to a user
+// it returns a CardinalityError in case the document exceeds the holders of a role or the roles of a user
+// it returns an error wrapping ErrRoleProtected or ErrPermissionProtected in case the document
+// renames, unprotects or revokes the permissions of protected roles and permissions, unless overridden
+// it returns an error in case of any, in which case nothing is applied
+func (a *Authority) ImportPolicy(r io.Reader) (changes []PolicyChange, err error) {
+	a, op := a.begin("ImportPolicy")
+	defer func() { op.mutated(err) }()
+
+	return a.importPolicy(r, nil)
+}
+
+// Reads a policy document and applies it on behalf of an actor
+// every change requires the meta-permission the As method making it requires,
+// and the actor must hold every permission granted to a role and every permission of an assigned role
+// meta-permissions cannot be granted on behalf of an actor
+// it returns an error wrapping ErrActorNotAllowed or ErrPrivilegeEscalation in case the actor cannot make a change
+// it returns the errors of ImportPolicy otherwise
+func (a *Authority) ImportPolicyAs(actorID interface{}, r io.Reader) (changes []PolicyChange, err error) {
+	a, op := a.begin("ImportPolicy")
+	defer func() { op.mutated(err) }()
+
+	var held map[string]bool
+	return a.importPolicy(r, func(tx *gorm.DB, changes []PolicyChange, applied bool) error {
+		if !applied {
+			// the permissions of the actor are read before the import so it cannot extend them
+			var err error
+			held, err = userPermissions(tx, fmt.Sprintf("%v", actorID))
+			return err
+		}
+		return checkPolicyActor(tx, actorID, held, changes)
+	})
+}
+
+// importPolicy applies the document in a transaction
+// the optional check function runs before and after the changes are applied
+func (a *Authority) importPolicy(r io.Reader, check func(tx *gorm.DB, changes []PolicyChange, applied bool) error) (changes []PolicyChange, err error) {
+	doc, err := DecodePolicy(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		changes, err = planPolicy(tx, doc)
+		if err != nil {
+			return err
+		}
+		if err := a.checkPolicyProtected(tx, changes); err != nil {
+			return err
+		}
+		if check != nil {
+			if err := check(tx, changes, false); err != nil {
+				return err
+			}
+		}
+		if err := lockPolicyAssignments(tx, changes); err != nil {
+			return err
+		}
+		if err := applyPolicy(tx, changes); err != nil {
+			return err
+		}
+		if check != nil {
+			if err := check(tx, changes, true); err != nil {
+				return err
+			}
+		}
+		if err := checkPolicySoD(tx, changes); err != nil {
+			return err
+		}
+		if err := checkPolicyCardinality(tx, changes, a.maxRolesPerUser); err != nil {
+			return err
+		}
+		events = nil
+		for _, c := range changes {
+			events = append(events, c.event())
+		}
+		return a.record(tx, events...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a.emit(events...)
+
+	return changes, nil
+}
+
+// Reads a policy document and returns the changes ImportPolicy would apply, without applying them
+// it returns an error in case of any
+func (a *Authority) DiffPolicy(r io.Reader) (changes []PolicyChange, err error) {
+	a, op := a.begin("DiffPolicy")
+	defer func() { op.end(err) }()
+
+	doc, err := DecodePolicy(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return planPolicy(a.DB, doc)
+}
+
+// Writes all the stored roles, permissions and user assignments as a yaml policy document
+// it returns an error in case of any
+func (a *Authority) ExportPolicy(w io.Writer) (err error) {
+	a, op := a.begin("ExportPolicy")
+	defer func() { op.end(err) }()
+
+	s, err := loadPolicyState(a.DB)
+	if err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(s.document()); err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+// checkPolicyActor returns an error if the actor holding the given permissions cannot make the applied changes
+// the assigned roles are checked with their permissions after the import
+func checkPolicyActor(tx *gorm.DB, actorID interface{}, held map[string]bool, changes []PolicyChange) error {
+	for _, c := range changes {
+		var err error
+		switch c.Action {
+		case PolicyCreatePermission, PolicyRenamePermission, PolicyProtectPermission, PolicyUnprotectPermission:
+			err = checkHeld(actorID, held, nil, PermissionPermissionsManage)
+		case PolicyCreateRole, PolicyRenameRole, PolicyProtectRole, PolicyUnprotectRole:
+			err = checkHeld(actorID, held, nil, PermissionRolesManage)
+		case PolicyGrantPermission:
+			if IsMetaPermission(c.Permission) {
+				return fmt.Errorf("%w: meta-permission '%v' cannot be granted on behalf of actor '%v'", ErrPrivilegeEscalation, c.Permission, actorID)
+			}
+			err = checkHeld(actorID, held, []string{c.Permission}, PermissionRolesManage, GrantPermission(c.Role))
+		case PolicyRevokePermission:
+			err = checkHeld(actorID, held, nil, PermissionRolesManage, GrantPermission(c.Role))
+		case PolicyAssignRole:
+			var slugs []string
+			slugs, err = rolePermissionSlugs(tx, c.Role)
+			if err == nil {
+				err = checkHeld(actorID, held, slugs, AssignPermission(c.Role), GrantPermission(c.Role))
+			}
+		case PolicyRevokeRole:
+			err = checkHeld(actorID, held, nil, AssignPermission(c.Role), GrantPermission(c.Role))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lockPolicyAssignments locks the roles assigned by the changes and then their users, like assignRole,
+// so the concurrent assignments of the roles and to the users wait for the checks of the import
+// the roles and users are locked in the order of their slugs and ids
+func lockPolicyAssignments(tx *gorm.DB, changes []PolicyChange) error {
+	lockedRoles := map[string]bool{}
+	lockedUsers := map[string]bool{}
+	var roleSlugs, userIDs []string
+	for _, c := range changes {
+		if c.Action != PolicyAssignRole {
+			continue
+		}
+		if !lockedRoles[c.Role] {
+			lockedRoles[c.Role] = true
+			roleSlugs = append(roleSlugs, c.Role)
+		}
+		if !lockedUsers[c.UserID] {
+			lockedUsers[c.UserID] = true
+			userIDs = append(userIDs, c.UserID)
+		}
+	}
+	sort.Strings(roleSlugs)
+	sort.Strings(userIDs)
+	for _, slug := range roleSlugs {
+		// the roles created by the import do not exist yet, nothing can assign them concurrently
+		var roles []Role
+		if res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("slug = ?", slug).Find(&roles); res.Error != nil {
+			return res.Error
+		}
+	}
+	for _, userID := range userIDs {
+		if err := lockUser(tx, userID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkPolicySoD returns a SoDError if the applied changes assigned mutually exclusive roles to a user
+func checkPolicySoD(tx *gorm.DB, changes []PolicyChange) error {
+	userIDs := []string{}
+	for _, c := range changes {
+		if c.Action == PolicyAssignRole {
+			userIDs = append(userIDs, c.UserID)
+		}
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	violations, err := sodViolations(tx, userIDs)
+	if err != nil || len(violations) == 0 {
+		return err
+	}
+
+	return &SoDError{violations[0]}
+}
+
+// checkPolicyProtected returns an error if the changes alter protected roles or permissions
+func (a *Authority) checkPolicyProtected(tx *gorm.DB, changes []PolicyChange) error {
+	for _, c := range changes {
+		switch c.Action {
+		case PolicyRenameRole, PolicyUnprotectRole, PolicyRevokePermission:
+			var role Role
+			if res := tx.Where("slug = ?", c.Role).First(&role); res.Error != nil {
+				return res.Error
+			}
+			if err := a.checkRoleProtected(role); err != nil {
+				return err
+			}
+		case PolicyRenamePermission, PolicyUnprotectPermission:
+			var perm Permission
+			if res := tx.Where("slug = ?", c.Permission).First(&perm); res.Error != nil {
+				return res.Error
+			}
+			if err := a.checkPermissionProtected(perm); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// event returns the change event of an applied change
+func (c PolicyChange) event() Event {
+	e := Event{Role: c.Role, Permission: c.Permission, UserID: c.UserID, Name: c.Name}
+	switch c.Action {
+	case PolicyCreatePermission:
+		e.Type = EventPermissionCreated
+	case PolicyRenamePermission:
+		e.Type = EventPermissionRenamed
+	case PolicyCreateRole:
+		e.Type = EventRoleCreated
+	case PolicyRenameRole:
+		e.Type = EventRoleRenamed
+	case PolicyProtectPermission:
+		e.Type = EventPermissionProtected
+	case PolicyUnprotectPermission:
+		e.Type = EventPermissionUnprotected
+	case PolicyProtectRole:
+		e.Type = EventRoleProtected
+	case PolicyUnprotectRole:
+		e.Type = EventRoleUnprotected
+	case PolicyGrantPermission:
+		e.Type = EventPermissionGranted
+	case PolicyRevokePermission:
+		e.Type = EventPermissionRevoked
+	case PolicyAssignRole:
+		e.Type = EventRoleAssigned
+	case PolicyRevokeRole:
+		e.Type = EventRoleRevoked
+	}
+
+	return e
+}
+
+// DecodePolicy reads a yaml or json policy document
+// it returns an error in case of an invalid document
+func DecodePolicy(r io.Reader) (PolicyDocument, error) {
+	var doc PolicyDocument
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&doc); err != nil && err != io.EOF {
+		return doc, fmt.Errorf("invalid policy document: %w", err)
+	}
+
+	return doc, nil
+}
+
+// policyState is a snapshot of the stored model
+type policyState struct {
+	roles       []Role
+	perms       []Permission
+	rolesByID   map[uint]Role
+	permsByID   map[uint]Permission
+	roleSlugs   map[string]Role
+	permSlugs   map[string]Permission
+	grants      map[uint]map[uint]bool   // role id -> permission ids
+	assignments map[string]map[uint]bool // user id -> role ids
+}
+
+func loadPolicyState(db *gorm.DB) (*policyState, error) {
+	s := &policyState{
+		rolesByID:   map[uint]Role{},
+		permsByID:   map[uint]Permission{},
+		roleSlugs:   map[string]Role{},
+		permSlugs:   map[string]Permission{},
+		grants:      map[uint]map[uint]bool{},
+		assignments: map[string]map[uint]bool{},
+	}
+	if res := db.Order("slug").Find(&s.roles); res.Error != nil {
+		return nil, res.Error
+	}
+	if res := db.Order("slug").Find(&s.perms); res.Error != nil {
+		return nil, res.Error
+	}
+	var rolePerms []RolePermission
+	if res := db.Find(&rolePerms); res.Error != nil {
+		return nil, res.Error
+	}
+	var userRoles []UserRole
+	if res := db.Scopes(active).Find(&userRoles); res.Error != nil {
+		return nil, res.Error
+	}
+
+	for _, role := range s.roles {
+		s.rolesByID[role.ID] = role
+		s.roleSlugs[role.Slug] = role
+	}
+	for _, perm := range s.perms {
+		s.permsByID[perm.ID] = perm
+		s.permSlugs[perm.Slug] = perm
+	}
+	for _, rp := range rolePerms {
+		if s.grants[rp.RoleID] == nil {
+			s.grants[rp.RoleID] = map[uint]bool{}
+		}
+		s.grants[rp.RoleID][rp.PermissionID] = true
+	}
+	for _, ur := range userRoles {
+		if s.assignments[ur.UserID] == nil {
+			s.assignments[ur.UserID] = map[uint]bool{}
+		}
+		s.assignments[ur.UserID][ur.RoleID] = true
+	}
+
+	return s, nil
+}
+
+// document returns the snapshot as a policy document, sorted by slug and user id
+func (s *policyState) document() PolicyDocument {
+	var doc PolicyDocument
+	for _, perm := range s.perms {
+		pp := PolicyPermission{Name: perm.Name, Slug: perm.Slug}
+		if perm.Protected {
+			pp.Protected = &perm.Protected
+		}
+		doc.Permissions = append(doc.Permissions, pp)
+	}
+	for _, role := range s.roles {
+		pr := PolicyRole{Name: role.Name, Slug: role.Slug}
+		if role.Protected {
+			pr.Protected = &role.Protected
+		}
+		for permID := range s.grants[role.ID] {
+			pr.Permissions = append(pr.Permissions, s.permsByID[permID].Slug)
+		}
+		sort.Strings(pr.Permissions)
+		doc.Roles = append(doc.Roles, pr)
+	}
+	var userIDs []string
+	for userID := range s.assignments {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+	for _, userID := range userIDs {
+		pu := PolicyUser{ID: userID}
+		for roleID := range s.assignments[userID] {
+			pu.Roles = append(pu.Roles, s.rolesByID[roleID].Slug)
+		}
+		sort.Strings(pu.Roles)
+		doc.Users = append(doc.Users, pu)
+	}
+
+	return doc
+}
+
+// planPolicy compares the document with the stored model and returns the changes in the order they must be applied
+func planPolicy(db *gorm.DB, doc PolicyDocument) ([]PolicyChange, error) {
+	s, err := loadPolicyState(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return PlanPolicy(s.document(), doc)
+}
+
+// PlanPolicy compares the wanted document with the current one and returns the changes in the order they must be applied
+// the current document is expected in the form written by ExportPolicy, its lists sorted by slug and user id
+// it returns an error in case the wanted document is invalid
+func PlanPolicy(current PolicyDocument, doc PolicyDocument) ([]PolicyChange, error) {
+	perms := map[string]PolicyPermission{}
+	for _, p := range current.Permissions {
+		perms[p.Slug] = p
+	}
+	roles := map[string]PolicyRole{}
+	grants := map[string]map[string]bool{}
+	for _, r := range current.Roles {
+		roles[r.Slug] = r
+		grants[r.Slug] = map[string]bool{}
+		for _, permSlug := range r.Permissions {
+			grants[r.Slug][permSlug] = true
+		}
+	}
+	assignments := map[string]map[string]bool{}
+	for _, u := range current.Users {
+		assignments[u.ID] = map[string]bool{}
+		for _, roleSlug := range u.Roles {
+			assignments[u.ID][roleSlug] = true
+		}
+	}
+
+	var changes []PolicyChange
+	permDeclared := map[string]bool{}
+	for _, p := range doc.Permissions {
+		if p.Slug == "" {
+			return nil, fmt.Errorf("invalid policy document: permission '%v' has no slug", p.Name)
+		}
+		if permDeclared[p.Slug] {
+			return nil, fmt.Errorf("invalid policy document: permission '%v' is declared twice", p.Slug)
+		}
+		permDeclared[p.Slug] = true
+		currentPerm, ok := perms[p.Slug]
+		if !ok {
+			changes = append(changes, PolicyChange{Action: PolicyCreatePermission, Permission: p.Slug, Name: p.Name})
+		} else if currentPerm.Name != p.Name {
+			changes = append(changes, PolicyChange{Action: PolicyRenamePermission, Permission: p.Slug, Name: p.Name})
+		}
+		if p.Protected != nil && *p.Protected != isProtected(currentPerm.Protected) {
+			action := PolicyProtectPermission
+			if !*p.Protected {
+				action = PolicyUnprotectPermission
+			}
+			changes = append(changes, PolicyChange{Action: action, Permission: p.Slug})
+		}
+	}
+
+	roleDeclared := map[string]bool{}
+	for _, r := range doc.Roles {
+		if r.Slug == "" {
+			return nil, fmt.Errorf("invalid policy document: role '%v' has no slug", r.Name)
+		}
+		if roleDeclared[r.Slug] {
+			return nil, fmt.Errorf("invalid policy document: role '%v' is declared twice", r.Slug)
+		}
+		roleDeclared[r.Slug] = true
+		currentRole, exists := roles[r.Slug]
+		if !exists {
+			changes = append(changes, PolicyChange{Action: PolicyCreateRole, Role: r.Slug, Name: r.Name})
+		} else if currentRole.Name != r.Name {
+			changes = append(changes, PolicyChange{Action: PolicyRenameRole, Role: r.Slug, Name: r.Name})
+		}
+		if r.Protected != nil && *r.Protected != isProtected(currentRole.Protected) {
+			action := PolicyProtectRole
+			if !*r.Protected {
+				action = PolicyUnprotectRole
+			}
+			changes = append(changes, PolicyChange{Action: action, Role: r.Slug})
+		}
+
+		wanted := map[string]bool{}
+		for _, permSlug := range r.Permissions {
+			if _, ok := perms[permSlug]; !ok && !permDeclared[permSlug] {
+				return nil, fmt.Errorf("invalid policy document: role '%v': %w: '%v'", r.Slug, ErrPermissionNotFound, permSlug)
+			}
+			wanted[permSlug] = true
+			if !grants[r.Slug][permSlug] {
+				changes = append(changes, PolicyChange{Action: PolicyGrantPermission, Role: r.Slug, Permission: permSlug})
+			}
+		}
+		for _, permSlug := range currentRole.Permissions {
+			if !wanted[permSlug] {
+				changes = append(changes, PolicyChange{Action: PolicyRevokePermission, Role: r.Slug, Permission: permSlug})
+			}
+		}
+	}
+
+	for _, u := range doc.Users {
+		if u.ID == "" {
+			return nil, fmt.Errorf("invalid policy document: user without id")
+		}
+		wanted := map[string]bool{}
+		for _, roleSlug := range u.Roles {
+			if _, ok := roles[roleSlug]; !ok && !roleDeclared[roleSlug] {
+				return nil, fmt.Errorf("invalid policy document: user '%v': %w: '%v'", u.ID, ErrRoleNotFound, roleSlug)
+			}
+			wanted[roleSlug] = true
+			if !assignments[u.ID][roleSlug] {
+				changes = append(changes, PolicyChange{Action: PolicyAssignRole, Role: roleSlug, UserID: u.ID})
+			}
+		}
+		for _, r := range current.Roles {
+			if assignments[u.ID][r.Slug] && !wanted[r.Slug] {
+				changes = append(changes, PolicyChange{Action: PolicyRevokeRole, Role: r.Slug, UserID: u.ID})
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+func isProtected(protected *bool) bool {
+	return protected != nil && *protected
+}
+
+func applyPolicy(tx *gorm.DB, changes []PolicyChange) error {
+	roleIDs := map[string]uint{}
+	permIDs := map[string]uint{}
+	roleID := func(slug string) (uint, error) {
+		if id, ok := roleIDs[slug]; ok {
+			return id, nil
+		}
+		var role Role
+		if res := tx.Where("slug = ?", slug).First(&role); res.Error != nil {
+			return 0, res.Error
+		}
+		roleIDs[slug] = role.ID
+		return role.ID, nil
+	}
+	permID := func(slug string) (uint, error) {
+		if id, ok := permIDs[slug]; ok {
+			return id, nil
+		}
+		var perm Permission
+		if res := tx.Where("slug = ?", slug).First(&perm); res.Error != nil {
+			return 0, res.Error
+		}
+		permIDs[slug] = perm.ID
+		return perm.ID, nil
+	}
+
+	for _, c := range changes {
+		var res *gorm.DB
+		switch c.Action {
+		case PolicyCreatePermission:
+			perm := Permission{Name: c.Name, Slug: c.Permission}
+			res = tx.Create(&perm)
+			permIDs[perm.Slug] = perm.ID
+		case PolicyRenamePermission:
+			res = tx.Model(Permission{}).Where("slug = ?", c.Permission).Update("name", c.Name)
+		case PolicyCreateRole:
+			role := Role{Name: c.Name, Slug: c.Role}
+			res = tx.Create(&role)
+			roleIDs[role.Slug] = role.ID
+		case PolicyRenameRole:
+			res = tx.Model(Role{}).Where("slug = ?", c.Role).Update("name", c.Name)
+		case PolicyProtectPermission, PolicyUnprotectPermission:
+			res = tx.Model(Permission{}).Where("slug = ?", c.Permission).Update("protected", c.Action == PolicyProtectPermission)
+		case PolicyProtectRole, PolicyUnprotectRole:
+			res = tx.Model(Role{}).Where("slug = ?", c.Role).Update("protected", c.Action == PolicyProtectRole)
+		default:
+			rID, err := roleID(c.Role)
+			if err != nil {
+				return err
+			}
+			switch c.Action {
+			case PolicyAssignRole:
+				// drop the expired assignment not yet revoked by RevokeExpiredRoles
+				dRes := tx.Where("user_id = ?", c.UserID).Where("role_id = ?", rID).Where("expires_at <= ?", time.Now()).Delete(UserRole{})
+				if dRes.Error != nil {
+					return dRes.Error
+				}
+				res = tx.Create(&UserRole{UserID: c.UserID, RoleID: rID})
+			case PolicyRevokeRole:
+				res = tx.Where("user_id = ?", c.UserID).Where("role_id = ?", rID).Delete(UserRole{})
+			default:
+				pID, err := permID(c.Permission)
+				if err != nil {
+					return err
+				}
+				if c.Action == PolicyGrantPermission {
+					res = tx.Create(&RolePermission{RoleID: rID, PermissionID: pID})
+				} else {
+					res = tx.Where("role_id = ?", rID).Where("permission_id = ?", pID).Delete(RolePermission{})
+				}
+			}
+		}
+		if res.Error != nil {
+			return res.Error
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package authority
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRoleProtected       = errors.New("role is protected")
+	ErrPermissionProtected = errors.New("permission is protected")
+)
+
+// Override returns a copy of the authority allowed to change protected roles and permissions
+// use it for the deliberate changes of system roles, never in cleanup jobs
+func (a *Authority) Override() *Authority {
+	override := *a
+	override.override = true
+
+	return &override
+}
+
+// Protects a role or lifts its protection
+// a protected role cannot be deleted or renamed and its permissions cannot be revoked
+// lifting the protection requires Override
+// it returns an error in case of any
+// in case the role does not exists, an error is returned
+func (a *Authority) SetRoleProtected(roleSlug string, protected bool) (err error) {
+	a, op := a.begin("SetRoleProtected", AttrRole.String(roleSlug))
+	defer func() { op.mutated(err) }()
+
+	var role Role
+	res := a.DB.Where("slug = ?", roleSlug).First(&role)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return ErrRoleNotFound
+		}
+		return res.Error
+	}
+	if !protected {
+		if err := a.checkRoleProtected(role); err != nil {
+			return err
+		}
+	}
+
+	return a.write(func(db *gorm.DB) ([]Event, error) {
+		if err := db.Model(Role{}).Where("id = ?", role.ID).Update("protected", protected).Error; err != nil {
+			return nil, err
+		}
+		if protected {
+			return []Event{{Type: EventRoleProtected, Role: roleSlug}}, nil
+		}
+		return []Event{{Type: EventRoleUnprotected, Role: roleSlug}}, nil
+	})
+}
+
+// Protects a permission or lifts its protection
+// a protected permission cannot be deleted or renamed
+// lifting the protection requires Override
+// it returns an error in case of any
+// in case the permission does not exists, an error is returned
+func (a *Authority) SetPermissionProtected(permSlug string, protected bool) (err error) {
+	a, op := a.begin("SetPermissionProtected", AttrPermission.String(permSlug))
+	defer func() { op.mutated(err) }()
+
+	var perm Permission
+	res := a.DB.Where("slug = ?", permSlug).First(&perm)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return ErrPermissionNotFound
+		}
+		return res.Error
+	}
+	if !protected {
+		if err := a.checkPermissionProtected(perm); err != nil {
+			return err
+		}
+	}
+
+	return a.write(func(db *gorm.DB) ([]Event, error) {
+		if err := db.Model(Permission{}).Where("id = ?", perm.ID).Update("protected", protected).Error; err != nil {
+			return nil, err
+		}
+		if protected {
+			return []Event{{Type: EventPermissionProtected, Permission: permSlug}}, nil
+		}
+		return []Event{{Type: EventPermissionUnprotected, Permission: permSlug}}, nil
+	})
+}
+
+// Changes the name of a role, its slug is kept
+// it returns an error in case of any
+// in case the role does not exists, an error is returned
+// in case the role is protected, an error is returned
+func (a *Authority) RenameRole(roleSlug string, name string) (err error) {
+	a, op := a.begin("RenameRole", AttrRole.String(roleSlug))
+	defer func() { op.mutated(err) }()
+
+	var role Role
+	res := a.DB.Where("slug = ?", roleSlug).First(&role)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return ErrRoleNotFound
+		}
+		return res.Error
+	}
+	if err := a.checkRoleProtected(role); err != nil {
+		return err
+	}
+
+	return a.write(func(db *gorm.DB) ([]Event, error) {
+		if err := db.Model(Role{}).Where("id = ?", role.ID).Update("name", name).Error; err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventRoleRenamed, Role: roleSlug, Name: name}}, nil
+	})
+}
+
+// Changes the name of a permission, its slug is kept
+// it returns an error in case of any
+// in case the permission does not exists, an error is returned
+// in case the permission is protected, an error is returned
+func (a *Authority) RenamePermission(permSlug string, name string) (err error) {
+	a, op := a.begin("RenamePermission", AttrPermission.String(permSlug))
+	defer func() { op.mutated(err) }()
+
+	var perm Permission
+	res := a.DB.Where("slug = ?", permSlug).First(&perm)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return ErrPermissionNotFound
+		}
+		return res.Error
+	}
+	if err := a.checkPermissionProtected(perm); err != nil {
+		return err
+	}
+
+	return a.write(func(db *gorm.DB) ([]Event, error) {
+		if err := db.Model(Permission{}).Where("id = ?", perm.ID).Update("name", name).Error; err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventPermissionRenamed, Permission: permSlug, Name: name}}, nil
+	})
+}
+
+// checkRoleProtected returns an error wrapping ErrRoleProtected if the role is protected and not overridden
+func (a *Authority) checkRoleProtected(role Role) error {
+	if role.Protected && !a.override {
+		return fmt.Errorf("%w: '%v'", ErrRoleProtected, role.Slug)
+	}
+
+	return nil
+}
+
+// checkPermissionProtected returns an error wrapping ErrPermissionProtected if the permission is protected and not overridden
+func (a *Authority) checkPermissionProtected(perm Permission) error {
+	if perm.Protected && !a.override {
+		return fmt.Errorf("%w: '%v'", ErrPermissionProtected, perm.Slug)
+	}
+
+	return nil
+}
@@ -5,6 +5,10 @@ type Role struct {
 	ID   uint   // The role id (it gets set automatically by the database)
 	Name string // The name of the role
 	Slug string // String based unique identifier of the role, (use hyphen seperated role name '-', instead of space)
+	// The maximum number of users holding the role, zero means no limit
+	MaxHolders int
+	// Protected blocks deleting and renaming the role and revoking its permissions, unless overridden
+	Protected bool
 }
 
 // TableName sets the table name
@@ -0,0 +1,391 @@
+// Package server answers authority checks and queries over http json, it is
+// the handler behind the authority-server command and lets services written
+// in other languages share one authority database
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harranali/authority"
+	"github.com/harranali/authority/api"
+)
+
+// MaxBatch is the maximum number of checks in a single request
+const MaxBatch = 1000
+
+// The error codes of the check results and error responses
+const (
+	CodeRoleNotFound       = "role_not_found"
+	CodePermissionNotFound = "permission_not_found"
+	CodeInvalid            = "invalid"
+	CodeInternal           = "internal"
+)
+
+// Options has the options for initiating the server handler
+type Options struct {
+	Authority *authority.Authority // The authority instance answering the checks
+	// CacheTTL is how long check results are cached, zero disables the cache
+	// only the changes made through this handler purge the cache, changes made by
+	// other processes or directly through the package are seen once the results expire
+	CacheTTL  time.Duration
+	CacheSize int // The maximum number of cached results, defaults to 10000
+	// Management mounts the management api of the api package under /api/
+	// successful changes through it purge the cache
+	// the api does not authenticate its callers, set ManagementActor or mount the handler behind your own authentication
+	Management bool
+	// ManagementActor returns the id of the authenticated caller of the management api, optional
+	// see the Actor option of the api package
+	ManagementActor func(r *http.Request) (interface{}, bool)
+	// CacheMetrics receives every cache lookup, optional
+	// the collector of the metrics package implements it
+	CacheMetrics CacheMetrics
+	// Logger receives the unexpected errors, their details are not sent to the caller
+	// defaults to slog.Default()
+	Logger *slog.Logger
+}
+
+// CacheMetrics receives the result of the cache lookups
+type CacheMetrics interface {
+	ObserveCacheLookup(hit bool)
+}
+
+// Check is a single check of a batch
+// set the user id and permission to check a user permission,
+// the user id and role to check a user role,
+// or the role and permission to check a role permission
+type Check struct {
+	UserID     string `json:"user_id,omitempty"`
+	Role       string `json:"role,omitempty"`
+	Permission string `json:"permission,omitempty"`
+}
+
+// CheckRequest is the body of a batch check
+type CheckRequest struct {
+	Checks []Check `json:"checks"`
+}
+
+// Result is the outcome of a single check
+type Result struct {
+	Allowed bool   `json:"allowed"`
+	Error   string `json:"error,omitempty"`
+	Code    string `json:"code,omitempty"`
+}
+
+// CheckResponse holds the results in the order of the checks
+type CheckResponse struct {
+	Results []Result `json:"results"`
+}
+
+// ErrorResponse is the body of every failed request
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+type server struct {
+	auth         *authority.Authority
+	cache        *cache
+	cacheMetrics CacheMetrics
+	logger       *slog.Logger
+	mux          *http.ServeMux
+}
+
+// New returns the server http handler
+func New(opts Options) http.Handler {
+	s := &server{
+		auth:         opts.Authority,
+		cacheMetrics: opts.CacheMetrics,
+		logger:       opts.Logger,
+		mux:          http.NewServeMux(),
+	}
+	if s.logger == nil {
+		s.logger = slog.Default()
+	}
+	if opts.CacheTTL > 0 {
+		size := opts.CacheSize
+		if size <= 0 {
+			size = 10000
+		}
+		s.cache = newCache(opts.CacheTTL, size)
+	}
+
+	s.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	s.mux.HandleFunc("POST /v1/check", s.check)
+	s.mux.HandleFunc("GET /v1/roles", s.roles)
+	s.mux.HandleFunc("GET /v1/permissions", s.permissions)
+	s.mux.HandleFunc("GET /v1/roles/{slug}/permissions", s.rolePermissions)
+	s.mux.HandleFunc("GET /v1/roles/{slug}/users", s.roleUsers)
+	s.mux.HandleFunc("GET /v1/users/{id}/roles", s.userRoles)
+	s.mux.HandleFunc("GET /v1/explain", s.explain)
+	if opts.Management {
+		management := http.StripPrefix("/api", api.New(api.Options{
+			Authority: opts.Authority,
+			BasePath:  "/api",
+			Actor:     opts.ManagementActor,
+			Logger:    opts.Logger,
+		}))
+		s.mux.Handle("/api/", s.purging(management))
+	}
+
+	return s.mux
+}
+
+func (s *server) check(w http.ResponseWriter, r *http.Request) {
+	var req CheckRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<22))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid request body: " + err.Error(), Code: CodeInvalid})
+		return
+	}
+	if len(req.Checks) > MaxBatch {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "too many checks in the batch", Code: CodeInvalid})
+		return
+	}
+
+	// the checks run in the request context so they are traced as part of the request
+	auth := s.auth.WithContext(r.Context())
+	res := CheckResponse{Results: make([]Result, len(req.Checks))}
+	for i, c := range req.Checks {
+		res.Results[i] = s.result(auth, c)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *server) result(auth *authority.Authority, c Check) Result {
+	if s.cache != nil {
+		res, ok := s.cache.get(c)
+		if s.cacheMetrics != nil {
+			s.cacheMetrics.ObserveCacheLookup(ok)
+		}
+		if ok {
+			return res
+		}
+	}
+
+	var ok bool
+	var err error
+	switch {
+	case c.UserID != "" && c.Permission != "" && c.Role == "":
+		ok, err = auth.CheckUserPermission(c.UserID, c.Permission)
+	case c.UserID != "" && c.Role != "" && c.Permission == "":
+		ok, err = auth.CheckUserRole(c.UserID, c.Role)
+	case c.UserID == "" && c.Role != "" && c.Permission != "":
+		ok, err = auth.CheckRolePermission(c.Role, c.Permission)
+	default:
+		return Result{Error: "expected two of user_id, role and permission", Code: CodeInvalid}
+	}
+
+	res := Result{Allowed: ok}
+	if err != nil {
+		res = Result{Error: err.Error(), Code: errorCode(err)}
+		if res.Code == CodeInternal {
+			s.logger.Error("authority server check failed", "user_id", c.UserID, "role", c.Role, "permission", c.Permission, "error", err)
+			res.Error = "internal error"
+		}
+	}
+	// internal errors are not cached so the next request retries
+	if s.cache != nil && res.Code != CodeInternal {
+		s.cache.put(c, res)
+	}
+
+	return res
+}
+
+func (s *server) roles(w http.ResponseWriter, r *http.Request) {
+	roles, err := s.auth.GetAllRoles()
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toRoles(roles))
+}
+
+func (s *server) permissions(w http.ResponseWriter, r *http.Request) {
+	perms, err := s.auth.GetAllPermissions()
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toPermissions(perms))
+}
+
+func (s *server) rolePermissions(w http.ResponseWriter, r *http.Request) {
+	perms, err := s.auth.GetRolePermissions(r.PathValue("slug"))
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toPermissions(perms))
+}
+
+func (s *server) roleUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := s.auth.GetRoleUsers(r.PathValue("slug"))
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	if users == nil {
+		users = []string{}
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (s *server) explain(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("user_id") == "" || q.Get("permission") == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "expected user_id and permission", Code: CodeInvalid})
+		return
+	}
+	e, err := s.auth.WithContext(r.Context()).ExplainUserPermission(q.Get("user_id"), q.Get("permission"))
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, api.ExplainResponse{
+		UserID:     e.UserID,
+		Permission: e.Permission,
+		Allowed:    e.Allowed,
+		Roles:      toRoles(e.Roles),
+	})
+}
+
+func (s *server) userRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := s.auth.GetUserRoles(r.PathValue("id"))
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toRoles(roles))
+}
+
+// purging empties the cache after every successful change
+func (s *server) purging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		if s.cache != nil && r.Method != http.MethodGet && sw.status < 300 {
+			s.cache.purge()
+		}
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func toRoles(roles []authority.Role) []api.Role {
+	res := make([]api.Role, len(roles))
+	for i, role := range roles {
+		res[i] = api.Role{Name: role.Name, Slug: role.Slug}
+	}
+	return res
+}
+
+func toPermissions(perms []authority.Permission) []api.Permission {
+	res := make([]api.Permission, len(perms))
+	for i, perm := range perms {
+		res[i] = api.Permission{Name: perm.Name, Slug: perm.Slug}
+	}
+	return res
+}
+
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, authority.ErrRoleNotFound):
+		return CodeRoleNotFound
+	case errors.Is(err, authority.ErrPermissionNotFound):
+		return CodePermissionNotFound
+	}
+	return CodeInternal
+}
+
+// writeError maps the authority errors to http status codes
+// the unexpected errors are logged and answered with a generic message
+func (s *server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code := errorCode(err)
+	if code == CodeInternal {
+		s.logger.ErrorContext(r.Context(), "authority server request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "internal error", Code: code})
+		return
+	}
+	writeJSON(w, http.StatusNotFound, ErrorResponse{Error: err.Error(), Code: code})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// cache keeps check results for a limited time
+type cache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	result  Result
+	expires time.Time
+}
+
+func newCache(ttl time.Duration, size int) *cache {
+	return &cache{ttl: ttl, size: size, entries: map[string]cacheEntry{}}
+}
+
+func cacheKey(c Check) string {
+	return strings.Join([]string{c.UserID, c.Role, c.Permission}, "\x00")
+}
+
+func (c *cache) get(check Check) (Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[cacheKey(check)]
+	if !ok || time.Now().After(e.expires) {
+		return Result{}, false
+	}
+	return e.result, true
+}
+
+func (c *cache) put(check Check, res Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= c.size {
+		for key, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	if len(c.entries) >= c.size {
+		// still full, drop an arbitrary entry
+		for key := range c.entries {
+			delete(c.entries, key)
+			break
+		}
+	}
+	c.entries[cacheKey(check)] = cacheEntry{result: res, expires: now.Add(c.ttl)}
+}
+
+func (c *cache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]cacheEntry{}
+}
@@ -0,0 +1,193 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harranali/authority"
+	"github.com/harranali/authority/internal/testdb"
+	"github.com/harranali/authority/server"
+)
+
+func setup(t *testing.T, opts server.Options) (*authority.Authority, *httptest.Server) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           testdb.SQLite(t),
+	})
+	auth.CreateRole(authority.Role{Name: "Role A", Slug: "role-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission A", Slug: "permission-a"})
+	auth.CreatePermission(authority.Permission{Name: "Permission B", Slug: "permission-b"})
+	auth.AssignPermissionsToRole("role-a", []string{"permission-a"})
+	auth.AssignRoleToUser(1, "role-a")
+
+	opts.Authority = auth
+	srv := httptest.NewServer(server.New(opts))
+	t.Cleanup(srv.Close)
+
+	return auth, srv
+}
+
+func check(t *testing.T, srv *httptest.Server, checks ...server.Check) []server.Result {
+	body, _ := json.Marshal(server.CheckRequest{Checks: checks})
+	res, err := http.Post(srv.URL+"/v1/check", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal("failed to post the checks", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatal("unexpected status", res.StatusCode)
+	}
+	var out server.CheckResponse
+	json.NewDecoder(res.Body).Decode(&out)
+	return out.Results
+}
+
+func TestBatchCheck(t *testing.T) {
+	_, srv := setup(t, server.Options{})
+
+	results := check(t, srv,
+		server.Check{UserID: "1", Permission: "permission-a"},
+		server.Check{UserID: "1", Permission: "permission-b"},
+		server.Check{UserID: "1", Role: "role-a"},
+		server.Check{Role: "role-a", Permission: "permission-a"},
+		server.Check{UserID: "1", Permission: "permission-x"},
+		server.Check{UserID: "1"},
+	)
+	expected := []server.Result{
+		{Allowed: true},
+		{Allowed: false},
+		{Allowed: true},
+		{Allowed: true},
+		{Error: authority.ErrPermissionNotFound.Error(), Code: server.CodePermissionNotFound},
+		{Error: "expected two of user_id, role and permission", Code: server.CodeInvalid},
+	}
+	if len(results) != len(expected) {
+		t.Fatal("unexpected results", results)
+	}
+	for i := range expected {
+		if results[i] != expected[i] {
+			t.Errorf("check %v: expected %+v, got %+v", i, expected[i], results[i])
+		}
+	}
+
+	res, _ := http.Post(srv.URL+"/v1/check", "application/json", strings.NewReader(`{"checks": 1}`))
+	if res.StatusCode != http.StatusBadRequest {
+		t.Error("expected a bad request for an invalid body, got", res.StatusCode)
+	}
+}
+
+func TestCache(t *testing.T) {
+	auth, srv := setup(t, server.Options{CacheTTL: time.Hour, Management: true})
+
+	c := server.Check{UserID: "2", Permission: "permission-a"}
+	if check(t, srv, c)[0].Allowed {
+		t.Fatal("the user should not have the permission yet")
+	}
+
+	// changes made directly are not seen until the entry expires
+	auth.AssignRoleToUser(2, "role-a")
+	if check(t, srv, c)[0].Allowed {
+		t.Error("expected the cached result")
+	}
+
+	// changes made through the management api purge the cache
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/api/users/2/roles/role-a", nil)
+	http.DefaultClient.Do(req)
+	res, _ := http.Post(srv.URL+"/api/users/2/roles", "application/json", strings.NewReader(`{"role": "role-a"}`))
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatal("unexpected status assigning the role", res.StatusCode)
+	}
+	if !check(t, srv, c)[0].Allowed {
+		t.Error("expected the cache to be purged")
+	}
+}
+
+func TestManagementActor(t *testing.T) {
+	_, srv := setup(t, server.Options{
+		Management: true,
+		ManagementActor: func(r *http.Request) (interface{}, bool) {
+			id := r.Header.Get("X-User")
+			return id, id != ""
+		},
+	})
+
+	post := func(user string) int {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/users/2/roles", strings.NewReader(`{"role": "role-a"}`))
+		req.Header.Set("X-User", user)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("failed to post", err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := post(""); code != http.StatusUnauthorized {
+		t.Error("expected a change without a caller to be unauthorized, got", code)
+	}
+	if code := post("1"); code != http.StatusForbidden {
+		t.Error("expected a change by a caller without the meta-permission to be forbidden, got", code)
+	}
+}
+
+// lookups counts the cache lookups by result
+type lookups struct {
+	hits, misses int
+}
+
+func (l *lookups) ObserveCacheLookup(hit bool) {
+	if hit {
+		l.hits++
+	} else {
+		l.misses++
+	}
+}
+
+func TestCacheMetrics(t *testing.T) {
+	l := &lookups{}
+	_, srv := setup(t, server.Options{CacheTTL: time.Hour, CacheMetrics: l})
+
+	c := server.Check{UserID: "1", Permission: "permission-a"}
+	check(t, srv, c)
+	check(t, srv, c, c)
+	if l.hits != 2 || l.misses != 1 {
+		t.Error("unexpected cache lookups", l)
+	}
+}
+
+func TestQueries(t *testing.T) {
+	_, srv := setup(t, server.Options{})
+
+	queries := map[string]string{
+		"/v1/roles":                                     `[{"name":"Role A","slug":"role-a"}]`,
+		"/v1/permissions":                               `[{"name":"Permission A","slug":"permission-a"},{"name":"Permission B","slug":"permission-b"}]`,
+		"/v1/roles/role-a/permissions":                  `[{"name":"Permission A","slug":"permission-a"}]`,
+		"/v1/users/1/roles":                             `[{"name":"Role A","slug":"role-a"}]`,
+		"/v1/roles/role-a/users":                        `["1"]`,
+		"/v1/explain?user_id=1&permission=permission-a": `{"user_id":"1","permission":"permission-a","allowed":true,"roles":[{"name":"Role A","slug":"role-a"}]}`,
+	}
+	for path, expected := range queries {
+		res, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal("failed to get", path, err)
+		}
+		var buf bytes.Buffer
+		buf.ReadFrom(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || strings.TrimSpace(buf.String()) != expected {
+			t.Errorf("GET %v: unexpected response %v %v", path, res.StatusCode, buf.String())
+		}
+	}
+
+	res, _ := http.Get(srv.URL + "/v1/roles/role-x/permissions")
+	if res.StatusCode != http.StatusNotFound {
+		t.Error("expected an unknown role to be not found, got", res.StatusCode)
+	}
+	if res, _ := http.Get(srv.URL + "/api/roles"); res.StatusCode != http.StatusNotFound {
+		t.Error("expected the management api to be disabled by default, got", res.StatusCode)
+	}
+}
@@ -0,0 +1,274 @@
+package authority
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSoDConstraintExists   = errors.New("separation of duties constraint already exists")
+	ErrSoDConstraintNotFound = errors.New("separation of duties constraint not found")
+	ErrSoDViolation          = errors.New("separation of duties violation")
+)
+
+// The database model of a separation of duties constraint
+// a user cannot hold both roles of a constraint
+type SoDConstraint struct {
+	ID                uint // Unique id (it gets set automatically by the database)
+	RoleID            uint // The role id, the lower id of the two roles
+	ConflictingRoleID uint // The id of the role excluded by the first one
+}
+
+// TableName sets the table name
+func (c SoDConstraint) TableName() string {
+	return auth.TablesPrefix + "sod_constraints"
+}
+
+// RoleConflict is a pair of mutually exclusive roles
+type RoleConflict struct {
+	Role            string // The role slug
+	ConflictingRole string // The slug of the role excluded by the first one
+}
+
+// SoDViolation is a user holding two mutually exclusive roles
+type SoDViolation struct {
+	UserID string // The user id
+	RoleConflict
+}
+
+// SoDError is returned when assigning a role would violate a separation of duties constraint
+// it wraps ErrSoDViolation
+type SoDError struct {
+	SoDViolation
+}
+
+func (e *SoDError) Error() string {
+	return fmt.Sprintf("%v: user '%v' holds role '%v' which excludes role '%v'", ErrSoDViolation, e.UserID, e.ConflictingRole, e.Role)
+}
+
+func (e *SoDError) Unwrap() error {
+	return ErrSoDViolation
+}
+
+// Declares two roles as mutually exclusive, a user cannot be assigned both of them
+// existing assignments are not changed, use ValidateSoD to find the users holding both roles
+// it returns an error in case of any
+// in case any of the roles does not exists, an error is returned
+// in case the roles are already mutually exclusive, an error is returned
+func (a *Authority) AddSoDConstraint(roleSlug string, conflictingRoleSlug string) (err error) {
+	a, op := a.begin("AddSoDConstraint", AttrRole.String(roleSlug), AttrConflictingRole.String(conflictingRoleSlug))
+	defer func() { op.mutated(err) }()
+
+	roleID, conflictingRoleID, err := a.sodRoleIDs(roleSlug, conflictingRoleSlug)
+	if err != nil {
+		return err
+	}
+
+	var c SoDConstraint
+	res := a.DB.Where("role_id = ?", roleID).Where("conflicting_role_id = ?", conflictingRoleID).First(&c)
+	if res.Error == nil {
+		return fmt.Errorf("%w: '%v', '%v'", ErrSoDConstraintExists, roleSlug, conflictingRoleSlug)
+	}
+	if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return res.Error
+	}
+
+	return a.write(func(db *gorm.DB) ([]Event, error) {
+		if err := db.Create(&SoDConstraint{RoleID: roleID, ConflictingRoleID: conflictingRoleID}).Error; err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventSoDAdded, Role: roleSlug, ConflictingRole: conflictingRoleSlug}}, nil
+	})
+}
+
+// Removes the separation of duties constraint between two roles
+// it returns an error in case of any
+// in case any of the roles does not exists, an error is returned
+// in case the roles are not mutually exclusive, an error is returned
+func (a *Authority) RemoveSoDConstraint(roleSlug string, conflictingRoleSlug string) (err error) {
+	a, op := a.begin("RemoveSoDConstraint", AttrRole.String(roleSlug), AttrConflictingRole.String(conflictingRoleSlug))
+	defer func() { op.mutated(err) }()
+
+	roleID, conflictingRoleID, err := a.sodRoleIDs(roleSlug, conflictingRoleSlug)
+	if err != nil {
+		return err
+	}
+
+	return a.write(func(db *gorm.DB) ([]Event, error) {
+		res := db.Where("role_id = ?", roleID).Where("conflicting_role_id = ?", conflictingRoleID).Delete(SoDConstraint{})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil, fmt.Errorf("%w: '%v', '%v'", ErrSoDConstraintNotFound, roleSlug, conflictingRoleSlug)
+		}
+		return []Event{{Type: EventSoDRemoved, Role: roleSlug, ConflictingRole: conflictingRoleSlug}}, nil
+	})
+}
+
+// Returns all separation of duties constraints, ordered by role slug
+// it returns an error in case of any
+func (a *Authority) GetSoDConstraints() (conflicts []RoleConflict, err error) {
+	a, op := a.begin("GetSoDConstraints")
+	defer func() { op.end(err) }()
+
+	var constraints []SoDConstraint
+	if res := a.DB.Find(&constraints); res.Error != nil {
+		return nil, res.Error
+	}
+	slugs, err := roleSlugs(a.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range constraints {
+		conflict := RoleConflict{Role: slugs[c.RoleID], ConflictingRole: slugs[c.ConflictingRoleID]}
+		if conflict.Role > conflict.ConflictingRole {
+			conflict.Role, conflict.ConflictingRole = conflict.ConflictingRole, conflict.Role
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Role != conflicts[j].Role {
+			return conflicts[i].Role < conflicts[j].Role
+		}
+		return conflicts[i].ConflictingRole < conflicts[j].ConflictingRole
+	})
+
+	return conflicts, nil
+}
+
+// Reports the users holding mutually exclusive roles, ordered by user id
+// it returns an error in case of any
+func (a *Authority) ValidateSoD() (violations []SoDViolation, err error) {
+	a, op := a.begin("ValidateSoD")
+	defer func() { op.end(err) }()
+
+	return sodViolations(a.DB, nil)
+}
+
+// sodRoleIDs returns the ids of the roles of a constraint, the lower one first
+func (a *Authority) sodRoleIDs(roleSlug string, conflictingRoleSlug string) (uint, uint, error) {
+	if roleSlug == conflictingRoleSlug {
+		return 0, 0, fmt.Errorf("role '%v' cannot exclude itself", roleSlug)
+	}
+
+	var ids [2]uint
+	for i, slug := range []string{roleSlug, conflictingRoleSlug} {
+		var role Role
+		res := a.DB.Where("slug = ?", slug).First(&role)
+		if res.Error != nil {
+			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+				return 0, 0, fmt.Errorf("%w: '%v'", ErrRoleNotFound, slug)
+			}
+			return 0, 0, res.Error
+		}
+		ids[i] = role.ID
+	}
+	if ids[0] > ids[1] {
+		ids[0], ids[1] = ids[1], ids[0]
+	}
+
+	return ids[0], ids[1], nil
+}
+
+// checkSoD returns a SoDError if assigning the role to the user violates a constraint
+// it locks the user so concurrent assignments to the user wait for the transaction
+func checkSoD(db *gorm.DB, userID string, role Role) error {
+	var constraints []SoDConstraint
+	res := db.Where("role_id = ? OR conflicting_role_id = ?", role.ID, role.ID).Find(&constraints)
+	if res.Error != nil || len(constraints) == 0 {
+		return res.Error
+	}
+	var excludedIDs []uint
+	for _, c := range constraints {
+		if c.RoleID == role.ID {
+			excludedIDs = append(excludedIDs, c.ConflictingRoleID)
+		} else {
+			excludedIDs = append(excludedIDs, c.RoleID)
+		}
+	}
+
+	if err := lockUser(db, userID); err != nil {
+		return err
+	}
+	var held UserRole
+	res = db.Scopes(active).Where("user_id = ?", userID).Where("role_id IN (?)", excludedIDs).Order("role_id").First(&held)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	var conflicting Role
+	if res := db.Where("id = ?", held.RoleID).First(&conflicting); res.Error != nil {
+		return res.Error
+	}
+
+	return &SoDError{SoDViolation{UserID: userID, RoleConflict: RoleConflict{Role: role.Slug, ConflictingRole: conflicting.Slug}}}
+}
+
+// sodViolations returns the users holding mutually exclusive roles
+// when userIDs is not nil only these users are checked
+func sodViolations(db *gorm.DB, userIDs []string) ([]SoDViolation, error) {
+	var constraints []SoDConstraint
+	if res := db.Find(&constraints); res.Error != nil {
+		return nil, res.Error
+	}
+	if len(constraints) == 0 {
+		return nil, nil
+	}
+
+	query := db.Scopes(active).Order("user_id")
+	if userIDs != nil {
+		query = query.Where("user_id IN (?)", userIDs)
+	}
+	var userRoles []UserRole
+	if res := query.Find(&userRoles); res.Error != nil {
+		return nil, res.Error
+	}
+	held := map[string]map[uint]bool{}
+	var users []string
+	for _, ur := range userRoles {
+		if held[ur.UserID] == nil {
+			held[ur.UserID] = map[uint]bool{}
+			users = append(users, ur.UserID)
+		}
+		held[ur.UserID][ur.RoleID] = true
+	}
+	slugs, err := roleSlugs(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var violations []SoDViolation
+	for _, userID := range users {
+		for _, c := range constraints {
+			if held[userID][c.RoleID] && held[userID][c.ConflictingRoleID] {
+				violations = append(violations, SoDViolation{
+					UserID:       userID,
+					RoleConflict: RoleConflict{Role: slugs[c.RoleID], ConflictingRole: slugs[c.ConflictingRoleID]},
+				})
+			}
+		}
+	}
+
+	return violations, nil
+}
+
+// roleSlugs returns the slugs of all roles by id
+func roleSlugs(db *gorm.DB) (map[uint]string, error) {
+	var roles []Role
+	if res := db.Find(&roles); res.Error != nil {
+		return nil, res.Error
+	}
+	slugs := map[uint]string{}
+	for _, role := range roles {
+		slugs[role.ID] = role.Slug
+	}
+
+	return slugs, nil
+}
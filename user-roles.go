@@ -1,13 +1,42 @@
 package authority
 
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
 // The link between the users and roles
 type UserRole struct {
-	ID     uint   // Unique id (it gets set automatically by the database)
-	UserID string // The user id
-	RoleID uint   // The role id
+	ID        uint       // Unique id (it gets set automatically by the database)
+	UserID    string     // The user id
+	RoleID    uint       // The role id
+	ExpiresAt *time.Time `gorm:"index"` // The assignment is ignored from this time, nil means it never expires
 }
 
 // TableName sets the table name
 func (u UserRole) TableName() string {
 	return auth.TablesPrefix + "user_roles"
 }
+
+// The database model of the lock of a user
+// the changes of the roles of a user lock its row, so the checks spanning them see the concurrent changes
+type UserLock struct {
+	UserID string `gorm:"primaryKey"` // The user id
+}
+
+// TableName sets the table name
+func (u UserLock) TableName() string {
+	return auth.TablesPrefix + "user_locks"
+}
+
+// lockUser locks the user until the end of the transaction, creating its row if needed
+func lockUser(db *gorm.DB, userID string) error {
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&UserLock{UserID: userID}).Error; err != nil {
+		return err
+	}
+
+	var lock UserLock
+	return db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&lock).Error
+}
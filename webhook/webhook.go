@@ -0,0 +1,296 @@
+// Package webhook delivers the change events to http endpoints
+//
+// the sink of this package plugs into an outbox dispatcher, every event is
+// posted as signed json to the endpoints subscribed to it. every delivery of
+// the outbox makes a single attempt, a failing endpoint fails the delivery so
+// the outbox retries the event with its backoff. after the last attempt the
+// event is stored in the dead letter table, from where it can be replayed
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/harranali/authority"
+	"gorm.io/gorm"
+)
+
+// The headers of the webhook requests
+const (
+	HeaderEvent     = "X-Authority-Event"     // The event type
+	HeaderDelivery  = "X-Authority-Delivery"  // The outbox event id, the same across the retries
+	HeaderTimestamp = "X-Authority-Timestamp" // The unix time of the request
+	HeaderSignature = "X-Authority-Signature" // "sha256=" followed by the hex hmac of the timestamp, a dot and the body
+)
+
+var (
+	ErrInvalidSignature   = errors.New("invalid webhook signature")
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+)
+
+// DefaultTolerance is the maximum age of a request accepted by Verify when no tolerance is given
+const DefaultTolerance = 5 * time.Minute
+
+// Endpoint is a url receiving the events
+type Endpoint struct {
+	URL    string                // The url the events are posted to
+	Secret []byte                // The key of the hmac signature
+	Events []authority.EventType // The event types sent to the endpoint, empty sends all of them
+	Roles  []string              // Only send the events of these roles, empty sends the events of all roles
+}
+
+// Options has the options for initiating the sink
+type Options struct {
+	Authority   *authority.Authority // The authority instance, its database stores the dead letters
+	Endpoints   []Endpoint           // The endpoints receiving the events
+	HTTPClient  *http.Client         // The client posting the events, defaults to a client with a 10s timeout
+	MaxAttempts int                  // The number of deliveries of an event before it is dead lettered, defaults to 5
+}
+
+// Payload is the json body of the webhook requests
+type Payload struct {
+	ID uint `json:"id"` // The outbox event id
+	authority.Event
+}
+
+// DeadLetter is an event an endpoint failed to receive
+type DeadLetter struct {
+	ID         uint       // Unique id (it gets set automatically by the database)
+	EventID    uint       // The outbox event id
+	EventType  string     // The event type
+	URL        string     // The endpoint url
+	Payload    string     // The json body
+	Attempts   int        // The number of failed attempts
+	LastError  string     // The error of the last attempt
+	CreatedAt  time.Time  // The time the event was dead lettered
+	ReplayedAt *time.Time `gorm:"index"` // The time of the successful replay, nil until then
+}
+
+// Sink posts the events to the endpoints, it implements outbox.Sink
+type Sink struct {
+	opts  Options
+	table string
+}
+
+// New returns the sink and creates its dead letter table
+// it returns an error in case of any
+func New(opts Options) (*Sink, error) {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+
+	s := &Sink{opts: opts, table: opts.Authority.TablesPrefix + "webhook_dead_letters"}
+	if err := s.db().AutoMigrate(&DeadLetter{}); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Sink) db() *gorm.DB {
+	return s.opts.Authority.DB.Table(s.table)
+}
+
+// Deliver posts the event once to every endpoint subscribed to it
+// a failing endpoint fails the delivery, so the outbox retries the event after its backoff
+// and the endpoints which received it get it again with the same delivery id
+// on the last attempt the failing endpoints get a dead letter instead
+// it returns an error in case of any
+func (s *Sink) Deliver(ctx context.Context, e authority.OutboxEvent) error {
+	body, err := json.Marshal(Payload{ID: e.ID, Event: e.Event()})
+	if err != nil {
+		return err
+	}
+
+	attempt := e.Attempts + 1
+	var failed error
+	for _, endpoint := range s.opts.Endpoints {
+		if !endpoint.subscribed(e) {
+			continue
+		}
+		err := s.send(ctx, endpoint, e.ID, e.Type, body)
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt < s.opts.MaxAttempts {
+			if failed == nil {
+				failed = fmt.Errorf("endpoint '%v': %w", endpoint.URL, err)
+			}
+			continue
+		}
+		dead := DeadLetter{
+			EventID:   e.ID,
+			EventType: e.Type,
+			URL:       endpoint.URL,
+			Payload:   string(body),
+			Attempts:  attempt,
+			LastError: err.Error(),
+		}
+		if res := s.db().WithContext(ctx).Create(&dead); res.Error != nil {
+			return res.Error
+		}
+	}
+
+	return failed
+}
+
+func (e Endpoint) subscribed(event authority.OutboxEvent) bool {
+	typeMatches := len(e.Events) == 0
+	for _, t := range e.Events {
+		if string(t) == event.Type {
+			typeMatches = true
+		}
+	}
+	roleMatches := len(e.Roles) == 0
+	for _, role := range e.Roles {
+		if role == event.Role {
+			roleMatches = true
+		}
+	}
+
+	return typeMatches && roleMatches
+}
+
+// send posts the body once, any status other than 2xx is an error
+func (s *Sink) send(ctx context.Context, endpoint Endpoint, id uint, eventType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(id), 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(endpoint.Secret, timestamp, body))
+
+	res, err := s.opts.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %v", res.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign returns the signature header of a request
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a received request
+// the tolerance is the maximum difference between the timestamp of the request and now, zero or less uses DefaultTolerance
+// it returns ErrInvalidSignature if the signature does not match or the timestamp is too old or too far in the future
+func Verify(secret []byte, header http.Header, body []byte, tolerance time.Duration) error {
+	timestamp := header.Get(HeaderTimestamp)
+	expected := Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(header.Get(HeaderSignature))) {
+		return ErrInvalidSignature
+	}
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// DeadLetters returns the dead letters not replayed yet, oldest first
+// it returns an error in case of any
+func (s *Sink) DeadLetters() ([]DeadLetter, error) {
+	var dead []DeadLetter
+	res := s.db().Where("replayed_at IS NULL").Order("id").Find(&dead)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	return dead, nil
+}
+
+// Replay posts a dead letter to its endpoint again, with the original payload and delivery id
+// a successful replay marks the dead letter as replayed
+// it returns ErrDeadLetterNotFound if the dead letter does not exist or was already replayed
+// it returns an error if its endpoint is no longer configured
+// it returns an error in case of any
+func (s *Sink) Replay(ctx context.Context, id uint) error {
+	var dead DeadLetter
+	res := s.db().WithContext(ctx).Where("id = ?", id).Where("replayed_at IS NULL").First(&dead)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return ErrDeadLetterNotFound
+		}
+		return res.Error
+	}
+
+	var endpoint *Endpoint
+	for i := range s.opts.Endpoints {
+		if s.opts.Endpoints[i].URL == dead.URL {
+			endpoint = &s.opts.Endpoints[i]
+			break
+		}
+	}
+	if endpoint == nil {
+		return fmt.Errorf("endpoint '%v' is not configured", dead.URL)
+	}
+	if err := s.send(ctx, *endpoint, dead.EventID, dead.EventType, []byte(dead.Payload)); err != nil {
+		s.db().WithContext(ctx).Where("id = ?", dead.ID).Updates(map[string]interface{}{
+			"attempts":   dead.Attempts + 1,
+			"last_error": err.Error(),
+		})
+		return err
+	}
+
+	return s.db().WithContext(ctx).Where("id = ?", dead.ID).Update("replayed_at", time.Now()).Error
+}
+
+// ReplayAll replays every dead letter
+// it returns the number of replayed dead letters and the first error
+func (s *Sink) ReplayAll(ctx context.Context) (int, error) {
+	dead, err := s.DeadLetters()
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	var firstErr error
+	for _, d := range dead {
+		if err := s.Replay(ctx, d.ID); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		replayed++
+	}
+
+	return replayed, firstErr
+}
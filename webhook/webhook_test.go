@@ -0,0 +1,190 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/harranali/authority"
+	"github.com/harranali/authority/internal/testdb"
+	"github.com/harranali/authority/outbox"
+	"github.com/harranali/authority/webhook"
+)
+
+var secret = []byte("secret")
+
+// receiver records the verified payloads and fails while failing is set
+type receiver struct {
+	mu       sync.Mutex
+	failing  bool
+	requests int
+	payloads []webhook.Payload
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests++
+	if rc.failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	if err := webhook.Verify(secret, r.Header, body, time.Minute); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var p webhook.Payload
+	json.Unmarshal(body, &p)
+	rc.payloads = append(rc.payloads, p)
+}
+
+func setup(t *testing.T, endpoints ...webhook.Endpoint) (*authority.Authority, *webhook.Sink, *outbox.Dispatcher) {
+	auth := authority.New(authority.Options{
+		TablesPrefix: "authority_",
+		DB:           testdb.SQLite(t),
+		Outbox:       true,
+	})
+	sink, err := webhook.New(webhook.Options{
+		Authority:   auth,
+		Endpoints:   endpoints,
+		MaxAttempts: 3,
+	})
+	if err != nil {
+		t.Fatal("an error was not expected while creating the sink", err)
+	}
+	d := outbox.New(outbox.Options{Authority: auth, Sink: sink})
+
+	return auth, sink, d
+}
+
+func TestDeliver(t *testing.T) {
+	all := &receiver{}
+	admins := &receiver{}
+	allSrv := httptest.NewServer(all)
+	defer allSrv.Close()
+	adminsSrv := httptest.NewServer(admins)
+	defer adminsSrv.Close()
+
+	auth, _, d := setup(t,
+		webhook.Endpoint{URL: allSrv.URL, Secret: secret},
+		webhook.Endpoint{URL: adminsSrv.URL, Secret: secret, Events: []authority.EventType{authority.EventRoleAssigned}, Roles: []string{"admin"}},
+	)
+	auth.CreateRole(authority.Role{Name: "Admin", Slug: "admin"})
+	auth.CreateRole(authority.Role{Name: "Editor", Slug: "editor"})
+	auth.AssignRoleToUser(1, "editor")
+	auth.AssignRoleToUser(2, "admin")
+
+	if _, err := d.Dispatch(context.Background()); err != nil {
+		t.Fatal("an error was not expected while dispatching", err)
+	}
+	if len(all.payloads) != 4 {
+		t.Error("expected every event to be posted", all.payloads)
+	}
+	if len(admins.payloads) != 1 || admins.payloads[0].UserID != "2" || admins.payloads[0].Type != authority.EventRoleAssigned || admins.payloads[0].ID == 0 {
+		t.Error("expected only the admin assignment to be posted", admins.payloads)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	header := http.Header{}
+	header.Set(webhook.HeaderTimestamp, timestamp)
+	header.Set(webhook.HeaderSignature, webhook.Sign(secret, timestamp, body))
+
+	if err := webhook.Verify(secret, header, body, 0); err != nil {
+		t.Error("expected the signature to be valid", err)
+	}
+	if err := webhook.Verify([]byte("other"), header, body, 0); !errors.Is(err, webhook.ErrInvalidSignature) {
+		t.Error("expected ErrInvalidSignature for another secret", err)
+	}
+	if err := webhook.Verify(secret, header, []byte(`{"id":2}`), 0); !errors.Is(err, webhook.ErrInvalidSignature) {
+		t.Error("expected ErrInvalidSignature for another body", err)
+	}
+
+	// an old request is refused, with the default tolerance too
+	header.Set(webhook.HeaderTimestamp, "1700000000")
+	header.Set(webhook.HeaderSignature, webhook.Sign(secret, "1700000000", body))
+	if err := webhook.Verify(secret, header, body, time.Minute); !errors.Is(err, webhook.ErrInvalidSignature) {
+		t.Error("expected ErrInvalidSignature for an old request", err)
+	}
+	if err := webhook.Verify(secret, header, body, 0); !errors.Is(err, webhook.ErrInvalidSignature) {
+		t.Error("expected ErrInvalidSignature for an old request with the default tolerance", err)
+	}
+
+	// so is a request from the future
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	header.Set(webhook.HeaderTimestamp, future)
+	header.Set(webhook.HeaderSignature, webhook.Sign(secret, future, body))
+	if err := webhook.Verify(secret, header, body, time.Minute); !errors.Is(err, webhook.ErrInvalidSignature) {
+		t.Error("expected ErrInvalidSignature for a request from the future", err)
+	}
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	rc := &receiver{failing: true}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	auth, sink, d := setup(t, webhook.Endpoint{URL: srv.URL, Secret: secret, Events: []authority.EventType{authority.EventRoleAssigned}})
+	auth.CreateRole(authority.Role{Name: "Admin", Slug: "admin"})
+	auth.AssignRoleToUser(1, "admin")
+
+	// a delivery makes a single attempt, the outbox retries the event
+	d.Dispatch(context.Background())
+	if rc.requests != 1 {
+		t.Error("expected a single attempt", rc.requests)
+	}
+	if pending, _ := d.Pending(); pending != 1 {
+		t.Error("expected the outbox to retry the event", pending)
+	}
+	if dead, _ := sink.DeadLetters(); len(dead) != 0 {
+		t.Error("expected no dead letter before the last attempt", dead)
+	}
+
+	// after the last attempt the event is dead lettered and the outbox moves on
+	for i := 0; i < 2; i++ {
+		auth.DB.Model(&authority.OutboxEvent{}).Where("delivered_at IS NULL").Update("next_attempt_at", time.Now())
+		d.Dispatch(context.Background())
+	}
+	if rc.requests != 3 {
+		t.Error("expected three attempts", rc.requests)
+	}
+	if pending, _ := d.Pending(); pending != 0 {
+		t.Error("expected the outbox to move on", pending)
+	}
+	dead, err := sink.DeadLetters()
+	if err != nil {
+		t.Fatal("an error was not expected while listing the dead letters", err)
+	}
+	if len(dead) != 1 || dead[0].URL != srv.URL || dead[0].Attempts != 3 || dead[0].LastError != "unexpected status 503" {
+		t.Fatal("unexpected dead letters", dead)
+	}
+
+	if err := sink.Replay(context.Background(), dead[0].ID); err == nil {
+		t.Error("expected the replay to fail while the endpoint fails")
+	}
+
+	rc.failing = false
+	n, err := sink.ReplayAll(context.Background())
+	if err != nil || n != 1 {
+		t.Error("expected the dead letter to be replayed", n, err)
+	}
+	if len(rc.payloads) != 1 || rc.payloads[0].ID != dead[0].EventID || rc.payloads[0].Role != "admin" {
+		t.Error("expected the original payload", rc.payloads)
+	}
+	if dead, _ := sink.DeadLetters(); len(dead) != 0 {
+		t.Error("expected no dead letters left", dead)
+	}
+	if err := sink.Replay(context.Background(), dead[0].ID); !errors.Is(err, webhook.ErrDeadLetterNotFound) {
+		t.Error("expected ErrDeadLetterNotFound for a replayed dead letter", err)
+	}
+}